@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/adevinta/k8s-traffic-controller/pkg/admin"
 	"github.com/adevinta/k8s-traffic-controller/pkg/controllers"
 	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
@@ -17,36 +19,103 @@ import (
 	logruslogr "github.com/adevinta/go-log-toolkit"
 )
 
+const (
+	backendSetupTimeout     = 30 * time.Second
+	weightReconcileInterval = 20 * time.Second
+)
+
 var (
 	scheme   = controllers.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
 )
 
+// stringList is a flag that can be repeated. Empty values are ignored.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	if value != "" {
+		*l = append(*l, value)
+	}
+	return nil
+}
+
 func main() {
 	var metricsAddr string
 	var clusterName string
 	var awsRegion string
-	var bindingDomain string
+	var bindingDomains stringList
+	var excludedHosts stringList
 	var backendType string
 	var annotationFilter string
+	var labelFilter string
+	var ingressClass string
+	var namespaceSelector string
 	var enableLeaderElection bool
 	var devMode bool
 	var initialWeight int
 	var tableName string
 	var awsHealthCheckID string
 	var annotationPrefix string
+	var maxWeightChangePerInterval int
+	var maxWeightChange int
+	var lastKnownGoodConfigMap string
+	var lastKnownGoodNamespace string
+	var backendOutagePolicy string
+	var backendOutageGracePeriod time.Duration
+	var backendOutageFallbackWeight int
+	var auditTableName string
+	var auditEvents bool
+	var adminAddr string
+	var adminTokenFile string
+	var dryRun bool
+	var includeTLSHosts bool
+	var outOfScopeAction string
+	var controllerID string
+	var hostConflictPolicy string
+	var recordTTL int64
+	var setIdentifierTemplate string
+	var previousSetIdentifierTemplate string
+	var orphanSweepInterval time.Duration
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&clusterName, "cluster-name", "", "The name of the cluster")
 	flag.StringVar(&awsRegion, "aws-region", "eu-west-1", "The AWS Region for route53 provider")
-	flag.StringVar(&bindingDomain, "binding-domain", "", "The domain to bind for create DNS entries")
+	flag.Var(&bindingDomains, "binding-domain", "A domain to create DNS entries for, as DOMAIN[,ttl=SECONDS][,record-type=CNAME|A][,health-check-id=ID|none]. Can be repeated. All the hosts are bound when empty")
+	flag.Var(&excludedHosts, "binding-domain-exclude", "A host, with its subdomains, or a glob pattern like *-internal.foo.io, never bound. Can be repeated")
 	flag.StringVar(&backendType, "backend-type", "fake", "The config backend to use. By default uses fake")
-	flag.StringVar(&annotationFilter, "annotation-filter", "", "Given an annotation, filter which ingress objects react to")
+	flag.StringVar(&annotationFilter, "annotation-filter", "", "Label selector evaluated on the ingress annotations, like \"team in (a,b),!legacy\", to filter which ingress objects react to")
+	flag.StringVar(&labelFilter, "label-filter", "", "Label selector evaluated on the ingress labels to filter which ingress objects react to")
+	flag.StringVar(&ingressClass, "ingress-class", "", "Only react to the ingresses of this class, from spec.ingressClassName or the kubernetes.io/ingress.class annotation")
+	flag.StringVar(&namespaceSelector, "namespace-selector", "", "Label selector evaluated on the namespace labels to filter which ingress objects react to")
 	flag.StringVar(&tableName, "table-name", "traffic-controller", "table name to use when reading from dynamodb backend")
 	flag.StringVar(&awsHealthCheckID, "aws-health-check-id", "", "AWS route53 healthcheck id used, it can be only one.  set to \"\" to disable healthchecks")
 	flag.StringVar(&annotationPrefix, "annotation-prefix", "dns.adevinta.com", "The prefix for traffic-management annotations in ingress objects (e.g. dns.adevinta.io/traffic-weight)")
 
 	flag.IntVar(&initialWeight, "initial-weight", 0, "DNS weight for this cluster")
+	flag.IntVar(&maxWeightChangePerInterval, "max-weight-change-per-interval", 0, "Maximum weight change applied on each reconcile interval, bigger changes are applied in steps. 0 disables the limit")
+	flag.IntVar(&maxWeightChange, "max-weight-change", 0, "Maximum accepted weight change, bigger changes are rejected unless forced in the backend. 0 disables the limit")
+	flag.StringVar(&lastKnownGoodConfigMap, "last-known-good-configmap", "", "Name of the ConfigMap storing the last weight configuration read from the backend, used when the backend is unreachable at startup. Empty disables it")
+	flag.StringVar(&lastKnownGoodNamespace, "last-known-good-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the last known good ConfigMap. Defaults to the POD_NAMESPACE environment variable")
+	flag.StringVar(&backendOutagePolicy, "backend-outage-policy", string(trafficweight.OutagePolicyKeepLast), "What to do when the backend is unreachable for longer than --backend-outage-grace-period: \"keep-last\" or \"fallback\"")
+	flag.DurationVar(&backendOutageGracePeriod, "backend-outage-grace-period", 10*time.Minute, "How long the backend can be unreachable before applying the backend outage policy")
+	flag.IntVar(&backendOutageFallbackWeight, "backend-outage-fallback-weight", 0, "DNS weight applied when the backend is unreachable and --backend-outage-policy=fallback")
+	flag.StringVar(&auditTableName, "audit-table-name", "", "DynamoDB table where the weight changes are appended, with ClusterName as partition key and Time as sort key. Empty disables it")
+	flag.BoolVar(&auditEvents, "audit-events", false, "Emit the weight changes as Kubernetes events on the controller pod, identified by the POD_NAMESPACE and POD_NAME environment variables")
+	flag.StringVar(&adminAddr, "admin-addr", "", "The address the admin API binds to. Empty disables it")
+	flag.StringVar(&adminTokenFile, "admin-token-file", "", "File containing the bearer token required by the admin API")
+	flag.BoolVar(&includeTLSHosts, "include-tls-hosts", false, "Create DNS entries for the hosts of the ingresses TLS section too")
+	flag.StringVar(&controllerID, "controller-id", "", "Identifies this controller instance in the labels of the DNSEndpoints it owns. Defaults to the annotation prefix")
+	flag.Int64Var(&recordTTL, "record-ttl", 0, "TTL of the DNS records in seconds, overridden by the binding domains ttl, the record-ttl annotation and the weight backend. The external-dns default is used when 0")
+	flag.StringVar(&setIdentifierTemplate, "set-identifier-template", "", "Go template of the set identifier of the records, with the fields Cluster, Region, Namespace, Ingress and IngressClass, like \"{{.Cluster}}-{{.IngressClass}}\". Defaults to the cluster name")
+	flag.StringVar(&previousSetIdentifierTemplate, "previous-set-identifier-template", "", "The --set-identifier-template used before, like \"{{.Cluster}}\" for the default, whose records are kept with a weight of 0 while migrating. Empty deletes them")
+	flag.StringVar(&hostConflictPolicy, "host-conflict-policy", string(controllers.HostConflictMerge), "How hosts declared by several ingresses get a single record: \"merge\" the ingresses sharing a load balancer, or keep the \"oldest\" ingress")
+	flag.StringVar(&outOfScopeAction, "out-of-scope-action", string(controllers.OutOfScopeDelete), "What to do with the DNSEndpoint of an ingress no longer matching the filters nor the binding domains: \"delete\" or \"zero\" its weights")
+	flag.DurationVar(&orphanSweepInterval, "orphan-sweep-interval", 10*time.Minute, "How often the DNSEndpoints whose ingress no longer exists or is out of scope are released. 0 disables it")
+	flag.BoolVar(&dryRun, "dry-run", false, "Compute and log the changes to the DNSEndpoints without writing them, nor acknowledging the weights in the backend")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -56,66 +125,267 @@ func main() {
 
 	ctrl.SetLogger(logruslogr.NewLogr(logruslogr.DefaultLogger))
 
-	trafficweight.Store = trafficweight.StoreConfig{
-		DesiredWeight:    initialWeight,
-		CurrentWeight:    initialWeight,
-		AWSHealthCheckID: awsHealthCheckID,
+	domains := []controllers.BindingDomain{}
+	for _, value := range bindingDomains {
+		domain, err := controllers.ParseBindingDomain(value)
+		if err != nil {
+			setupLog.Error(err, "invalid binding domain")
+			os.Exit(1)
+		}
+		domains = append(domains, domain)
 	}
-
-	backend, err := trafficweight.NewBackend(backendType, clusterName, awsRegion, tableName, awsHealthCheckID, ctrl.Log.WithName("ConfigBackend"))
+	ingressAnnotationFilter, err := controllers.NewAnnotationFilter(annotationFilter)
 	if err != nil {
-		// Move this to log.Fatal with a proper logger
-		panic(err.Error())
+		setupLog.Error(err, "invalid annotation filter")
+		os.Exit(1)
 	}
-
-	desiredWeight, err := backend.ReadWeight()
+	ingressLabelFilter, err := controllers.NewSelector(labelFilter)
+	if err != nil {
+		setupLog.Error(err, "invalid label filter")
+		os.Exit(1)
+	}
+	ingressNamespaceSelector, err := controllers.NewSelector(namespaceSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid namespace selector")
+		os.Exit(1)
+	}
+	if controllerID == "" {
+		controllerID = annotationPrefix
+	}
+	if err := controllers.ValidateOwnershipLabel("controller id", controllerID); err != nil {
+		setupLog.Error(err, "invalid controller id")
+		os.Exit(1)
+	}
+	if err := controllers.ValidateOwnershipLabel("cluster name", clusterName); err != nil {
+		setupLog.Error(err, "invalid cluster name")
+		os.Exit(1)
+	}
+	if err := trafficweight.ValidateRecordTTL(recordTTL); err != nil {
+		setupLog.Error(err, "invalid record ttl")
+		os.Exit(1)
+	}
+	setIdentifier, err := controllers.NewSetIdentifierTemplate(setIdentifierTemplate)
+	if err != nil {
+		setupLog.Error(err, "invalid set identifier template")
+		os.Exit(1)
+	}
+	previousSetIdentifier, err := controllers.NewSetIdentifierTemplate(previousSetIdentifierTemplate)
+	if err != nil {
+		setupLog.Error(err, "invalid previous set identifier template")
+		os.Exit(1)
+	}
+	ingressHostConflictPolicy, err := controllers.ParseHostConflictPolicy(hostConflictPolicy)
+	if err != nil {
+		setupLog.Error(err, "invalid host conflict policy")
+		os.Exit(1)
+	}
+	ingressOutOfScopeAction, err := controllers.ParseOutOfScopeAction(outOfScopeAction)
 	if err != nil {
-		// Move this to log.Fatal with a proper logger
-		log.Fatal(err, "Unable to read desired weight from backend")
+		setupLog.Error(err, "invalid out of scope action")
+		os.Exit(1)
+	}
+	for _, pattern := range excludedHosts {
+		if err := controllers.ValidateExcludedHost(pattern); err != nil {
+			setupLog.Error(err, "invalid binding domain exclusion")
+			os.Exit(1)
+		}
 	}
 
-	// We do not do gradual changes for now. So current == desired
-	trafficweight.Store.DesiredWeight = desiredWeight
-	trafficweight.Store.CurrentWeight = desiredWeight
+	weightStore := trafficweight.NewWeightStore(trafficweight.StoreConfig{
+		DesiredWeight:    initialWeight,
+		CurrentWeight:    initialWeight,
+		AWSHealthCheckID: awsHealthCheckID,
+	})
 
-	backend.OnWeightUpdate(trafficweight.Store)
+	outageMode, err := trafficweight.ParseOutagePolicyMode(backendOutagePolicy)
+	if err != nil {
+		setupLog.Error(err, "invalid backend outage policy")
+		os.Exit(1)
+	}
+	outagePolicy := trafficweight.OutagePolicy{
+		Mode:           outageMode,
+		GracePeriod:    backendOutageGracePeriod,
+		FallbackWeight: backendOutageFallbackWeight,
+	}
 
+	leaderElectionID := "a5568bf5.dns.adevinta.com"
+	if dryRun {
+		// A dry-run instance must not take the lease of the controller it is compared with
+		leaderElectionID += "-dry-run"
+	}
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
 		Metrics: metricsserver.Options{
 			BindAddress: metricsAddr,
 		},
 		LeaderElection:   enableLeaderElection,
-		LeaderElectionID: "a5568bf5.dns.adevinta.com",
+		LeaderElectionID: leaderElectionID,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	// The manager cache is not started yet, read the last known good configuration straight from the API
+	lastKnownGood := trafficweight.NewLastKnownGoodStore(mgr.GetAPIReader(), mgr.GetClient(), lastKnownGoodNamespace, lastKnownGoodConfigMap)
+
+	ctx := ctrl.SetupSignalHandler()
+	setupCtx, cancelSetup := context.WithTimeout(ctx, backendSetupTimeout)
+	defer cancelSetup()
+
+	backend, err := trafficweight.NewBackend(setupCtx, backendType, clusterName, awsRegion, tableName, awsHealthCheckID, weightStore, ctrl.Log.WithName("ConfigBackend"), dryRun)
+	if err != nil {
+		setupLog.Error(err, "unable to create weight backend", "backend", backendType)
+		os.Exit(1)
+	}
+
+	desired, err := backend.ReadWeight(setupCtx)
+	if err != nil {
+		setupLog.Error(err, "Unable to read desired weight from backend")
+		store, lkgErr := lastKnownGood.Load(setupCtx)
+		switch {
+		case lkgErr == nil:
+			setupLog.Info("Using last known good weight configuration", "weight", store.DesiredWeight, "version", store.Version)
+			// The version is needed to acknowledge the weight once the backend is back
+			weightStore.Restore(store)
+		case outagePolicy.Mode == trafficweight.OutagePolicyFallback:
+			setupLog.Error(lkgErr, "Unable to read last known good weight configuration, using fallback weight", "weight", outagePolicy.FallbackWeight)
+			weightStore.Update(func(config *trafficweight.StoreConfig) {
+				config.DesiredWeight = outagePolicy.FallbackWeight
+				config.CurrentWeight = outagePolicy.FallbackWeight
+			})
+		default:
+			setupLog.Error(lkgErr, "Unable to read last known good weight configuration")
+			os.Exit(1)
+		}
+	} else {
+		// Start from the weight applied before the restart, the ConfigReconciler
+		// steps towards the desired weight within the weight change limits
+		weightStore.Follow(desired)
+	}
+
+	audit := trafficweight.AuditSinks{&trafficweight.LogAuditSink{Log: ctrl.Log.WithName("audit")}}
+	if auditTableName != "" && !dryRun {
+		sink, err := trafficweight.NewDynamoDBAuditSink(clusterName, awsRegion, auditTableName)
+		if err != nil {
+			setupLog.Error(err, "unable to create the audit table sink")
+			os.Exit(1)
+		}
+		audit = append(audit, sink)
+	}
+	if auditEvents && !dryRun {
+		audit = append(audit, trafficweight.NewEventAuditSink(mgr.GetEventRecorderFor("traffic-controller"), os.Getenv("POD_NAMESPACE"), os.Getenv("POD_NAME")))
+	}
+
 	events := make(chan event.GenericEvent)
+	propagation := trafficweight.NewPropagationTracker()
 
-	if err = (&controllers.IngressReconciler{
-		Client:           mgr.GetClient(),
-		Log:              ctrl.Log.WithName("controllers").WithName("Ingress"),
-		Scheme:           mgr.GetScheme(),
-		ClusterName:      clusterName,
-		AWSRegion:        awsRegion,
-		DevMode:          devMode,
-		BindingDomain:    bindingDomain,
-		AnnotationFilter: controllers.NewAnnotationFilter(annotationFilter),
-		AnnotationPrefix: annotationPrefix,
-	}).SetupWithManager(mgr, events); err != nil {
+	ingressReconciler := &controllers.IngressReconciler{
+		Client:                mgr.GetClient(),
+		Log:                   ctrl.Log.WithName("controllers").WithName("Ingress"),
+		Scheme:                mgr.GetScheme(),
+		ClusterName:           clusterName,
+		AWSRegion:             awsRegion,
+		DevMode:               devMode,
+		BindingDomains:        domains,
+		ExcludedHosts:         excludedHosts,
+		IncludeTLSHosts:       includeTLSHosts,
+		AnnotationFilter:      ingressAnnotationFilter,
+		LabelFilter:           ingressLabelFilter,
+		IngressClass:          ingressClass,
+		NamespaceSelector:     ingressNamespaceSelector,
+		AnnotationPrefix:      annotationPrefix,
+		WeightStore:           weightStore,
+		Propagation:           propagation,
+		Recorder:              mgr.GetEventRecorderFor("traffic-controller"),
+		DryRun:                dryRun,
+		OutOfScopeAction:      ingressOutOfScopeAction,
+		ControllerID:          controllerID,
+		HostConflictPolicy:    ingressHostConflictPolicy,
+		RecordTTL:             recordTTL,
+		SetIdentifier:         setIdentifier,
+		PreviousSetIdentifier: previousSetIdentifier,
+	}
+	if err = ingressReconciler.SetupWithManager(mgr, events); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Ingress")
 		os.Exit(1)
 	}
 
-	trafficweight.ConfigReconcileLoop(backend, mgr.GetCache(), 20*time.Second, ctrl.Log.WithName("ReconcileLoop"), events)
+	if orphanSweepInterval > 0 {
+		if err = mgr.Add(&controllers.OrphanSweeper{
+			Reconciler: ingressReconciler,
+			Interval:   orphanSweepInterval,
+		}); err != nil {
+			setupLog.Error(err, "unable to add the orphan dns endpoints sweeper")
+			os.Exit(1)
+		}
+	}
+
+	if err = mgr.Add(&trafficweight.ConfigReconciler{
+		Backend:  backend,
+		Store:    weightStore,
+		Cache:    mgr.GetCache(),
+		Events:   events,
+		Interval: weightReconcileInterval,
+		Limits: trafficweight.WeightChangeLimits{
+			MaxDeltaPerInterval: maxWeightChangePerInterval,
+			MaxDeltaPerChange:   maxWeightChange,
+		},
+		OutagePolicy:  outagePolicy,
+		LastKnownGood: lastKnownGood,
+		Propagation:   propagation,
+		Filter:        ingressReconciler.InScope,
+		Audit:         audit,
+		Source:        backendType,
+		DryRun:        dryRun,
+		Log:           ctrl.Log.WithName("ReconcileLoop"),
+	}); err != nil {
+		setupLog.Error(err, "unable to add weight reconcile loop")
+		os.Exit(1)
+	}
+
+	if err = mgr.Add(&trafficweight.ConfigFollower{
+		Backend:  backend,
+		Store:    weightStore,
+		Interval: weightReconcileInterval,
+		Elected:  mgr.Elected(),
+		Log:      ctrl.Log.WithName("FollowerLoop"),
+	}); err != nil {
+		setupLog.Error(err, "unable to add weight follower loop")
+		os.Exit(1)
+	}
+
+	if adminAddr != "" && dryRun {
+		// The weight changes of the admin API are written to the backend shared with the compared controller
+		setupLog.Info("the admin API is disabled in dry-run mode", "admin-addr", adminAddr)
+	} else if adminAddr != "" {
+		token, err := os.ReadFile(adminTokenFile)
+		if err != nil {
+			setupLog.Error(err, "unable to read the admin API token")
+			os.Exit(1)
+		}
+		if strings.TrimSpace(string(token)) == "" {
+			setupLog.Error(nil, "the admin API token is empty", "file", adminTokenFile)
+			os.Exit(1)
+		}
+		if err = mgr.Add(&admin.Server{
+			Addr:        adminAddr,
+			Token:       strings.TrimSpace(string(token)),
+			Backend:     backend,
+			Store:       weightStore,
+			Reader:      mgr.GetClient(),
+			ClusterName: clusterName,
+			Log:         ctrl.Log.WithName("admin"),
+		}); err != nil {
+			setupLog.Error(err, "unable to add the admin API")
+			os.Exit(1)
+		}
+	}
 
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
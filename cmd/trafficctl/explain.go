@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/adevinta/k8s-traffic-controller/pkg/controllers"
+)
+
+// clusterValues holds repeated [CLUSTER=]VALUE flags. Values without cluster apply to all the clusters.
+type clusterValues []clusterValue
+
+type clusterValue struct {
+	cluster string
+	value   string
+}
+
+func (v *clusterValues) String() string {
+	return fmt.Sprint(*v)
+}
+
+func (v *clusterValues) Set(value string) error {
+	cluster, rest, ok := strings.Cut(value, "=")
+	if !ok {
+		cluster, rest = "", value
+	}
+	*v = append(*v, clusterValue{cluster: cluster, value: rest})
+	return nil
+}
+
+// forCluster returns the values applying to cluster
+func (v clusterValues) forCluster(cluster string) []string {
+	values := []string{}
+	for _, value := range v {
+		if value.cluster == "" || value.cluster == cluster {
+			values = append(values, value.value)
+		}
+	}
+	return values
+}
+
+// stringList holds a repeated flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func (c *cli) explain(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	weights := trafficWeights{}
+	files := clusterValues{}
+	unready := clusterValues{}
+	flags.Var(weights, "weight", "CLUSTER=WEIGHT, the weight of a cluster. Can be repeated. Defaults to the current weights in the table")
+	flags.Var(&files, "f", "[CLUSTER=]FILE, a file with the Ingress manifests deployed in a cluster, or in all of them without CLUSTER. Can be repeated")
+	flags.Var(&unready, "unready", "[CLUSTER=]NAMESPACE/SERVICE, a service without ready pods in a cluster, or in all of them without CLUSTER. Can be repeated")
+	annotationPrefix := flags.String("annotation-prefix", "dns.adevinta.com", "The --annotation-prefix of the controllers")
+	annotationFilter := flags.String("annotation-filter", "", "The --annotation-filter of the controllers")
+	labelFilter := flags.String("label-filter", "", "The --label-filter of the controllers")
+	ingressClass := flags.String("ingress-class", "", "The --ingress-class of the controllers")
+	bindingDomains := stringList{}
+	excludedHosts := stringList{}
+	flags.Var(&bindingDomains, "binding-domain", "A --binding-domain of the controllers. Can be repeated")
+	flags.Var(&excludedHosts, "binding-domain-exclude", "A --binding-domain-exclude of the controllers. Can be repeated")
+	includeTLSHosts := flags.Bool("include-tls-hosts", false, "The --include-tls-hosts of the controllers")
+	hostConflictPolicy := flags.String("host-conflict-policy", string(controllers.HostConflictMerge), "The --host-conflict-policy of the controllers")
+	setIdentifierTemplate := flags.String("set-identifier-template", "", "The --set-identifier-template of the controllers")
+	flags.Parse(args)
+	if len(files) == 0 {
+		return errors.New("missing -f")
+	}
+	domains := []controllers.BindingDomain{}
+	for _, value := range bindingDomains {
+		domain, err := controllers.ParseBindingDomain(value)
+		if err != nil {
+			return err
+		}
+		domains = append(domains, domain)
+	}
+	for _, pattern := range excludedHosts {
+		if err := controllers.ValidateExcludedHost(pattern); err != nil {
+			return err
+		}
+	}
+	conflictPolicy, err := controllers.ParseHostConflictPolicy(*hostConflictPolicy)
+	if err != nil {
+		return err
+	}
+
+	if len(weights) == 0 {
+		items, err := c.table.Clusters(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			weights[item.ClusterName] = float64(item.CurrentWeight)
+		}
+	}
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, values := range []clusterValues{files, unready} {
+		for _, value := range values {
+			if _, ok := weights[value.cluster]; value.cluster != "" && !ok {
+				return fmt.Errorf("unknown cluster %s", value.cluster)
+			}
+		}
+	}
+
+	clusters := []controllers.SimulatedCluster{}
+	for _, name := range names {
+		cluster := controllers.SimulatedCluster{Name: name, Weight: int(weights[name])}
+		for _, file := range files.forCluster(name) {
+			ingresses, err := readIngresses(file)
+			if err != nil {
+				return err
+			}
+			cluster.Ingresses = append(cluster.Ingresses, ingresses...)
+		}
+		for _, service := range unready.forCluster(name) {
+			namespace, serviceName, ok := strings.Cut(service, "/")
+			if !ok {
+				return fmt.Errorf("expected NAMESPACE/SERVICE, got %q", service)
+			}
+			cluster.UnreadyServices = append(cluster.UnreadyServices, types.NamespacedName{Namespace: namespace, Name: serviceName})
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	records, err := controllers.Simulate(ctx, controllers.SimulationOptions{
+		AnnotationPrefix:      *annotationPrefix,
+		AnnotationFilter:      *annotationFilter,
+		LabelFilter:           *labelFilter,
+		IngressClass:          *ingressClass,
+		BindingDomains:        domains,
+		ExcludedHosts:         excludedHosts,
+		IncludeTLSHosts:       *includeTLSHosts,
+		HostConflictPolicy:    conflictPolicy,
+		SetIdentifierTemplate: *setIdentifierTemplate,
+	}, clusters)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tCLUSTER\tINGRESS\tCLUSTER WEIGHT\tWEIGHT\tTRAFFIC\t")
+	for _, record := range records {
+		note := ""
+		if record.WithoutPods {
+			note = "without ready pods"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s/%s\t%d\t%d\t%.2f%%\t%s\n", record.Host, record.Cluster, record.Namespace, record.Ingress, int(weights[record.Cluster]), record.Weight, record.Traffic, note)
+	}
+	return w.Flush()
+}
+
+// readIngresses returns the Ingresses of a YAML or JSON file with one or more manifests.
+// Other kinds of objects are ignored.
+func readIngresses(path string) ([]netv1.Ingress, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ingresses := []netv1.Ingress{}
+	decoder := yaml.NewYAMLOrJSONDecoder(file, 4096)
+	for {
+		ingress := netv1.Ingress{}
+		err := decoder.Decode(&ingress)
+		if errors.Is(err, io.EOF) {
+			return ingresses, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if ingress.Kind != "Ingress" {
+			continue
+		}
+		if ingress.Namespace == "" {
+			ingress.Namespace = "default"
+		}
+		ingresses = append(ingresses, ingress)
+	}
+}
@@ -0,0 +1,259 @@
+// trafficctl operates the cluster weights stored in the DynamoDB table read by the traffic controllers
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/sirupsen/logrus"
+
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+)
+
+const usage = `Usage: trafficctl [flags] <command> [command flags]
+
+Commands:
+  list                      List the clusters and their weights
+  set -cluster NAME WEIGHT  Set the desired weight of a cluster
+  drain -cluster NAME       Set the desired weight of a cluster to 0
+  restore -cluster NAME     Set the desired weight of a drained cluster back
+  wait -cluster NAME        Wait until the weight of a cluster is applied
+  split                     Show the traffic split of a host between the clusters
+  explain -f FILE           Show the DNS records and traffic split of Ingress manifests
+
+Flags:
+`
+
+type cli struct {
+	table *trafficweight.DynamoDBTable
+	out   io.Writer
+}
+
+func main() {
+	global := flag.NewFlagSet("trafficctl", flag.ExitOnError)
+	awsRegion := global.String("aws-region", "eu-west-1", "The AWS Region of the DynamoDB table")
+	tableName := global.String("table-name", "traffic-controller", "The DynamoDB table storing the cluster weights")
+	global.Usage = func() {
+		fmt.Fprint(global.Output(), usage)
+		global.PrintDefaults()
+	}
+	global.Parse(os.Args[1:])
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	// Only the failures of the backend, like throttling or failed conditions,
+	// are logged, the output of the commands goes to stdout
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	logger.SetLevel(logrus.WarnLevel)
+	table, err := trafficweight.NewDynamoDBTable(logruslogr.NewLogr(logger), *awsRegion, *tableName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	c := &cli{table: table, out: os.Stdout}
+
+	command, args := global.Arg(0), global.Args()[1:]
+	switch command {
+	case "list":
+		err = c.list(ctx, args)
+	case "set", "drain", "restore":
+		err = c.change(ctx, command, args)
+	case "wait":
+		err = c.wait(ctx, args)
+	case "split":
+		err = c.split(ctx, args)
+	case "explain":
+		err = c.explain(ctx, args)
+	default:
+		global.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func (c *cli) list(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	flags.Parse(args)
+
+	clusters, err := c.table.Clusters(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tDESIRED\tCURRENT\tVERSION\tAPPLIED VERSION\tAPPLIED AT\tCHANGED BY\tFORCE\tDRAINED WEIGHT\tRECORD TTL")
+	for _, cluster := range clusters {
+		drained := ""
+		if cluster.DrainedWeight != nil {
+			drained = strconv.Itoa(*cluster.DrainedWeight)
+		}
+		ttl := ""
+		if cluster.RecordTTL != 0 {
+			ttl = strconv.FormatInt(cluster.RecordTTL, 10)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%t\t%s\t%s\n", cluster.ClusterName, cluster.DesiredWeight, cluster.CurrentWeight, cluster.Version, cluster.AppliedVersion, cluster.AppliedAt, cluster.ChangedBy, cluster.Force, drained, ttl)
+	}
+	return w.Flush()
+}
+
+func (c *cli) change(ctx context.Context, command string, args []string) error {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	clusterName := flags.String("cluster", "", "The cluster to change")
+	force := flags.Bool("force", false, "Bypass the weight change limits of the controller")
+	changedBy := flags.String("changed-by", os.Getenv("USER"), "Who changes the weight, recorded in the audit log")
+	wait := flags.Duration("wait", 0, "Wait up to this duration for the weight to be applied")
+	flags.Parse(args)
+	if *clusterName == "" {
+		return errors.New("missing -cluster")
+	}
+
+	backend := c.table.Cluster(*clusterName)
+	change := trafficweight.WeightChange{ChangedBy: *changedBy, Force: *force}
+	var err error
+	switch command {
+	case "set":
+		if flags.NArg() != 1 {
+			return errors.New("usage: trafficctl set -cluster NAME WEIGHT")
+		}
+		weight, parseErr := strconv.Atoi(flags.Arg(0))
+		if parseErr != nil {
+			return fmt.Errorf("invalid weight %q: %w", flags.Arg(0), parseErr)
+		}
+		err = backend.SetDesiredWeight(ctx, weight, change)
+	case "drain":
+		err = backend.Drain(ctx, change)
+	case "restore":
+		err = backend.Restore(ctx, change)
+	}
+	if err != nil {
+		return err
+	}
+	config, err := backend.ReadWeight(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "%s: desired weight %d (version %d), current weight %d\n", *clusterName, config.DesiredWeight, config.Version, config.CurrentWeight)
+	if *wait > 0 {
+		return c.waitFor(ctx, *clusterName, *wait)
+	}
+	return nil
+}
+
+func (c *cli) wait(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("wait", flag.ExitOnError)
+	clusterName := flags.String("cluster", "", "The cluster to wait for")
+	timeout := flags.Duration("timeout", 10*time.Minute, "How long to wait for the weight to be applied")
+	flags.Parse(args)
+	if *clusterName == "" {
+		return errors.New("missing -cluster")
+	}
+	return c.waitFor(ctx, *clusterName, *timeout)
+}
+
+func (c *cli) waitFor(ctx context.Context, clusterName string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	config, err := trafficweight.WaitForWeight(ctx, c.table.Cluster(clusterName), 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("%s: weight %d not applied, current weight %d: %w", clusterName, config.DesiredWeight, config.CurrentWeight, err)
+	}
+	fmt.Fprintf(c.out, "%s: weight %d applied\n", clusterName, config.CurrentWeight)
+	return nil
+}
+
+// trafficWeights holds the traffic-weight annotation of a host in each cluster
+type trafficWeights map[string]float64
+
+func (t trafficWeights) String() string {
+	return fmt.Sprint(map[string]float64(t))
+}
+
+func (t trafficWeights) Set(value string) error {
+	cluster, weight, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected CLUSTER=WEIGHT, got %q", value)
+	}
+	parsed, err := strconv.ParseFloat(weight, 64)
+	if err != nil {
+		return fmt.Errorf("invalid traffic weight %q: %w", weight, err)
+	}
+	t[cluster] = parsed
+	return nil
+}
+
+func (c *cli) split(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("split", flag.ExitOnError)
+	annotations := trafficWeights{}
+	flags.Var(annotations, "traffic-weight", "CLUSTER=WEIGHT, the traffic-weight annotation of the host in a cluster. Can be repeated. Hosts without annotation get the cluster weight")
+	flags.Parse(args)
+
+	clusters, err := c.table.Clusters(ctx)
+	if err != nil {
+		return err
+	}
+	weights, err := hostWeights(clusters, annotations)
+	if err != nil {
+		return err
+	}
+	split := trafficweight.TrafficSplit(weights)
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tCLUSTER WEIGHT\tHOST WEIGHT\tTRAFFIC")
+	for _, name := range names {
+		clusterWeight := ""
+		for _, cluster := range clusters {
+			if cluster.ClusterName == name {
+				clusterWeight = strconv.Itoa(cluster.CurrentWeight)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.2f%%\n", name, clusterWeight, weights[name], split[name])
+	}
+	return w.Flush()
+}
+
+// hostWeights returns the weight of a host in each cluster, as computed by the
+// controllers from the applied cluster weight and the host annotations
+func hostWeights(clusters []trafficweight.Item, annotations trafficWeights) (map[string]uint, error) {
+	weights := map[string]uint{}
+	for _, cluster := range clusters {
+		weight := uint(cluster.CurrentWeight)
+		if annotation, ok := annotations[cluster.ClusterName]; ok {
+			var err error
+			weight, err = trafficweight.IngressWeight(cluster.CurrentWeight, annotation)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", cluster.ClusterName, err)
+			}
+		}
+		weights[cluster.ClusterName] = weight
+	}
+	for cluster := range annotations {
+		if _, ok := weights[cluster]; !ok {
+			return nil, fmt.Errorf("unknown cluster %s", cluster)
+		}
+	}
+	return weights, nil
+}
@@ -0,0 +1,255 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+
+	"github.com/adevinta/k8s-traffic-controller/pkg/controllers"
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+)
+
+const (
+	shutdownTimeout = 5 * time.Second
+	// defaultChangedBy identifies the changes made through the API that do not name their author
+	defaultChangedBy = "admin-api"
+)
+
+// HostWeight is the weight published for a host in a DNSEndpoint
+type HostWeight struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Host      string `json:"host"`
+	// SetIdentifier tells the records of a host apart, like the drained ones while migrating the set identifiers
+	SetIdentifier string `json:"setIdentifier,omitempty"`
+	Weight        *int   `json:"weight,omitempty"`
+}
+
+// Weights is the response of GET /weights
+type Weights struct {
+	Cluster trafficweight.StoreConfig `json:"cluster"`
+	Hosts   []HostWeight              `json:"hosts"`
+}
+
+// WeightChangeRequest is the body of POST /weights, /drain and /restore.
+// Weight is only used by POST /weights.
+type WeightChangeRequest struct {
+	Weight    *int   `json:"weight,omitempty"`
+	Force     bool   `json:"force,omitempty"`
+	ChangedBy string `json:"changedBy,omitempty"`
+}
+
+// Server exposes an HTTP API to inspect and change the cluster weight.
+// Every request must be authenticated with the bearer Token.
+type Server struct {
+	Addr  string
+	Token string
+	// Backend receives the weight changes, it must implement trafficweight.WeightWriter to accept them
+	Backend trafficweight.TrafficWeightBackend
+	Store   *trafficweight.WeightStore
+	// Reader lists the DNSEndpoints to report the weight of every host
+	Reader      client.Reader
+	ClusterName string
+	Log         logr.Logger
+}
+
+var _ manager.Runnable = &Server{}
+var _ manager.LeaderElectionRunnable = &Server{}
+
+// NeedLeaderElection returns false as the weight changes are written to the
+// backend, which is shared by all the replicas
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              s.Addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		s.Log.Info("Starting admin API", "address", s.Addr)
+		errs <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/weights", s.weights)
+	mux.HandleFunc("/drain", s.change(func(ctx context.Context, writer trafficweight.WeightWriter, req WeightChangeRequest, change trafficweight.WeightChange) error {
+		return writer.Drain(ctx, change)
+	}))
+	mux.HandleFunc("/restore", s.change(func(ctx context.Context, writer trafficweight.WeightWriter, req WeightChangeRequest, change trafficweight.WeightChange) error {
+		return writer.Restore(ctx, change)
+	}))
+	return s.authenticated(mux)
+}
+
+func (s *Server) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || s.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httpError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) weights(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getWeights(w, r)
+	case http.MethodPost:
+		s.change(func(ctx context.Context, writer trafficweight.WeightWriter, req WeightChangeRequest, change trafficweight.WeightChange) error {
+			if req.Weight == nil {
+				return &badRequestError{errors.New("missing weight")}
+			}
+			if err := trafficweight.ValidateWeight(*req.Weight); err != nil {
+				return &badRequestError{err}
+			}
+			return writer.SetDesiredWeight(ctx, *req.Weight, change)
+		})(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (s *Server) getWeights(w http.ResponseWriter, r *http.Request) {
+	hosts, err := s.hostWeights(r.Context())
+	if err != nil {
+		s.Log.Error(err, "Unable to list the DNS endpoints")
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Weights{Cluster: s.Store.Get(), Hosts: hosts})
+}
+
+// hostWeights returns the weights of the records published for this cluster
+func (s *Server) hostWeights(ctx context.Context) ([]HostWeight, error) {
+	endpoints := externaldnsk8siov1alpha1.DNSEndpointList{}
+	if err := s.Reader.List(ctx, &endpoints); err != nil {
+		return nil, err
+	}
+	hosts := []HostWeight{}
+	for _, dnsEndpoint := range endpoints.Items {
+		for _, ep := range dnsEndpoint.Spec.Endpoints {
+			if !controllers.PublishedByCluster(&dnsEndpoint, ep, s.ClusterName) {
+				continue
+			}
+			host := HostWeight{Namespace: dnsEndpoint.Namespace, Name: dnsEndpoint.Name, Host: ep.DNSName, SetIdentifier: ep.SetIdentifier}
+			if value, ok := ep.GetProviderSpecificProperty("aws/weight"); ok {
+				if weight, err := strconv.Atoi(value.Value); err == nil {
+					host.Weight = &weight
+				}
+			}
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Host != hosts[j].Host {
+			return hosts[i].Host < hosts[j].Host
+		}
+		if hosts[i].Namespace != hosts[j].Namespace {
+			return hosts[i].Namespace < hosts[j].Namespace
+		}
+		if hosts[i].Name != hosts[j].Name {
+			return hosts[i].Name < hosts[j].Name
+		}
+		return hosts[i].SetIdentifier < hosts[j].SetIdentifier
+	})
+	return hosts, nil
+}
+
+type badRequestError struct {
+	err error
+}
+
+func (e *badRequestError) Error() string { return e.err.Error() }
+
+func (e *badRequestError) Unwrap() error { return e.err }
+
+type changeFunc func(ctx context.Context, writer trafficweight.WeightWriter, req WeightChangeRequest, change trafficweight.WeightChange) error
+
+// change decodes the request and applies it to the backend.
+// The new weight is applied asynchronously by the weight reconcile loop.
+func (s *Server) change(apply changeFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		writer, ok := s.Backend.(trafficweight.WeightWriter)
+		if !ok {
+			httpError(w, http.StatusNotImplemented, errors.New("the weight backend does not support weight changes"))
+			return
+		}
+		req := WeightChangeRequest{}
+		// The body is optional for /drain and /restore
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			httpError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		change := trafficweight.WeightChange{ChangedBy: req.ChangedBy, Force: req.Force}
+		if change.ChangedBy == "" {
+			change.ChangedBy = defaultChangedBy
+		}
+		log := s.Log.WithValues("path", r.URL.Path, "changedBy", change.ChangedBy, "force", change.Force)
+		if req.Weight != nil {
+			log = log.WithValues("weight", *req.Weight)
+		}
+
+		err := apply(r.Context(), writer, req, change)
+		var badRequest *badRequestError
+		switch {
+		case errors.As(err, &badRequest):
+			httpError(w, http.StatusBadRequest, err)
+		case errors.Is(err, trafficweight.ErrNotDrained):
+			httpError(w, http.StatusConflict, err)
+		case errors.Is(err, trafficweight.ErrClusterNotFound):
+			httpError(w, http.StatusNotFound, err)
+		case err != nil:
+			log.Error(err, "Unable to change the weight")
+			httpError(w, http.StatusInternalServerError, err)
+		default:
+			log.Info("Weight change requested")
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func httpError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+
+	"github.com/adevinta/k8s-traffic-controller/pkg/controllers"
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+)
+
+type readOnlyBackend struct {
+	trafficweight.TrafficWeightBackend
+}
+
+func newTestServer(backend func(*trafficweight.WeightStore) trafficweight.TrafficWeightBackend) (*Server, *trafficweight.WeightStore) {
+	store := trafficweight.NewWeightStore(trafficweight.StoreConfig{DesiredWeight: 70, CurrentWeight: 70})
+	dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-app", Namespace: "cpr-dev"},
+		Spec: externaldnsk8siov1alpha1.DNSEndpointSpec{Endpoints: []*externaldnsk8siov1alpha1.Endpoint{
+			{
+				DNSName:          "test-app.domain.tld",
+				SetIdentifier:    "test-cluster",
+				ProviderSpecific: externaldnsk8siov1alpha1.ProviderSpecific{{Name: "aws/weight", Value: "35"}},
+			},
+			{
+				DNSName:          "other-cluster.domain.tld",
+				SetIdentifier:    "other-cluster",
+				ProviderSpecific: externaldnsk8siov1alpha1.ProviderSpecific{{Name: "aws/weight", Value: "10"}},
+			},
+		}},
+	}
+	logger := logruslogr.NewLogr(&logrus.Logger{})
+	return &Server{
+		Token:       "s3cr3t",
+		Backend:     backend(store),
+		Store:       store,
+		Reader:      fake.NewClientBuilder().WithScheme(controllers.NewScheme()).WithObjects(dnsEndpoint).Build(),
+		ClusterName: "test-cluster",
+		Log:         logger,
+	}, store
+}
+
+func fakeBackend(store *trafficweight.WeightStore) trafficweight.TrafficWeightBackend {
+	return trafficweight.NewFakeBackend(logruslogr.NewLogr(&logrus.Logger{}), store)
+}
+
+// desired returns the configuration of the backend, applied by the ConfigReconciler
+func desired(t *testing.T, server *Server) trafficweight.StoreConfig {
+	t.Helper()
+	config, err := server.Backend.ReadWeight(context.Background())
+	require.NoError(t, err)
+	return config
+}
+
+func request(t *testing.T, server *Server, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminServer(t *testing.T) {
+	t.Run("requests must be authenticated", func(t *testing.T) {
+		server, _ := newTestServer(fakeBackend)
+		assert.Equal(t, http.StatusUnauthorized, request(t, server, http.MethodGet, "/weights", "", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, request(t, server, http.MethodGet, "/weights", "wrong", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, request(t, server, http.MethodPost, "/drain", "", "").Code)
+	})
+
+	t.Run("an empty token rejects every request", func(t *testing.T) {
+		server, _ := newTestServer(fakeBackend)
+		server.Token = ""
+		req := httptest.NewRequest(http.MethodGet, "/weights", nil)
+		req.Header.Set("Authorization", "Bearer ")
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("weights of the cluster and its hosts are returned", func(t *testing.T) {
+		server, _ := newTestServer(fakeBackend)
+		rec := request(t, server, http.MethodGet, "/weights", "s3cr3t", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		weights := Weights{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &weights))
+		assert.Equal(t, 70, weights.Cluster.DesiredWeight)
+		assert.Equal(t, 70, weights.Cluster.CurrentWeight)
+		require.Len(t, weights.Hosts, 1)
+		assert.Equal(t, "test-app.domain.tld", weights.Hosts[0].Host)
+		assert.Equal(t, "cpr-dev", weights.Hosts[0].Namespace)
+		require.NotNil(t, weights.Hosts[0].Weight)
+		assert.Equal(t, 35, *weights.Hosts[0].Weight)
+	})
+
+	t.Run("desired weights are set through the backend", func(t *testing.T) {
+		server, store := newTestServer(fakeBackend)
+		rec := request(t, server, http.MethodPost, "/weights", "s3cr3t", `{"weight": 20, "changedBy": "jane", "force": true}`)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.Equal(t, 20, desired(t, server).DesiredWeight)
+		assert.Equal(t, "jane", desired(t, server).ChangedBy)
+		assert.True(t, desired(t, server).Force)
+		assert.Equal(t, 70, store.Get().DesiredWeight, "the weight is applied by the ConfigReconciler")
+	})
+
+	t.Run("invalid weights are rejected", func(t *testing.T) {
+		server, _ := newTestServer(fakeBackend)
+		assert.Equal(t, http.StatusBadRequest, request(t, server, http.MethodPost, "/weights", "s3cr3t", `{"weight": 101}`).Code)
+		assert.Equal(t, http.StatusBadRequest, request(t, server, http.MethodPost, "/weights", "s3cr3t", `{}`).Code)
+		assert.Equal(t, http.StatusBadRequest, request(t, server, http.MethodPost, "/weights", "s3cr3t", `weight=10`).Code)
+		assert.Equal(t, 70, desired(t, server).DesiredWeight)
+	})
+
+	t.Run("clusters are drained and restored", func(t *testing.T) {
+		server, _ := newTestServer(fakeBackend)
+		assert.Equal(t, http.StatusConflict, request(t, server, http.MethodPost, "/restore", "s3cr3t", "").Code)
+
+		assert.Equal(t, http.StatusAccepted, request(t, server, http.MethodPost, "/drain", "s3cr3t", "").Code)
+		assert.Equal(t, 0, desired(t, server).DesiredWeight)
+		assert.Equal(t, "admin-api", desired(t, server).ChangedBy)
+
+		assert.Equal(t, http.StatusAccepted, request(t, server, http.MethodPost, "/restore", "s3cr3t", `{"changedBy": "jane"}`).Code)
+		assert.Equal(t, 70, desired(t, server).DesiredWeight)
+		assert.Equal(t, "jane", desired(t, server).ChangedBy)
+	})
+
+	t.Run("unsupported methods are rejected", func(t *testing.T) {
+		server, _ := newTestServer(fakeBackend)
+		assert.Equal(t, http.StatusMethodNotAllowed, request(t, server, http.MethodDelete, "/weights", "s3cr3t", "").Code)
+		assert.Equal(t, http.StatusMethodNotAllowed, request(t, server, http.MethodGet, "/drain", "s3cr3t", "").Code)
+	})
+
+	t.Run("backends without weight changes are reported", func(t *testing.T) {
+		server, _ := newTestServer(func(store *trafficweight.WeightStore) trafficweight.TrafficWeightBackend {
+			return readOnlyBackend{}
+		})
+		assert.Equal(t, http.StatusNotImplemented, request(t, server, http.MethodPost, "/drain", "s3cr3t", "").Code)
+	})
+}
@@ -2,10 +2,9 @@ package aws
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
-	"log"
-
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
@@ -45,7 +44,7 @@ func NewAwsSession(parameters *SessionParameters) (*session.Session, error) {
 	})
 
 	if err != nil {
-		log.Fatalf("unable to create new AWS session because of %s", err)
+		return nil, fmt.Errorf("unable to create new AWS session because of %w", err)
 	}
 
 	if parameters.AccessKey != "" && parameters.SecretKey != "" {
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// RecordTypeCNAME publishes the hosts as CNAME records to the load balancer
+	RecordTypeCNAME = "CNAME"
+	// RecordTypeA publishes the hosts as Route53 alias A records to the load balancer
+	RecordTypeA = "A"
+
+	// noHealthCheck disables the cluster health check for the records of a domain
+	noHealthCheck = "none"
+)
+
+// BindingDomain is a domain the controller creates DNS records for, with the settings of these records
+type BindingDomain struct {
+	Domain string
+	// TTL of the records in seconds, the provider default is used when 0
+	TTL int64
+	// RecordType of the records, CNAME when empty
+	RecordType string
+	// AWSHealthCheckID overrides the health check of the cluster, "none" disables it
+	AWSHealthCheckID string
+}
+
+// ParseBindingDomain parses a DOMAIN[,ttl=SECONDS][,record-type=CNAME|A][,health-check-id=ID|none] binding domain
+func ParseBindingDomain(value string) (BindingDomain, error) {
+	fields := strings.Split(value, ",")
+	domain := BindingDomain{Domain: normalizeDomain(fields[0])}
+	if domain.Domain == "" {
+		return BindingDomain{}, fmt.Errorf("binding domain %q: empty domain", value)
+	}
+	if strings.Contains(domain.Domain, "*") {
+		return BindingDomain{}, fmt.Errorf("binding domain %q: wildcards are not allowed", value)
+	}
+	for _, field := range fields[1:] {
+		key, setting, ok := strings.Cut(field, "=")
+		if !ok {
+			return BindingDomain{}, fmt.Errorf("binding domain %q: expected KEY=VALUE, got %q", value, field)
+		}
+		switch key {
+		case "ttl":
+			ttl, err := strconv.ParseInt(setting, 10, 64)
+			if err != nil || ttl <= 0 {
+				return BindingDomain{}, fmt.Errorf("binding domain %q: invalid ttl %q, expected a positive number of seconds", value, setting)
+			}
+			domain.TTL = ttl
+		case "record-type":
+			recordType := strings.ToUpper(setting)
+			if recordType != RecordTypeCNAME && recordType != RecordTypeA {
+				return BindingDomain{}, fmt.Errorf("binding domain %q: invalid record type %q, valid values are %q and %q", value, setting, RecordTypeCNAME, RecordTypeA)
+			}
+			domain.RecordType = recordType
+		case "health-check-id":
+			domain.AWSHealthCheckID = setting
+		default:
+			return BindingDomain{}, fmt.Errorf("binding domain %q: unknown setting %q", value, key)
+		}
+	}
+	return domain, nil
+}
+
+// ValidateExcludedHost checks that pattern is a valid host exclusion pattern
+func ValidateExcludedHost(pattern string) error {
+	if normalizeDomain(pattern) == "" {
+		return fmt.Errorf("empty host exclusion")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("host exclusion %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// normalizeDomain returns domain in lower case, without trailing dot
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// inDomain tells whether host is domain or one of its subdomains,
+// so that foo.io matches www.foo.io but not evilfoo.io
+func inDomain(host, domain string) bool {
+	host, domain = normalizeDomain(host), normalizeDomain(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// isExcluded tells whether host matches an exclusion pattern. Patterns
+// with wildcards are globs, like "*-internal.foo.io", while other patterns
+// exclude the domain and its subdomains.
+func isExcluded(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = normalizeDomain(pattern)
+		if !strings.ContainsAny(pattern, "*?[") {
+			if inDomain(host, pattern) {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern, normalizeDomain(host)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// bindingDomain returns the binding domain of host, the most specific one
+// when several match. Without binding domains, every host is bound.
+// Wildcard hosts are bound when their base is, so *.foo.io is in foo.io but
+// *.io is not, and invalid wildcard hosts are never bound.
+func (r *IngressReconciler) bindingDomain(host string) (BindingDomain, bool) {
+	name := normalizeDomain(host)
+	if isWildcardHost(host) {
+		base, err := wildcardBase(host)
+		if err != nil {
+			return BindingDomain{}, false
+		}
+		name = base
+	}
+	if isExcluded(host, r.ExcludedHosts) || isExcluded(name, r.ExcludedHosts) {
+		return BindingDomain{}, false
+	}
+	if len(r.BindingDomains) == 0 {
+		return BindingDomain{}, true
+	}
+	var bound *BindingDomain
+	for i, domain := range r.BindingDomains {
+		if inDomain(name, domain.Domain) && (bound == nil || len(domain.Domain) > len(bound.Domain)) {
+			bound = &r.BindingDomains[i]
+		}
+	}
+	if bound == nil {
+		return BindingDomain{}, false
+	}
+	return *bound, true
+}
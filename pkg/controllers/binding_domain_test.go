@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestParseBindingDomain(t *testing.T) {
+	domain, err := ParseBindingDomain("Foo.io.")
+	require.NoError(t, err)
+	assert.Equal(t, BindingDomain{Domain: "foo.io"}, domain)
+
+	domain, err = ParseBindingDomain("foo.io,ttl=60,record-type=a,health-check-id=none")
+	require.NoError(t, err)
+	assert.Equal(t, BindingDomain{Domain: "foo.io", TTL: 60, RecordType: RecordTypeA, AWSHealthCheckID: "none"}, domain)
+
+	for _, invalid := range []string{"", ",ttl=60", "*.foo.io", "foo.io,ttl=-1", "foo.io,ttl=1m", "foo.io,record-type=TXT", "foo.io,weight=10", "foo.io,ttl"} {
+		_, err := ParseBindingDomain(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestBindingDomainMatching(t *testing.T) {
+	reconciler := IngressReconciler{
+		BindingDomains: []BindingDomain{{Domain: "foo.io"}, {Domain: "internal.foo.io", TTL: 30}, {Domain: "bar.com"}},
+		ExcludedHosts:  []string{"private.foo.io", "*-canary.bar.com"},
+	}
+	cases := map[string]bool{
+		"foo.io":                  true,
+		"www.foo.io":              true,
+		"WWW.Foo.IO.":             true,
+		"evilfoo.io":              false,
+		"foo.io.evil.com":         false,
+		"private.foo.io":          false,
+		"api.private.foo.io":      false,
+		"www.bar.com":             true,
+		"www-canary.bar.com":      false,
+		"www-canary.bar.com.evil": false,
+		"other.tld":               false,
+	}
+	for host, bound := range cases {
+		_, ok := reconciler.bindingDomain(host)
+		assert.Equal(t, bound, ok, host)
+	}
+
+	domain, ok := reconciler.bindingDomain("api.internal.foo.io")
+	require.True(t, ok)
+	assert.Equal(t, BindingDomain{Domain: "internal.foo.io", TTL: 30}, domain)
+
+	_, ok = (&IngressReconciler{ExcludedHosts: []string{"foo.io"}}).bindingDomain("www.bar.com")
+	assert.True(t, ok, "all the hosts are bound without binding domains")
+	assert.Error(t, ValidateExcludedHost("[foo.io"))
+	assert.Error(t, ValidateExcludedHost(""))
+	assert.NoError(t, ValidateExcludedHost("*.foo.io"))
+}
+
+func TestBindingDomainSettings(t *testing.T) {
+	ingress := mockIngress(func(ing *netv1.Ingress) {
+		ing.Spec.Rules = append(ing.Spec.Rules,
+			netv1.IngressRule{Host: "test-app.other.tld", IngressRuleValue: ing.Spec.Rules[0].IngressRuleValue},
+			netv1.IngressRule{Host: "test-app.checked.tld", IngressRuleValue: ing.Spec.Rules[0].IngressRuleValue},
+		)
+	})
+	reconciler := IngressReconciler{
+		Client: fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(
+			mockEndpoint(epWithName("test-app")),
+			mockEndpoint(epWithName("test-app-a")),
+		).Build(),
+		Log:              logruslogr.NewLogr(&logrus.Logger{}),
+		AnnotationPrefix: "dns.adevinta.com",
+		ClusterName:      "cluster-1",
+		BindingDomains: []BindingDomain{
+			{Domain: "domain.tld", TTL: 60, RecordType: RecordTypeA},
+			{Domain: "other.tld", AWSHealthCheckID: "none"},
+			{Domain: "checked.tld", AWSHealthCheckID: "domain-check"},
+		},
+		WeightStore: trafficweight.NewWeightStore(trafficweight.StoreConfig{DesiredWeight: 10, CurrentWeight: 10, AWSHealthCheckID: "cluster-check"}),
+	}
+
+	dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{}
+	_, err := reconciler.newDnsEndpoint(context.Background(), dnsEndpoint, "lb.domain.tld", *ingress, metav1.OwnerReference{})
+	require.NoError(t, err)
+	require.Len(t, dnsEndpoint.Spec.Endpoints, 3)
+
+	aliased := dnsEndpoint.Spec.Endpoints[0]
+	assert.Equal(t, "test-app.domain.tld", aliased.DNSName)
+	assert.Equal(t, RecordTypeA, aliased.RecordType)
+	assert.Equal(t, externaldnsk8siov1alpha1.TTL(60), aliased.RecordTTL)
+	assert.Equal(t, externaldnsk8siov1alpha1.ProviderSpecific{
+		{Name: "aws/weight", Value: "10"},
+		{Name: "aws/health-check-id", Value: "cluster-check"},
+		{Name: "alias", Value: "true"},
+	}, aliased.ProviderSpecific)
+
+	other := dnsEndpoint.Spec.Endpoints[1]
+	assert.Equal(t, "test-app.other.tld", other.DNSName)
+	assert.Equal(t, RecordTypeCNAME, other.RecordType)
+	assert.False(t, other.RecordTTL.IsConfigured())
+	assert.Equal(t, externaldnsk8siov1alpha1.ProviderSpecific{{Name: "aws/weight", Value: "10"}}, other.ProviderSpecific)
+
+	checked := dnsEndpoint.Spec.Endpoints[2]
+	assert.Equal(t, externaldnsk8siov1alpha1.ProviderSpecific{
+		{Name: "aws/weight", Value: "10"},
+		{Name: "aws/health-check-id", Value: "domain-check"},
+	}, checked.ProviderSpecific)
+
+	// The weight breakdown records the health check of each record
+	breakdown := weightBreakdown{}
+	require.NoError(t, json.Unmarshal([]byte(dnsEndpoint.Annotations["dns.adevinta.com/weight-breakdown"]), &breakdown))
+	assert.Equal(t, "cluster-check", breakdown.Hosts["test-app.domain.tld"].HealthCheckID)
+	assert.Empty(t, breakdown.Hosts["test-app.other.tld"].HealthCheckID)
+	assert.Equal(t, "domain-check", breakdown.Hosts["test-app.checked.tld"].HealthCheckID)
+}
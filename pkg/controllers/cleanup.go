@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	netv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+type OutOfScopeAction string
+
+const (
+	// OutOfScopeDelete deletes the DNSEndpoint of the ingresses leaving the scope of the controller
+	OutOfScopeDelete OutOfScopeAction = "delete"
+	// OutOfScopeZero keeps the DNSEndpoint of the ingresses leaving the scope of
+	// the controller with all its weights set to 0, so the records are drained
+	// before being removed by hand
+	OutOfScopeZero OutOfScopeAction = "zero"
+)
+
+const (
+	releaseReasonOutOfScope = "out-of-scope"
+	releaseReasonOrphan     = "orphan"
+)
+
+func ParseOutOfScopeAction(action string) (OutOfScopeAction, error) {
+	switch OutOfScopeAction(action) {
+	case OutOfScopeDelete, OutOfScopeZero:
+		return OutOfScopeAction(action), nil
+	default:
+		return "", fmt.Errorf("unknown out of scope action %q, valid values are %q and %q", action, OutOfScopeDelete, OutOfScopeZero)
+	}
+}
+
+// bindsHosts tells whether some host of the ingress is in the binding domains
+func (r *IngressReconciler) bindsHosts(ingress netv1.Ingress) bool {
+	rules, err := r.hostRules(ingress)
+	if err != nil {
+		// Reported in the traffic status by reconcileDNSEntries
+		return true
+	}
+	return len(r.filterIngressRulesByHost(rules)) > 0
+}
+
+// zeroWeights sets the weight of all the records of the DNSEndpoint to 0 and
+// tells whether it changed
+func zeroWeights(dnsEndpoint *externaldnsk8siov1alpha1.DNSEndpoint) bool {
+	changed := false
+	for _, endpoint := range dnsEndpoint.Spec.Endpoints {
+		for i, property := range endpoint.ProviderSpecific {
+			if property.Name == "aws/weight" && property.Value != "0" {
+				endpoint.ProviderSpecific[i].Value = "0"
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// releaseDNSEndpoint removes the records of an ingress out of the scope of
+// the controller, as configured by OutOfScopeAction. DNSEndpoints not
+// controlled by the ingress are left untouched.
+func (r *IngressReconciler) releaseDNSEndpoint(ctx context.Context, ingress netv1.Ingress, log logr.Logger) error {
+	dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name}, dnsEndpoint); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !r.ownsDNSEndpoint(dnsEndpoint, &ingress) {
+		log.V(1).Info("The dns endpoint is not owned by the controller, leaving it untouched", "owner", describeOwner(dnsEndpoint))
+		return nil
+	}
+	return r.release(ctx, dnsEndpoint, r.OutOfScopeAction, releaseReasonOutOfScope, log)
+}
+
+// deleteOrphanDNSEndpoint deletes the DNSEndpoint of a deleted ingress, unless
+// it is owned by someone else
+func (r *IngressReconciler) deleteOrphanDNSEndpoint(ctx context.Context, key types.NamespacedName, log logr.Logger) error {
+	dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{}
+	if err := r.Get(ctx, key, dnsEndpoint); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !r.ownsDNSEndpoint(dnsEndpoint, nil) {
+		log.Info("The dns endpoint is not owned by the controller, leaving it untouched", "owner", describeOwner(dnsEndpoint))
+		return nil
+	}
+	return r.release(ctx, dnsEndpoint, OutOfScopeDelete, releaseReasonOrphan, log)
+}
+
+func (r *IngressReconciler) release(ctx context.Context, dnsEndpoint *externaldnsk8siov1alpha1.DNSEndpoint, action OutOfScopeAction, reason string, log logr.Logger) error {
+	if action == "" {
+		action = OutOfScopeDelete
+	}
+	log = log.WithValues("action", string(action), "reason", reason)
+	if action == OutOfScopeZero {
+		if !zeroWeights(dnsEndpoint) {
+			return nil
+		}
+		if r.DryRun {
+			log.Info("The dns endpoint weights would be set to 0", "DryRun", true)
+			return nil
+		}
+		if err := r.Update(ctx, dnsEndpoint); err != nil {
+			return err
+		}
+	} else {
+		if r.DryRun {
+			log.Info("The dns endpoint would be deleted", "DryRun", true)
+			return nil
+		}
+		if err := client.IgnoreNotFound(r.Delete(ctx, dnsEndpoint)); err != nil {
+			return err
+		}
+	}
+	log.Info("Released the dns endpoint")
+	ingressMetrics.ReleasedDNSEndpoints.WithLabelValues(reason, string(action)).Inc()
+	return nil
+}
+
+// SweepOrphans releases the DNSEndpoints owned by the controller whose
+// ingress no longer exists or is out of the scope of the controller. Those
+// are missed by Reconcile when the ingress changed while the controller was
+// offline, or when the controller restarts with different filters.
+// DNSEndpoints without ingress are always deleted. Out of scope DNSEndpoints
+// without ownership labels may belong to another controller instance and
+// are left untouched.
+// A DNSEndpoint failing to be swept does not stop the sweep of the others,
+// the errors are joined.
+func (r *IngressReconciler) SweepOrphans(ctx context.Context) error {
+	var dnsEndpoints externaldnsk8siov1alpha1.DNSEndpointList
+	if err := r.List(ctx, &dnsEndpoints); err != nil {
+		return err
+	}
+	var errs []error
+	for i := range dnsEndpoints.Items {
+		dnsEndpoint := &dnsEndpoints.Items[i]
+		if !r.ownsDNSEndpoint(dnsEndpoint, nil) || !dnsEndpoint.DeletionTimestamp.IsZero() {
+			continue
+		}
+		log := r.Log.WithValues("DNSEndpointName", dnsEndpoint.Name, "DNSEndpointNamespace", dnsEndpoint.Namespace)
+		if err := r.sweepOrphan(ctx, dnsEndpoint, log); err != nil {
+			log.Error(err, "Unable to sweep the dns endpoint")
+			ingressMetrics.OrphanSweepErrors.WithLabelValues(dnsEndpoint.Namespace, dnsEndpoint.Name).Inc()
+			errs = append(errs, fmt.Errorf("dns endpoint %s/%s: %w", dnsEndpoint.Namespace, dnsEndpoint.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sweepOrphan releases the DNSEndpoint when its ingress no longer exists or is out of scope
+func (r *IngressReconciler) sweepOrphan(ctx context.Context, dnsEndpoint *externaldnsk8siov1alpha1.DNSEndpoint, log logr.Logger) error {
+	var ingress netv1.Ingress
+	err := r.Get(ctx, types.NamespacedName{Namespace: dnsEndpoint.Namespace, Name: dnsEndpoint.Name}, &ingress)
+	if apierrors.IsNotFound(err) {
+		return r.release(ctx, dnsEndpoint, OutOfScopeDelete, releaseReasonOrphan, log)
+	}
+	if err != nil {
+		return err
+	}
+	if !hasOwnershipLabels(dnsEndpoint) || !ingress.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	matches, err := r.ingressMatchesFilter(ctx, ingress)
+	if err != nil {
+		return err
+	}
+	if matches && r.bindsHosts(ingress) {
+		return nil
+	}
+	ingressMetrics.forget(ingress.Namespace, ingress.Name)
+	return r.release(ctx, dnsEndpoint, r.OutOfScopeAction, releaseReasonOutOfScope, log)
+}
+
+// OrphanSweeper periodically runs IngressReconciler.SweepOrphans
+type OrphanSweeper struct {
+	Reconciler *IngressReconciler
+	Interval   time.Duration
+}
+
+var _ manager.Runnable = &OrphanSweeper{}
+var _ manager.LeaderElectionRunnable = &OrphanSweeper{}
+
+func (s *OrphanSweeper) NeedLeaderElection() bool {
+	return true
+}
+
+func (s *OrphanSweeper) Start(ctx context.Context) error {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Reconciler.SweepOrphans(ctx); err != nil {
+			s.Reconciler.Log.Error(err, "Unable to sweep the orphan dns endpoints")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
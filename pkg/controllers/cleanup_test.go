@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+func ownedDNSEndpoint(name string, uid types.UID) *externaldnsk8siov1alpha1.DNSEndpoint {
+	controller := true
+	return &externaldnsk8siov1alpha1.DNSEndpoint{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "cpr-dev",
+			Labels: map[string]string{
+				ControllerIDLabel: "dns.adevinta.com",
+				ClusterNameLabel:  "cluster-1",
+				SourceKindLabel:   "Ingress",
+			},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "networking.k8s.io/v1", Kind: "Ingress", Name: name, UID: uid, Controller: &controller}},
+		},
+		Spec: externaldnsk8siov1alpha1.DNSEndpointSpec{Endpoints: []*externaldnsk8siov1alpha1.Endpoint{{
+			DNSName:          name + ".domain.tld",
+			Targets:          externaldnsk8siov1alpha1.Targets{"lb.domain.tld"},
+			RecordType:       "CNAME",
+			SetIdentifier:    "cluster-1",
+			ProviderSpecific: externaldnsk8siov1alpha1.ProviderSpecific{{Name: "aws/weight", Value: "40"}},
+		}}},
+	}
+}
+
+func TestReleaseOutOfScopeIngresses(t *testing.T) {
+	newReconciler := func(objects ...client.Object) (IngressReconciler, client.Client) {
+		k8sClient := fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(objects...).Build()
+		return IngressReconciler{
+			Client:           k8sClient,
+			Log:              logruslogr.NewLogr(&logrus.Logger{}),
+			AnnotationPrefix: "dns.adevinta.com",
+			ClusterName:      "cluster-1",
+			BindingDomains:   []BindingDomain{{Domain: "domain.tld"}},
+			AnnotationFilter: mustSelector(t, "!skip"),
+			WeightStore:      trafficweight.NewWeightStore(trafficweight.StoreConfig{DesiredWeight: 40, CurrentWeight: 40}),
+		}, k8sClient
+	}
+	key := types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}
+	skipped := func(ing *netv1.Ingress) {
+		ing.UID = "ingress-uid"
+		ing.Annotations = map[string]string{"skip": "true"}
+	}
+
+	t.Run("the dns endpoint is deleted when the ingress stops matching the filters", func(t *testing.T) {
+		reconciler, k8sClient := newReconciler(mockIngress(skipped), ownedDNSEndpoint("test-app", "ingress-uid"))
+		released := metricValue(t, ingressMetrics.ReleasedDNSEndpoints.WithLabelValues(releaseReasonOutOfScope, string(OutOfScopeDelete)))
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		require.NoError(t, err)
+		err = k8sClient.Get(context.Background(), key, &externaldnsk8siov1alpha1.DNSEndpoint{})
+		assert.True(t, apierrors.IsNotFound(err))
+		assert.Equal(t, released+1, metricValue(t, ingressMetrics.ReleasedDNSEndpoints.WithLabelValues(releaseReasonOutOfScope, string(OutOfScopeDelete))))
+	})
+
+	t.Run("the dns endpoint is deleted when the ingress leaves the binding domains", func(t *testing.T) {
+		reconciler, k8sClient := newReconciler(mockIngress(func(ing *netv1.Ingress) {
+			ing.UID = "ingress-uid"
+			ing.Spec.Rules[0].Host = "test-app.other.tld"
+		}), ownedDNSEndpoint("test-app", "ingress-uid"))
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		require.NoError(t, err)
+		err = k8sClient.Get(context.Background(), key, &externaldnsk8siov1alpha1.DNSEndpoint{})
+		assert.True(t, apierrors.IsNotFound(err))
+	})
+
+	t.Run("the weights are zeroed with the zero action", func(t *testing.T) {
+		reconciler, k8sClient := newReconciler(mockIngress(skipped), ownedDNSEndpoint("test-app", "ingress-uid"))
+		reconciler.OutOfScopeAction = OutOfScopeZero
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		require.NoError(t, err)
+		dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{}
+		require.NoError(t, k8sClient.Get(context.Background(), key, dnsEndpoint))
+		assert.Equal(t, "0", dnsEndpoint.Spec.Endpoints[0].ProviderSpecific[0].Value)
+	})
+
+	t.Run("dns endpoints owned by other controllers and dry runs are left untouched", func(t *testing.T) {
+		foreign := ownedDNSEndpoint("test-app", "ingress-uid")
+		foreign.Labels[ControllerIDLabel] = "other.adevinta.com"
+		reconciler, k8sClient := newReconciler(mockIngress(skipped), foreign)
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		require.NoError(t, err)
+		require.NoError(t, k8sClient.Get(context.Background(), key, &externaldnsk8siov1alpha1.DNSEndpoint{}))
+
+		reconciler, k8sClient = newReconciler(mockIngress(skipped), ownedDNSEndpoint("test-app", "ingress-uid"))
+		reconciler.DryRun = true
+		_, err = reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		require.NoError(t, err)
+		require.NoError(t, k8sClient.Get(context.Background(), key, &externaldnsk8siov1alpha1.DNSEndpoint{}))
+	})
+}
+
+func TestSweepOrphans(t *testing.T) {
+	inScope := mockIngress(func(ing *netv1.Ingress) { ing.UID = "in-scope-uid" })
+	outOfScope := mockIngress(func(ing *netv1.Ingress) {
+		ing.Name = "out-of-scope"
+		ing.UID = "out-of-scope-uid"
+		ing.Annotations = map[string]string{"skip": "true"}
+	})
+	foreign := ownedDNSEndpoint("foreign", "foreign-uid")
+	foreign.Labels[ControllerIDLabel] = "other.adevinta.com"
+	legacyOutOfScope := mockIngress(func(ing *netv1.Ingress) {
+		ing.Name = "legacy"
+		ing.UID = "legacy-uid"
+		ing.Annotations = map[string]string{"skip": "true"}
+	})
+	legacy := ownedDNSEndpoint("legacy", "legacy-uid")
+	legacy.Labels = nil
+	k8sClient := fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(
+		inScope, outOfScope, legacyOutOfScope,
+		ownedDNSEndpoint("test-app", "in-scope-uid"),
+		ownedDNSEndpoint("out-of-scope", "out-of-scope-uid"),
+		ownedDNSEndpoint("deleted", "deleted-uid"),
+		foreign,
+		legacy,
+	).Build()
+	reconciler := IngressReconciler{
+		Client:           k8sClient,
+		Log:              logruslogr.NewLogr(&logrus.Logger{}),
+		AnnotationPrefix: "dns.adevinta.com",
+		ClusterName:      "cluster-1",
+		AnnotationFilter: mustSelector(t, "!skip"),
+	}
+
+	reconciler.DryRun = true
+	require.NoError(t, reconciler.SweepOrphans(context.Background()))
+	var dnsEndpoints externaldnsk8siov1alpha1.DNSEndpointList
+	require.NoError(t, k8sClient.List(context.Background(), &dnsEndpoints))
+	assert.Len(t, dnsEndpoints.Items, 5)
+
+	reconciler.DryRun = false
+	orphans := metricValue(t, ingressMetrics.ReleasedDNSEndpoints.WithLabelValues(releaseReasonOrphan, string(OutOfScopeDelete)))
+	require.NoError(t, reconciler.SweepOrphans(context.Background()))
+	require.NoError(t, k8sClient.List(context.Background(), &dnsEndpoints))
+	names := []string{}
+	for _, dnsEndpoint := range dnsEndpoints.Items {
+		names = append(names, dnsEndpoint.Name)
+	}
+	assert.ElementsMatch(t, []string{"test-app", "foreign", "legacy"}, names, "DNSEndpoints owned by other controllers, or without ownership labels and an ingress, are kept")
+	assert.Equal(t, orphans+1, metricValue(t, ingressMetrics.ReleasedDNSEndpoints.WithLabelValues(releaseReasonOrphan, string(OutOfScopeDelete))))
+}
+
+func TestSweepOrphansContinuesOnErrors(t *testing.T) {
+	k8sClient := fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(
+		ownedDNSEndpoint("broken", "broken-uid"),
+		ownedDNSEndpoint("deleted", "deleted-uid"),
+	).WithInterceptorFuncs(interceptor.Funcs{
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			if obj.GetName() == "broken" {
+				return errors.New("delete refused")
+			}
+			return c.Delete(ctx, obj, opts...)
+		},
+	}).Build()
+	reconciler := IngressReconciler{
+		Client:           k8sClient,
+		Log:              logruslogr.NewLogr(&logrus.Logger{}),
+		AnnotationPrefix: "dns.adevinta.com",
+		ClusterName:      "cluster-1",
+	}
+	failures := metricValue(t, ingressMetrics.OrphanSweepErrors.WithLabelValues("cpr-dev", "broken"))
+
+	err := reconciler.SweepOrphans(context.Background())
+	assert.ErrorContains(t, err, "delete refused")
+	assert.ErrorContains(t, err, "cpr-dev/broken")
+	err = k8sClient.Get(context.Background(), types.NamespacedName{Namespace: "cpr-dev", Name: "deleted"}, &externaldnsk8siov1alpha1.DNSEndpoint{})
+	assert.True(t, apierrors.IsNotFound(err), "the DNSEndpoints after the broken one are still swept")
+	assert.Equal(t, failures+1, metricValue(t, ingressMetrics.OrphanSweepErrors.WithLabelValues("cpr-dev", "broken")))
+}
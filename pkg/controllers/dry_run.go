@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	netv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+// endpointChange is a DNS record that differs between the existing and the desired DNSEndpoint
+type endpointChange struct {
+	Action   string
+	DNSName  string
+	Existing string
+	Desired  string
+}
+
+func endpointKey(ep *externaldnsk8siov1alpha1.Endpoint) string {
+	return ep.DNSName + "/" + ep.SetIdentifier
+}
+
+func describeEndpoint(ep *externaldnsk8siov1alpha1.Endpoint) string {
+	properties := make([]string, 0, len(ep.ProviderSpecific))
+	for _, property := range ep.ProviderSpecific {
+		properties = append(properties, property.Name+"="+property.Value)
+	}
+	return fmt.Sprintf("%s %s %v set=%s ttl=%d %v", ep.DNSName, ep.RecordType, []string(ep.Targets), ep.SetIdentifier, ep.RecordTTL, properties)
+}
+
+// diffEndpoints returns the records to create, update and delete to go from existing to desired
+func diffEndpoints(existing, desired []*externaldnsk8siov1alpha1.Endpoint) []endpointChange {
+	existingByKey := map[string]*externaldnsk8siov1alpha1.Endpoint{}
+	for _, ep := range existing {
+		existingByKey[endpointKey(ep)] = ep
+	}
+	changes := []endpointChange{}
+	for _, ep := range desired {
+		previous, ok := existingByKey[endpointKey(ep)]
+		delete(existingByKey, endpointKey(ep))
+		switch {
+		case !ok:
+			changes = append(changes, endpointChange{Action: "create", DNSName: ep.DNSName, Desired: describeEndpoint(ep)})
+		case !reflect.DeepEqual(previous, ep):
+			changes = append(changes, endpointChange{Action: "update", DNSName: ep.DNSName, Existing: describeEndpoint(previous), Desired: describeEndpoint(ep)})
+		}
+	}
+	for _, ep := range existingByKey {
+		changes = append(changes, endpointChange{Action: "delete", DNSName: ep.DNSName, Existing: describeEndpoint(ep)})
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].DNSName < changes[j].DNSName })
+	return changes
+}
+
+// WeightCalculationError is returned when the DNSEndpoint of an ingress can
+// not be computed, it is reported in the ingress status rather than retried
+type WeightCalculationError struct {
+	Err error
+}
+
+func (e *WeightCalculationError) Error() string {
+	return fmt.Sprintf("unable to calculate the DNS weights: %v", e.Err)
+}
+
+func (e *WeightCalculationError) Unwrap() error { return e.Err }
+
+// dryRunDNSEntries computes the DNSEndpoint of the ingress and reports how it
+// differs from the existing one, without writing it.
+// It returns the hosts of the computed DNSEndpoint, or a WeightCalculationError
+// when it can not be computed.
+func (r *IngressReconciler) dryRunDNSEntries(ctx context.Context, ingress netv1.Ingress, target string, ownerRef metav1.OwnerReference) ([]hostWeight, error) {
+	existing := &externaldnsk8siov1alpha1.DNSEndpoint{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(&ingress), existing)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	desired := existing.DeepCopy()
+	hosts, err := r.newDnsEndpoint(ctx, desired, target, ingress, ownerRef)
+	if err != nil {
+		// Like CreateOrUpdate, the existing records are kept
+		return nil, &WeightCalculationError{Err: err}
+	}
+	r.logChanges(ingress.Namespace, ingress.Name, diffEndpoints(existing.Spec.Endpoints, desired.Spec.Endpoints))
+	return hosts, nil
+}
+
+// logChanges logs the pending changes of a DNSEndpoint in dry-run mode and exposes their number
+func (r *IngressReconciler) logChanges(namespace, name string, changes []endpointChange) {
+	ingressMetrics.PendingChanges.WithLabelValues(namespace, name).Set(float64(len(changes)))
+	log := r.Log.WithValues("IngressName", name, "IngressNamespace", namespace, "DryRun", true)
+	if len(changes) == 0 {
+		log.V(1).Info("DNS endpoint up to date")
+		return
+	}
+	summary := make([]string, 0, len(changes))
+	for _, change := range changes {
+		summary = append(summary, change.Action+" "+change.DNSName)
+	}
+	log.Info("DNS endpoint would be changed", "changes", strings.Join(summary, ", "))
+	for _, change := range changes {
+		log.Info("DNS record would be changed", "action", change.Action, "host", change.DNSName, "existing", change.Existing, "desired", change.Desired)
+	}
+}
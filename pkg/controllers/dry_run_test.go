@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestDiffEndpoints(t *testing.T) {
+	record := func(host, weight string) *externaldnsk8siov1alpha1.Endpoint {
+		return &externaldnsk8siov1alpha1.Endpoint{
+			DNSName:          host,
+			Targets:          externaldnsk8siov1alpha1.Targets{"lb.domain.tld"},
+			RecordType:       "CNAME",
+			SetIdentifier:    "cluster-1",
+			ProviderSpecific: externaldnsk8siov1alpha1.ProviderSpecific{{Name: "aws/weight", Value: weight}},
+		}
+	}
+
+	assert.Empty(t, diffEndpoints(
+		[]*externaldnsk8siov1alpha1.Endpoint{record("a.domain.tld", "10")},
+		[]*externaldnsk8siov1alpha1.Endpoint{record("a.domain.tld", "10")},
+	))
+
+	changes := diffEndpoints(
+		[]*externaldnsk8siov1alpha1.Endpoint{record("a.domain.tld", "10"), record("b.domain.tld", "10")},
+		[]*externaldnsk8siov1alpha1.Endpoint{record("a.domain.tld", "20"), record("c.domain.tld", "10")},
+	)
+	require.Len(t, changes, 3)
+	assert.Equal(t, "update", changes[0].Action)
+	assert.Equal(t, "a.domain.tld", changes[0].DNSName)
+	assert.Contains(t, changes[0].Existing, "aws/weight=10")
+	assert.Contains(t, changes[0].Desired, "aws/weight=20")
+	assert.Equal(t, endpointChange{Action: "delete", DNSName: "b.domain.tld", Existing: describeEndpoint(record("b.domain.tld", "10"))}, changes[1])
+	assert.Equal(t, endpointChange{Action: "create", DNSName: "c.domain.tld", Desired: describeEndpoint(record("c.domain.tld", "10"))}, changes[2])
+}
+
+func TestDryRunReconcile(t *testing.T) {
+	newReconciler := func(objects ...client.Object) (IngressReconciler, client.Client) {
+		k8sClient := fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(objects...).Build()
+		return IngressReconciler{
+			Client:           k8sClient,
+			Log:              logruslogr.NewLogr(&logrus.Logger{}),
+			AnnotationPrefix: "dns.adevinta.com",
+			ClusterName:      "cluster-1",
+			DryRun:           true,
+			WeightStore: trafficweight.NewWeightStore(trafficweight.StoreConfig{
+				DesiredWeight: 100,
+				CurrentWeight: 100,
+			}),
+		}, k8sClient
+	}
+
+	t.Run("missing DNSEndpoints are not created", func(t *testing.T) {
+		reconciler, k8sClient := newReconciler(
+			mockIngress(withObjectNamespace[*netv1.Ingress]("dry-run-create")),
+			mockEndpoint(epWithName("test-app"), withObjectNamespace[*v1.Endpoints]("dry-run-create")),
+			mockEndpoint(epWithName("test-app-a"), withObjectNamespace[*v1.Endpoints]("dry-run-create")),
+		)
+		key := types.NamespacedName{Namespace: "dry-run-create", Name: "test-app"}
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		require.NoError(t, err)
+
+		err = k8sClient.Get(context.Background(), key, &externaldnsk8siov1alpha1.DNSEndpoint{})
+		assert.True(t, apierrors.IsNotFound(err))
+		assert.Equal(t, 1.0, metricValue(t, ingressMetrics.PendingChanges.WithLabelValues("dry-run-create", "test-app")))
+		assert.Equal(t, 100.0, metricValue(t, ingressMetrics.HostWeight.WithLabelValues("dry-run-create", "test-app", "test-app.domain.tld")))
+
+		ingress := &netv1.Ingress{}
+		require.NoError(t, k8sClient.Get(context.Background(), key, ingress))
+		assert.Empty(t, ingress.Annotations)
+	})
+
+	t.Run("existing DNSEndpoints are not updated", func(t *testing.T) {
+		existing := &externaldnsk8siov1alpha1.DNSEndpoint{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "dry-run-update", Name: "test-app", Labels: map[string]string{
+				ControllerIDLabel: "dns.adevinta.com",
+				ClusterNameLabel:  "cluster-1",
+				SourceKindLabel:   "Ingress",
+			}},
+			Spec: externaldnsk8siov1alpha1.DNSEndpointSpec{
+				Endpoints: []*externaldnsk8siov1alpha1.Endpoint{{DNSName: "test-app.domain.tld", SetIdentifier: "cluster-1"}},
+			},
+		}
+		reconciler, k8sClient := newReconciler(
+			existing,
+			mockIngress(withObjectNamespace[*netv1.Ingress]("dry-run-update")),
+			mockEndpoint(epWithName("test-app"), withObjectNamespace[*v1.Endpoints]("dry-run-update")),
+			mockEndpoint(epWithName("test-app-a"), withObjectNamespace[*v1.Endpoints]("dry-run-update")),
+		)
+		key := types.NamespacedName{Namespace: "dry-run-update", Name: "test-app"}
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		require.NoError(t, err)
+
+		dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{}
+		require.NoError(t, k8sClient.Get(context.Background(), key, dnsEndpoint))
+		assert.Equal(t, existing.Spec, dnsEndpoint.Spec)
+		assert.Equal(t, 1.0, metricValue(t, ingressMetrics.PendingChanges.WithLabelValues("dry-run-update", "test-app")))
+	})
+
+	t.Run("weight calculation errors are typed", func(t *testing.T) {
+		ingress := mockIngress(withObjectNamespace[*netv1.Ingress]("dry-run-invalid"))
+		ingress.Annotations = map[string]string{"dns.adevinta.com/record-ttl": "soon"}
+		reconciler, _ := newReconciler(ingress)
+		hosts, err := reconciler.dryRunDNSEntries(context.Background(), *ingress, "lb.domain.tld", metav1.OwnerReference{})
+		assert.Empty(t, hosts)
+		var calculationErr *WeightCalculationError
+		require.ErrorAs(t, err, &calculationErr)
+		var recordTTLErr *InvalidRecordTTLError
+		assert.ErrorAs(t, calculationErr.Err, &recordTTLErr)
+	})
+
+	t.Run("DNSEndpoints of deleted ingresses are not deleted", func(t *testing.T) {
+		existing := &externaldnsk8siov1alpha1.DNSEndpoint{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "dry-run-delete", Name: "test-app"},
+		}
+		reconciler, k8sClient := newReconciler(existing)
+		key := types.NamespacedName{Namespace: "dry-run-delete", Name: "test-app"}
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		require.NoError(t, err)
+		assert.NoError(t, k8sClient.Get(context.Background(), key, &externaldnsk8siov1alpha1.DNSEndpoint{}))
+	})
+}
@@ -14,6 +14,8 @@ import (
 // EndpointReconciler reconciles a Endpoint object
 type endpointsMapper struct {
 	client.Client
+	// Filter, when set, skips the ingresses out of the scope of the controller
+	Filter func(netv1.Ingress) bool
 }
 
 var _ handler.MapFunc = (&endpointsMapper{}).mapToIngressRequests
@@ -32,6 +34,19 @@ func (r *endpointsMapper) mapToIngressRequests(ctx context.Context, object clien
 	}
 
 	for _, ing := range ingresses.Items {
+		if r.Filter != nil && !r.Filter(ing) {
+			continue
+		}
+		// The default backend serves the TLS hosts without rule
+		if backend := ing.Spec.DefaultBackend; backend != nil && backend.Service != nil && backend.Service.Name == object.GetName() {
+			reqs = append(reqs, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: ing.GetNamespace(),
+					Name:      ing.GetName(),
+				},
+			})
+			continue
+		}
 		for _, rule := range ing.Spec.Rules {
 			//cover empty HTTP rule case
 			if rule.HTTP != nil {
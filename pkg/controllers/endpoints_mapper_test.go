@@ -136,3 +136,22 @@ func TestEndpointsMappingExcludesOtherNamespaces(t *testing.T) {
 		requests,
 	)
 }
+
+func TestEndpointsMappingIncludesDefaultBackends(t *testing.T) {
+	ingress := mockIngress(func(ing *netv1.Ingress) {
+		ing.Spec.DefaultBackend = &netv1.IngressBackend{Service: &netv1.IngressServiceBackend{Name: "default"}}
+	})
+	defaultEndpoint := mockEndpoint(epWithName("default"))
+
+	mapper := endpointsMapper{
+		Client: fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(defaultEndpoint, ingress).Build(),
+	}
+	requests := mapper.mapToIngressRequests(context.Background(), defaultEndpoint)
+
+	assert.Equal(t,
+		[]reconcile.Request{
+			{NamespacedName: types.NamespacedName{Namespace: ingress.GetNamespace(), Name: ingress.GetName()}},
+		},
+		requests,
+	)
+}
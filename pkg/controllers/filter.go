@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// legacyIngressClassAnnotation is used by the ingresses created before spec.ingressClassName
+const legacyIngressClassAnnotation = "kubernetes.io/ingress.class"
+
+// NewSelector parses a label selector expression, like "team in (a,b),!legacy".
+// Empty expressions select everything.
+func NewSelector(expression string) (labels.Selector, error) {
+	selector, err := labels.Parse(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %w", expression, err)
+	}
+	return selector, nil
+}
+
+// NewAnnotationFilter parses a label selector expression evaluated on the ingress annotations
+func NewAnnotationFilter(filter string) (labels.Selector, error) {
+	return NewSelector(filter)
+}
+
+func selectorMatches(selector labels.Selector, set map[string]string) bool {
+	return selector == nil || selector.Matches(labels.Set(set))
+}
+
+func ingressClassName(ingress netv1.Ingress) string {
+	if ingress.Spec.IngressClassName != nil {
+		return *ingress.Spec.IngressClassName
+	}
+	return ingress.Annotations[legacyIngressClassAnnotation]
+}
+
+// ingressMatchesObjectFilters tells whether the ingress matches the filters
+// that only depend on the ingress itself
+func (r *IngressReconciler) ingressMatchesObjectFilters(ingress netv1.Ingress) bool {
+	if !selectorMatches(r.AnnotationFilter, ingress.Annotations) || !selectorMatches(r.LabelFilter, ingress.Labels) {
+		return false
+	}
+	return r.IngressClass == "" || ingressClassName(ingress) == r.IngressClass
+}
+
+// ingressMatchesFilter tells whether the ingress is handled by this controller
+func (r *IngressReconciler) ingressMatchesFilter(ctx context.Context, ingress netv1.Ingress) (bool, error) {
+	if !r.ingressMatchesObjectFilters(ingress) {
+		return false, nil
+	}
+	if r.NamespaceSelector == nil || r.NamespaceSelector.Empty() {
+		return true, nil
+	}
+	namespace := v1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: ingress.Namespace}, &namespace); err != nil {
+		return false, err
+	}
+	return r.NamespaceSelector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// InScope tells whether the events of the ingress are reconciled, see ingressPredicate
+func (r *IngressReconciler) InScope(ctx context.Context, ingress *netv1.Ingress) bool {
+	matches, err := r.ingressMatchesFilter(ctx, *ingress)
+	// Let Reconcile retry when the namespace can not be read
+	return matches || err != nil
+}
+
+// ingressPredicate drops the events of the ingresses out of the scope of the
+// controller. Updates are kept when either version matches, so the ingresses
+// leaving the scope are reconciled once more.
+func (r *IngressReconciler) ingressPredicate() predicate.Funcs {
+	matches := func(obj client.Object) bool {
+		ingress, ok := obj.(*netv1.Ingress)
+		if !ok {
+			return true
+		}
+		return r.InScope(context.Background(), ingress)
+	}
+	return predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return matches(e.Object) },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return matches(e.Object) },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return matches(e.ObjectOld) || matches(e.ObjectNew) },
+		GenericFunc: func(e event.GenericEvent) bool { return matches(e.Object) },
+	}
+}
+
+// mapNamespaceToIngresses reconciles the ingresses of a namespace when its labels change
+func (r *IngressReconciler) mapNamespaceToIngresses(ctx context.Context, object client.Object) []reconcile.Request {
+	var ingresses netv1.IngressList
+	if err := r.List(ctx, &ingresses, client.InNamespace(object.GetName())); err != nil {
+		r.Log.Error(err, "Unable to list the ingresses of the namespace", "namespace", object.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(ingresses.Items))
+	for _, ingress := range ingresses.Items {
+		if r.ingressMatchesObjectFilters(ingress) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&ingress)})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func mustSelector(t *testing.T, expression string) labels.Selector {
+	t.Helper()
+	selector, err := NewSelector(expression)
+	require.NoError(t, err)
+	return selector
+}
+
+func TestIngressFilters(t *testing.T) {
+	ingress := func(annotations, labels map[string]string, class string) netv1.Ingress {
+		return *mockIngress(func(ing *netv1.Ingress) {
+			ing.Annotations = annotations
+			ing.Labels = labels
+			if class != "" {
+				ing.Spec.IngressClassName = &class
+			}
+		})
+	}
+
+	t.Run("annotation selectors", func(t *testing.T) {
+		reconciler := IngressReconciler{AnnotationFilter: mustSelector(t, "team in (payments,search),!legacy,owner")}
+		assert.True(t, reconciler.ingressMatchesObjectFilters(ingress(map[string]string{"team": "search", "owner": "me"}, nil, "")))
+		assert.False(t, reconciler.ingressMatchesObjectFilters(ingress(map[string]string{"team": "ads", "owner": "me"}, nil, "")))
+		assert.False(t, reconciler.ingressMatchesObjectFilters(ingress(map[string]string{"team": "search", "owner": "me", "legacy": "true"}, nil, "")))
+		assert.False(t, reconciler.ingressMatchesObjectFilters(ingress(map[string]string{"team": "search"}, nil, "")))
+
+		reconciler = IngressReconciler{AnnotationFilter: mustSelector(t, "team notin (ads)")}
+		assert.True(t, reconciler.ingressMatchesObjectFilters(ingress(nil, nil, "")))
+		assert.False(t, reconciler.ingressMatchesObjectFilters(ingress(map[string]string{"team": "ads"}, nil, "")))
+	})
+
+	t.Run("label selectors and ingress classes", func(t *testing.T) {
+		reconciler := IngressReconciler{LabelFilter: mustSelector(t, "tier=frontend"), IngressClass: "public"}
+		assert.True(t, reconciler.ingressMatchesObjectFilters(ingress(nil, map[string]string{"tier": "frontend"}, "public")))
+		assert.True(t, reconciler.ingressMatchesObjectFilters(ingress(map[string]string{legacyIngressClassAnnotation: "public"}, map[string]string{"tier": "frontend"}, "")))
+		assert.False(t, reconciler.ingressMatchesObjectFilters(ingress(nil, map[string]string{"tier": "frontend"}, "private")))
+		assert.False(t, reconciler.ingressMatchesObjectFilters(ingress(map[string]string{"tier": "frontend"}, nil, "public")))
+	})
+
+	t.Run("empty filters match everything", func(t *testing.T) {
+		reconciler := IngressReconciler{AnnotationFilter: mustSelector(t, "")}
+		assert.True(t, reconciler.ingressMatchesObjectFilters(ingress(nil, nil, "")))
+		assert.True(t, (&IngressReconciler{}).ingressMatchesObjectFilters(ingress(nil, nil, "")))
+	})
+
+	t.Run("malformed selectors are rejected", func(t *testing.T) {
+		for _, invalid := range []string{"team in (a", "team in a", "=value", "team=not a value"} {
+			_, err := NewAnnotationFilter(invalid)
+			assert.Error(t, err, invalid)
+		}
+	})
+}
+
+func TestNamespaceSelector(t *testing.T) {
+	enabled := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "enabled", Labels: map[string]string{"traffic": "on"}}}
+	disabled := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "disabled"}}
+	inEnabled := mockIngress(withObjectNamespace[*netv1.Ingress]("enabled"))
+	inDisabled := mockIngress(withObjectNamespace[*netv1.Ingress]("disabled"))
+	filtered := mockIngress(withObjectNamespace[*netv1.Ingress]("enabled"), func(ing *netv1.Ingress) {
+		ing.Name = "filtered"
+		ing.Annotations = map[string]string{"skip": "true"}
+	})
+	reconciler := IngressReconciler{
+		Client:            fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(enabled, disabled, inEnabled, inDisabled, filtered).Build(),
+		Log:               logruslogr.NewLogr(&logrus.Logger{}),
+		AnnotationFilter:  mustSelector(t, "!skip"),
+		NamespaceSelector: mustSelector(t, "traffic=on"),
+	}
+
+	matches, err := reconciler.ingressMatchesFilter(context.Background(), *inEnabled)
+	require.NoError(t, err)
+	assert.True(t, matches)
+	matches, err = reconciler.ingressMatchesFilter(context.Background(), *inDisabled)
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	assert.Equal(t,
+		[]reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "enabled", Name: "test-app"}}},
+		reconciler.mapNamespaceToIngresses(context.Background(), enabled),
+	)
+
+	predicate := reconciler.ingressPredicate()
+	assert.True(t, predicate.Create(event.CreateEvent{Object: inEnabled}))
+	assert.False(t, predicate.Create(event.CreateEvent{Object: inDisabled}))
+	assert.False(t, predicate.Generic(event.GenericEvent{Object: filtered}))
+	assert.True(t, predicate.Update(event.UpdateEvent{ObjectOld: inEnabled, ObjectNew: filtered}), "ingresses leaving the scope are reconciled")
+	assert.False(t, predicate.Update(event.UpdateEvent{ObjectOld: inDisabled, ObjectNew: inDisabled}))
+}
+
+func TestIngressPredicateWithIngressClassName(t *testing.T) {
+	public, internal := "public", "internal"
+	reconciler := IngressReconciler{
+		Client:       fake.NewClientBuilder().WithScheme(NewScheme()).Build(),
+		Log:          logruslogr.NewLogr(&logrus.Logger{}),
+		IngressClass: "public",
+	}
+	predicate := reconciler.ingressPredicate()
+
+	// The weight changes are sent as generic events with the listed ingresses
+	assert.True(t, predicate.Generic(event.GenericEvent{Object: mockIngress(func(ing *netv1.Ingress) { ing.Spec.IngressClassName = &public })}))
+	assert.False(t, predicate.Generic(event.GenericEvent{Object: mockIngress(func(ing *netv1.Ingress) { ing.Spec.IngressClassName = &internal })}))
+}
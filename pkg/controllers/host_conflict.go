@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	netv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+// hostIndex is the field index of the ingresses by the normalized hosts they get records for
+const hostIndex = "traffic-controller.hosts"
+
+type HostConflictPolicy string
+
+const (
+	// HostConflictMerge writes a single record for a host declared by several
+	// ingresses with the same load balancer, with the lowest of their weights,
+	// zeroed when any of them has no ready pods. The ingress controller merges
+	// the paths of these ingresses, so the record serves all of them.
+	// Ingresses with different load balancers are handled as with HostConflictOldest.
+	HostConflictMerge HostConflictPolicy = "merge"
+	// HostConflictOldest writes the record of a host declared by several
+	// ingresses from the oldest one only
+	HostConflictOldest HostConflictPolicy = "oldest"
+)
+
+func ParseHostConflictPolicy(policy string) (HostConflictPolicy, error) {
+	switch HostConflictPolicy(policy) {
+	case HostConflictMerge, HostConflictOldest:
+		return HostConflictPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unknown host conflict policy %q, valid values are %q and %q", policy, HostConflictMerge, HostConflictOldest)
+	}
+}
+
+// hostConflict describes a host of an ingress declared by other ingresses of the cluster
+type hostConflict struct {
+	// ingresses are the other ingresses declaring the host, as namespace/name
+	ingresses []string
+	// owner is the ingress writing the record of the host, empty when it is this one
+	owner string
+	// merged is set when the record gets the weight and readiness of all the ingresses
+	merged bool
+	// weight is the lowest weight of the other ingresses, when merged
+	weight uint
+	// withoutPods is set when the services of the other ingresses have no ready pods, when merged
+	withoutPods bool
+}
+
+// hostClaim is an ingress declaring a host
+type hostClaim struct {
+	ingress netv1.Ingress
+	rule    netv1.IngressRule
+}
+
+// claimedBefore orders the ingresses declaring the same host, the oldest first
+func claimedBefore(a, b netv1.Ingress) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return client.ObjectKeyFromObject(&a).String() < client.ObjectKeyFromObject(&b).String()
+}
+
+// boundHosts returns the normalized hosts the ingress gets records for, with their rules
+func (r *IngressReconciler) boundHosts(ingress netv1.Ingress) (map[string]netv1.IngressRule, error) {
+	rules, err := r.hostRules(ingress)
+	if err != nil {
+		return nil, err
+	}
+	hosts := map[string]netv1.IngressRule{}
+	for _, rule := range r.filterIngressRulesByHost(rules) {
+		if _, ok := hosts[normalizeDomain(rule.Host)]; !ok {
+			hosts[normalizeDomain(rule.Host)] = rule
+		}
+	}
+	return hosts, nil
+}
+
+// indexHosts returns the hostIndex values of the ingress
+func (r *IngressReconciler) indexHosts(object client.Object) []string {
+	ingress, ok := object.(*netv1.Ingress)
+	if !ok {
+		return nil
+	}
+	hosts, err := r.boundHosts(*ingress)
+	if err != nil {
+		return nil
+	}
+	values := make([]string, 0, len(hosts))
+	for host := range hosts {
+		values = append(values, host)
+	}
+	return values
+}
+
+// listHostCandidates returns the ingresses that may declare one of the normalized
+// hosts, all of them when the ingresses are not indexed by hostIndex
+func (r *IngressReconciler) listHostCandidates(ctx context.Context, hosts map[string]bool) ([]netv1.Ingress, error) {
+	if !r.hostsIndexed {
+		var ingresses netv1.IngressList
+		if err := r.List(ctx, &ingresses); err != nil {
+			return nil, err
+		}
+		return ingresses.Items, nil
+	}
+	seen := map[client.ObjectKey]bool{}
+	candidates := []netv1.Ingress{}
+	for host := range hosts {
+		var ingresses netv1.IngressList
+		if err := r.List(ctx, &ingresses, client.MatchingFields{hostIndex: host}); err != nil {
+			return nil, err
+		}
+		for _, ingress := range ingresses.Items {
+			if key := client.ObjectKeyFromObject(&ingress); !seen[key] {
+				seen[key] = true
+				candidates = append(candidates, ingress)
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// hostClaims returns, for the given hosts, the other ingresses handled by the
+// controller declaring them with the same set identifier
+func (r *IngressReconciler) hostClaims(ctx context.Context, ingress netv1.Ingress, hosts []string) (map[string][]hostClaim, error) {
+	// Records with different set identifiers are distinct weighted records
+	return r.identifiedHostClaims(ctx, ingress, hosts, r.setIdentifier)
+}
+
+// identifiedHostClaims returns, for the given hosts, the other ingresses
+// handled by the controller declaring them with the same identifier. The
+// ingresses whose identifier can not be rendered are left out.
+func (r *IngressReconciler) identifiedHostClaims(ctx context.Context, ingress netv1.Ingress, hosts []string, identifier func(netv1.Ingress) (string, error)) (map[string][]hostClaim, error) {
+	setIdentifier, err := identifier(ingress)
+	if err != nil {
+		return nil, err
+	}
+	wanted := map[string]bool{}
+	for _, host := range hosts {
+		wanted[normalizeDomain(host)] = true
+	}
+	candidates, err := r.listHostCandidates(ctx, wanted)
+	if err != nil {
+		return nil, err
+	}
+	claims := map[string][]hostClaim{}
+	for _, other := range candidates {
+		if other.Namespace == ingress.Namespace && other.Name == ingress.Name || !other.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if matches, err := r.ingressMatchesFilter(ctx, other); err != nil || !matches {
+			continue
+		}
+		if otherSetIdentifier, err := identifier(other); err != nil || otherSetIdentifier != setIdentifier {
+			continue
+		}
+		otherHosts, err := r.boundHosts(other)
+		if err != nil {
+			// The ingress gets no record
+			continue
+		}
+		for host, rule := range otherHosts {
+			if wanted[host] {
+				claims[host] = append(claims[host], hostClaim{ingress: other, rule: rule})
+			}
+		}
+	}
+	return claims, nil
+}
+
+// hostConflicts resolves the hosts of the ingress also declared by other
+// ingresses, as configured by HostConflictPolicy
+func (r *IngressReconciler) hostConflicts(ctx context.Context, ingress netv1.Ingress, target string, rules []netv1.IngressRule, store trafficweight.StoreConfig) map[string]*hostConflict {
+	hosts := []string{}
+	for _, rule := range rules {
+		hosts = append(hosts, rule.Host)
+	}
+	claims, err := r.hostClaims(ctx, ingress, hosts)
+	if err != nil {
+		r.Log.WithValues("IngressName", ingress.Name, "IngressNamespace", ingress.Namespace).Error(err, "Unable to check the hosts declared by other ingresses")
+		return nil
+	}
+	conflicts := map[string]*hostConflict{}
+	for host, claimed := range claims {
+		// The host is not handed over to an ingress unable to write its record
+		others := []hostClaim{}
+		for _, other := range claimed {
+			if r.publishes(ctx, other.ingress, store) {
+				others = append(others, other)
+			}
+		}
+		if len(others) == 0 {
+			continue
+		}
+		sort.Slice(others, func(i, j int) bool { return claimedBefore(others[i].ingress, others[j].ingress) })
+		conflict := &hostConflict{}
+		for _, other := range others {
+			conflict.ingresses = append(conflict.ingresses, client.ObjectKeyFromObject(&other.ingress).String())
+		}
+		if claimedBefore(others[0].ingress, ingress) {
+			conflict.owner = conflict.ingresses[0]
+		}
+		if r.HostConflictPolicy != HostConflictOldest && r.mergeable(target, others) {
+			conflict.merged = true
+			conflict.weight = uint(store.DesiredWeight)
+			for _, other := range others {
+				weight := uint(store.DesiredWeight)
+				if r.isIngressWeighted(other.ingress) {
+					weight, err = r.calculateIngressWeight(other.ingress, store.DesiredWeight)
+					if err != nil {
+						// The ingress gets no record
+						continue
+					}
+				}
+				conflict.weight = min(conflict.weight, weight)
+				conflict.withoutPods = conflict.withoutPods || !r.ingressRuleHasPods(ctx, other.ingress.Namespace, &other.rule)
+			}
+		}
+		conflicts[host] = conflict
+	}
+	return conflicts
+}
+
+// publishes tells whether the ingress gets the records of the hosts it owns
+// written: it has a load balancer, a valid weight and record ttl, and its
+// DNSEndpoint is not owned by someone else
+func (r *IngressReconciler) publishes(ctx context.Context, ingress netv1.Ingress, store trafficweight.StoreConfig) bool {
+	if _, err := r.getTargetFromIngress(ingress); err != nil {
+		return false
+	}
+	if r.isIngressWeighted(ingress) {
+		if _, err := r.calculateIngressWeight(ingress, store.DesiredWeight); err != nil {
+			return false
+		}
+	}
+	if _, err := r.ingressRecordTTL(ingress); err != nil {
+		return false
+	}
+	existing := &externaldnsk8siov1alpha1.DNSEndpoint{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(&ingress), existing)
+	if apierrors.IsNotFound(err) {
+		return true
+	}
+	return err == nil && r.ownsDNSEndpoint(existing, &ingress)
+}
+
+// mergeable tells whether the ingresses declaring a host share the load balancer target
+func (r *IngressReconciler) mergeable(target string, claims []hostClaim) bool {
+	for _, claim := range claims {
+		if otherTarget, err := r.getTargetFromIngress(claim.ingress); err != nil || otherTarget != target {
+			return false
+		}
+	}
+	return true
+}
+
+// mapHostConflicts reconciles the ingresses declaring a host of the given one,
+// so they take the hosts over, or report the conflict
+func (r *IngressReconciler) mapHostConflicts(ctx context.Context, object client.Object) []reconcile.Request {
+	ingress, ok := object.(*netv1.Ingress)
+	if !ok {
+		return nil
+	}
+	hosts, err := r.boundHosts(*ingress)
+	if err != nil || len(hosts) == 0 {
+		return nil
+	}
+	names := []string{}
+	for host := range hosts {
+		names = append(names, host)
+	}
+	claims, err := r.hostClaims(ctx, *ingress, names)
+	if err != nil {
+		r.Log.Error(err, "Unable to list the ingresses declaring the same hosts", "IngressName", ingress.Name, "IngressNamespace", ingress.Namespace)
+		return nil
+	}
+	drained := map[string][]hostClaim{}
+	if r.PreviousSetIdentifier != nil {
+		// The ingresses draining the same previous records take them over
+		drained, err = r.identifiedHostClaims(ctx, *ingress, names, r.drainedSetIdentifier)
+		if err != nil && !errors.Is(err, errNotMigrating) {
+			r.Log.Error(err, "Unable to list the ingresses draining the same records", "IngressName", ingress.Name, "IngressNamespace", ingress.Namespace)
+		}
+	}
+	seen := map[client.ObjectKey]bool{}
+	requests := []reconcile.Request{}
+	for _, hostClaims := range []map[string][]hostClaim{claims, drained} {
+		for _, others := range hostClaims {
+			for _, other := range others {
+				key := client.ObjectKeyFromObject(&other.ingress)
+				if !seen[key] {
+					seen[key] = true
+					requests = append(requests, reconcile.Request{NamespacedName: key})
+				}
+			}
+		}
+	}
+	return requests
+}
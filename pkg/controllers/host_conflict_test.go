@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestHostConflicts(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	older := mockIngress(func(ing *netv1.Ingress) {
+		ing.Name = "older"
+		ing.CreationTimestamp = metav1.NewTime(created)
+	})
+	newer := func(mutators ...func(*netv1.Ingress)) *netv1.Ingress {
+		return mockIngress(append([]func(*netv1.Ingress){func(ing *netv1.Ingress) {
+			ing.Name = "newer"
+			ing.CreationTimestamp = metav1.NewTime(created.Add(time.Hour))
+			ing.Spec.Rules = append(ing.Spec.Rules, netv1.IngressRule{Host: "newer.domain.tld", IngressRuleValue: ing.Spec.Rules[0].IngressRuleValue})
+		}}, mutators...)...)
+	}
+	reconcileAll := func(t *testing.T, policy HostConflictPolicy, objects ...client.Object) client.Client {
+		t.Helper()
+		objects = append(objects, mockEndpoint(epWithName("test-app")), mockEndpoint(epWithName("test-app-a")))
+		reconciler := IngressReconciler{
+			Log:                logruslogr.NewLogr(&logrus.Logger{}),
+			AnnotationPrefix:   "dns.adevinta.com",
+			ClusterName:        "cluster-1",
+			HostConflictPolicy: policy,
+			WeightStore:        trafficweight.NewWeightStore(trafficweight.StoreConfig{DesiredWeight: 40, CurrentWeight: 40}),
+			hostsIndexed:       true,
+		}
+		k8sClient := fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(objects...).WithIndex(&netv1.Ingress{}, hostIndex, reconciler.indexHosts).Build()
+		reconciler.Client = k8sClient
+		for _, name := range []string{"older", "newer"} {
+			_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "cpr-dev", Name: name}})
+			require.NoError(t, err)
+		}
+		return k8sClient
+	}
+	records := func(t *testing.T, k8sClient client.Client, name string) map[string]string {
+		t.Helper()
+		dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{}
+		require.NoError(t, k8sClient.Get(context.Background(), types.NamespacedName{Namespace: "cpr-dev", Name: name}, dnsEndpoint))
+		weights := map[string]string{}
+		for _, endpoint := range dnsEndpoint.Spec.Endpoints {
+			weights[endpoint.DNSName] = endpoint.ProviderSpecific[0].Value
+		}
+		return weights
+	}
+	status := func(t *testing.T, k8sClient client.Client, name string) trafficStatus {
+		t.Helper()
+		ingress := netv1.Ingress{}
+		require.NoError(t, k8sClient.Get(context.Background(), types.NamespacedName{Namespace: "cpr-dev", Name: name}, &ingress))
+		status := trafficStatus{}
+		require.NoError(t, json.Unmarshal([]byte(ingress.Annotations["dns.adevinta.com/traffic-status"]), &status))
+		return status
+	}
+
+	t.Run("the oldest ingress writes the record", func(t *testing.T) {
+		k8sClient := reconcileAll(t, HostConflictOldest, older.DeepCopy(), newer())
+
+		assert.Equal(t, map[string]string{"test-app.domain.tld": "40"}, records(t, k8sClient, "older"))
+		assert.Equal(t, map[string]string{"newer.domain.tld": "40"}, records(t, k8sClient, "newer"))
+
+		olderStatus := status(t, k8sClient, "older")
+		assert.Equal(t, ReasonHostConflict, olderStatus.Reason)
+		assert.Contains(t, olderStatus.Message, "test-app.domain.tld is also declared by cpr-dev/newer, its record is written by this ingress")
+		newerStatus := status(t, k8sClient, "newer")
+		assert.Equal(t, ReasonHostConflict, newerStatus.Reason)
+		assert.Contains(t, newerStatus.Message, "test-app.domain.tld is also declared by cpr-dev/older, its record is written by cpr-dev/older")
+		assert.Equal(t, map[string]uint{"newer.domain.tld": 40}, newerStatus.Hosts)
+		assert.Equal(t, 1.0, metricValue(t, ingressMetrics.HostConflicts.WithLabelValues("cpr-dev", "newer", "test-app.domain.tld")))
+	})
+
+	t.Run("ingresses sharing the load balancer are merged", func(t *testing.T) {
+		weighted := newer(func(ing *netv1.Ingress) {
+			ing.Annotations = map[string]string{"dns.adevinta.com/traffic-weight": "50"}
+		})
+		k8sClient := reconcileAll(t, HostConflictMerge, older.DeepCopy(), weighted)
+
+		assert.Equal(t, map[string]string{"test-app.domain.tld": "20"}, records(t, k8sClient, "older"), "the lowest weight is kept")
+		assert.Equal(t, map[string]string{"newer.domain.tld": "20"}, records(t, k8sClient, "newer"))
+		assert.Contains(t, status(t, k8sClient, "older").Message, "test-app.domain.tld is also declared by cpr-dev/newer, merged in the record written by this ingress")
+	})
+
+	t.Run("ingresses with different load balancers are not merged", func(t *testing.T) {
+		otherLB := newer(func(ing *netv1.Ingress) {
+			ing.Annotations = map[string]string{"dns.adevinta.com/traffic-weight": "50"}
+			ing.Status.LoadBalancer.Ingress[0].Hostname = "other-lb"
+		})
+		k8sClient := reconcileAll(t, HostConflictMerge, older.DeepCopy(), otherLB)
+
+		assert.Equal(t, map[string]string{"test-app.domain.tld": "40"}, records(t, k8sClient, "older"))
+		assert.Equal(t, map[string]string{"newer.domain.tld": "20"}, records(t, k8sClient, "newer"))
+	})
+
+	t.Run("an ingress unable to write the record does not own the host", func(t *testing.T) {
+		foreign := &externaldnsk8siov1alpha1.DNSEndpoint{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "cpr-dev", Name: "older", Labels: map[string]string{ControllerIDLabel: "someone-else"}},
+		}
+		for name, objects := range map[string][]client.Object{
+			"no load balancer": {mockIngress(func(ing *netv1.Ingress) {
+				ing.Name, ing.CreationTimestamp = "older", metav1.NewTime(created)
+				ing.Status.LoadBalancer.Ingress = nil
+			})},
+			"invalid weight": {mockIngress(func(ing *netv1.Ingress) {
+				ing.Name, ing.CreationTimestamp = "older", metav1.NewTime(created)
+				ing.Annotations = map[string]string{"dns.adevinta.com/traffic-weight": "half"}
+			})},
+			"ownership conflict": {older.DeepCopy(), foreign},
+		} {
+			k8sClient := reconcileAll(t, HostConflictOldest, append(objects, newer())...)
+
+			assert.Equal(t, map[string]string{"test-app.domain.tld": "40", "newer.domain.tld": "40"}, records(t, k8sClient, "newer"), name)
+			assert.Equal(t, ReasonWeightApplied, status(t, k8sClient, "newer").Reason, name)
+		}
+		ingressMetrics.forget("cpr-dev", "older")
+	})
+
+	t.Run("ingresses declaring the same hosts are reconciled together", func(t *testing.T) {
+		reconciler := IngressReconciler{
+			Client:           fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(older.DeepCopy(), newer(), mockIngress(func(ing *netv1.Ingress) { ing.Name = "unrelated"; ing.Spec.Rules[0].Host = "other.domain.tld" })).Build(),
+			Log:              logruslogr.NewLogr(&logrus.Logger{}),
+			AnnotationPrefix: "dns.adevinta.com",
+		}
+		assert.Equal(t,
+			[]reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "cpr-dev", Name: "newer"}}},
+			reconciler.mapHostConflicts(context.Background(), older),
+		)
+	})
+}
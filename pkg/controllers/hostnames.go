@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	netv1 "k8s.io/api/networking/v1"
+)
+
+// InvalidHostnamesError is returned when the additional-hostnames annotation can not be used
+type InvalidHostnamesError struct {
+	Annotation string
+	Reason     string
+}
+
+func (e *InvalidHostnamesError) Error() string {
+	return fmt.Sprintf("invalid annotation %s: %s", e.Annotation, e.Reason)
+}
+
+// hostRules returns the rules of the ingress, followed by rules for its TLS
+// hosts, when IncludeTLSHosts is set, and for its additional hostnames.
+// These rules get the paths of the rule they map to, so the readiness of
+// their services is the one of this rule.
+func (r *IngressReconciler) hostRules(ingress netv1.Ingress) ([]netv1.IngressRule, error) {
+	rules := []netv1.IngressRule{}
+	seen := map[string]bool{}
+	for _, rule := range ingress.Spec.Rules {
+		rules = append(rules, rule)
+		seen[normalizeDomain(rule.Host)] = true
+	}
+
+	if r.IncludeTLSHosts {
+		for _, tls := range ingress.Spec.TLS {
+			for _, host := range tls.Hosts {
+				if seen[normalizeDomain(host)] {
+					continue
+				}
+				value, ok := tlsHostRuleValue(ingress, host)
+				if !ok {
+					r.Log.V(1).Info("Skipping TLS host without rule nor default backend", "IngressName", ingress.Name, "IngressNamespace", ingress.Namespace, "host", host)
+					continue
+				}
+				rules = append(rules, netv1.IngressRule{Host: host, IngressRuleValue: value})
+				seen[normalizeDomain(host)] = true
+			}
+		}
+	}
+
+	annotation := r.annotationKey("additional-hostnames")
+	value, ok := ingress.Annotations[annotation]
+	if !ok {
+		return rules, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		alias, target, hasTarget := strings.Cut(entry, "=")
+		alias, target = strings.TrimSpace(alias), strings.TrimSpace(target)
+		if !hasTarget {
+			hosts := ruleHosts(ingress)
+			if len(hosts) != 1 {
+				return nil, &InvalidHostnamesError{Annotation: annotation, Reason: fmt.Sprintf("%s must be written as %s=HOST, the ingress has %d rule hosts", alias, alias, len(hosts))}
+			}
+			target = hosts[0]
+		}
+		if alias == "" || isWildcardHost(alias) {
+			return nil, &InvalidHostnamesError{Annotation: annotation, Reason: fmt.Sprintf("invalid hostname %q", alias)}
+		}
+		if seen[normalizeDomain(alias)] {
+			continue
+		}
+		rule, ok := ruleForHost(ingress, target)
+		if !ok {
+			return nil, &InvalidHostnamesError{Annotation: annotation, Reason: fmt.Sprintf("%s maps to %s, which is not a host of the ingress rules", alias, target)}
+		}
+		rules = append(rules, netv1.IngressRule{Host: alias, IngressRuleValue: rule.IngressRuleValue})
+		seen[normalizeDomain(alias)] = true
+	}
+	return rules, nil
+}
+
+// ruleHosts returns the distinct hosts of the ingress rules
+func ruleHosts(ingress netv1.Ingress) []string {
+	hosts := []string{}
+	seen := map[string]bool{}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host != "" && !seen[rule.Host] {
+			hosts = append(hosts, rule.Host)
+			seen[rule.Host] = true
+		}
+	}
+	return hosts
+}
+
+// ruleForHost returns the rule with the given host
+func ruleForHost(ingress netv1.Ingress, host string) (netv1.IngressRule, bool) {
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host != "" && normalizeDomain(rule.Host) == normalizeDomain(host) {
+			return rule, true
+		}
+	}
+	return netv1.IngressRule{}, false
+}
+
+// tlsHostRuleValue returns the paths serving a TLS host, as routed by the
+// ingress controllers: the wildcard rule covering the host, the rules
+// without host or the default backend
+func tlsHostRuleValue(ingress netv1.Ingress, host string) (netv1.IngressRuleValue, bool) {
+	for _, rule := range ingress.Spec.Rules {
+		if isWildcardHost(rule.Host) && coveredByWildcard(rule.Host, host) {
+			return rule.IngressRuleValue, true
+		}
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host == "" && rule.HTTP != nil {
+			return rule.IngressRuleValue, true
+		}
+	}
+	backend := ingress.Spec.DefaultBackend
+	if backend == nil || backend.Service == nil {
+		return netv1.IngressRuleValue{}, false
+	}
+	return netv1.IngressRuleValue{HTTP: &netv1.HTTPIngressRuleValue{
+		Paths: []netv1.HTTPIngressPath{{Path: "/", Backend: *backend}},
+	}}, true
+}
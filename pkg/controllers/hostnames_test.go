@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestAdditionalHostnames(t *testing.T) {
+	newReconciler := func(objects ...*v1.Endpoints) IngressReconciler {
+		builder := fake.NewClientBuilder().WithScheme(NewScheme())
+		for _, object := range objects {
+			builder = builder.WithObjects(object)
+		}
+		return IngressReconciler{
+			Client:           builder.Build(),
+			Log:              logruslogr.NewLogr(&logrus.Logger{}),
+			AnnotationPrefix: "dns.adevinta.com",
+			ClusterName:      "cluster-1",
+			BindingDomains:   []BindingDomain{{Domain: "domain.tld"}, {Domain: "vanity.tld"}},
+			WeightStore:      trafficweight.NewWeightStore(trafficweight.StoreConfig{DesiredWeight: 40, CurrentWeight: 40}),
+		}
+	}
+	hostWeights := func(t *testing.T, reconciler IngressReconciler, ingress *netv1.Ingress) map[string]uint {
+		t.Helper()
+		hosts, err := reconciler.newDnsEndpoint(context.Background(), &externaldnsk8siov1alpha1.DNSEndpoint{}, "lb.domain.tld", *ingress, metav1.OwnerReference{})
+		require.NoError(t, err)
+		weights := map[string]uint{}
+		for _, host := range hosts {
+			weights[host.host] = host.weight
+		}
+		return weights
+	}
+	annotated := func(value string) *netv1.Ingress {
+		return mockIngress(func(ing *netv1.Ingress) {
+			ing.Annotations = map[string]string{"dns.adevinta.com/additional-hostnames": value}
+		})
+	}
+
+	t.Run("aliases get the weight and readiness of their rule", func(t *testing.T) {
+		reconciler := newReconciler(mockEndpoint(epWithName("test-app")), mockEndpoint(epWithName("test-app-a")))
+		assert.Equal(t, map[string]uint{"test-app.domain.tld": 40, "www.vanity.tld": 40}, hostWeights(t, reconciler, annotated("www.vanity.tld=test-app.domain.tld")))
+		assert.Equal(t, map[string]uint{"test-app.domain.tld": 40, "www.vanity.tld": 40, "shop.vanity.tld": 40}, hostWeights(t, reconciler, annotated(" www.vanity.tld , shop.vanity.tld,")))
+
+		unready := newReconciler(mockEndpoint(epWithName("test-app"), epWithoutSubset()), mockEndpoint(epWithName("test-app-a")))
+		assert.Equal(t, map[string]uint{"test-app.domain.tld": 0, "www.vanity.tld": 0}, hostWeights(t, unready, annotated("www.vanity.tld")))
+	})
+
+	t.Run("aliases outside the binding domains or duplicated are ignored", func(t *testing.T) {
+		reconciler := newReconciler(mockEndpoint(epWithName("test-app")), mockEndpoint(epWithName("test-app-a")))
+		assert.Equal(t, map[string]uint{"test-app.domain.tld": 40}, hostWeights(t, reconciler, annotated("www.other.tld,test-app.domain.tld")))
+	})
+
+	t.Run("invalid annotations are reported", func(t *testing.T) {
+		reconciler := newReconciler()
+		for _, value := range []string{"www.vanity.tld=unknown.domain.tld", "*.vanity.tld", "=test-app.domain.tld"} {
+			_, err := reconciler.newDnsEndpoint(context.Background(), &externaldnsk8siov1alpha1.DNSEndpoint{}, "lb.domain.tld", *annotated(value), metav1.OwnerReference{})
+			var hostnamesErr *InvalidHostnamesError
+			assert.ErrorAs(t, err, &hostnamesErr, value)
+		}
+
+		twoHosts := annotated("www.vanity.tld")
+		twoHosts.Spec.Rules = append(twoHosts.Spec.Rules, netv1.IngressRule{Host: "other.domain.tld"})
+		_, err := reconciler.newDnsEndpoint(context.Background(), &externaldnsk8siov1alpha1.DNSEndpoint{}, "lb.domain.tld", *twoHosts, metav1.OwnerReference{})
+		assert.ErrorContains(t, err, "must be written as www.vanity.tld=HOST")
+	})
+
+	t.Run("invalid annotations are reported in the traffic status", func(t *testing.T) {
+		ingress := annotated("www.vanity.tld=unknown.domain.tld")
+		k8sClient := fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(ingress).Build()
+		reconciler := newReconciler()
+		reconciler.Client = k8sClient
+		key := types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		require.NoError(t, err)
+
+		require.NoError(t, k8sClient.Get(context.Background(), key, ingress))
+		status := trafficStatus{}
+		require.NoError(t, json.Unmarshal([]byte(ingress.Annotations["dns.adevinta.com/traffic-status"]), &status))
+		assert.Equal(t, ReasonInvalidHostnames, status.Reason)
+	})
+}
+
+func TestTLSHosts(t *testing.T) {
+	ingress := mockIngress(func(ing *netv1.Ingress) {
+		ing.Spec.Rules = append(ing.Spec.Rules, netv1.IngressRule{Host: "*.apps.domain.tld", IngressRuleValue: netv1.IngressRuleValue{HTTP: &netv1.HTTPIngressRuleValue{
+			Paths: []netv1.HTTPIngressPath{{Path: "/", Backend: netv1.IngressBackend{Service: &netv1.IngressServiceBackend{Name: "apps"}}}},
+		}}})
+		ing.Spec.DefaultBackend = &netv1.IngressBackend{Service: &netv1.IngressServiceBackend{Name: "default"}}
+		ing.Spec.TLS = []netv1.IngressTLS{{Hosts: []string{"test-app.domain.tld", "api.apps.domain.tld", "tls.domain.tld"}}}
+	})
+	reconciler := IngressReconciler{
+		Client: fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(
+			mockEndpoint(epWithName("test-app")),
+			mockEndpoint(epWithName("test-app-a")),
+			mockEndpoint(epWithName("apps"), epWithoutSubset()),
+			mockEndpoint(epWithName("default")),
+		).Build(),
+		Log:              logruslogr.NewLogr(&logrus.Logger{}),
+		AnnotationPrefix: "dns.adevinta.com",
+		WeightStore:      trafficweight.NewWeightStore(trafficweight.StoreConfig{DesiredWeight: 40, CurrentWeight: 40}),
+	}
+
+	hosts, err := reconciler.newDnsEndpoint(context.Background(), &externaldnsk8siov1alpha1.DNSEndpoint{}, "lb.domain.tld", *ingress, metav1.OwnerReference{})
+	require.NoError(t, err)
+	assert.Len(t, hosts, 2, "TLS hosts are ignored by default")
+
+	reconciler.IncludeTLSHosts = true
+	hosts, err = reconciler.newDnsEndpoint(context.Background(), &externaldnsk8siov1alpha1.DNSEndpoint{}, "lb.domain.tld", *ingress, metav1.OwnerReference{})
+	require.NoError(t, err)
+	assert.Equal(t, []hostWeight{
+		{host: "test-app.domain.tld", weight: 40},
+		{host: "*.apps.domain.tld", weight: 0, withoutPods: true},
+		{host: "api.apps.domain.tld", weight: 0, withoutPods: true},
+		{host: "tls.domain.tld", weight: 40},
+	}, hosts)
+
+	ingress.Spec.DefaultBackend = nil
+	hosts, err = reconciler.newDnsEndpoint(context.Background(), &externaldnsk8siov1alpha1.DNSEndpoint{}, "lb.domain.tld", *ingress, metav1.OwnerReference{})
+	require.NoError(t, err)
+	assert.Len(t, hosts, 3, "TLS hosts without backend are skipped")
+}
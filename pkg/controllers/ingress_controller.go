@@ -2,10 +2,10 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math"
 	"strconv"
-	"strings"
+	"text/template"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
@@ -15,60 +15,92 @@ import (
 	netv1 "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
 )
 
-type annotationFilter struct {
-	key   string
-	value string
+// hostWeight is the weight set in the DNS record of a host
+type hostWeight struct {
+	host   string
+	weight uint
+	// withoutPods is set when the weight is zeroed because the host services have no pods
+	withoutPods bool
+	// wildcardConflicts are the hosts of other ingresses overlapping with this host through a wildcard
+	wildcardConflicts []string
+	// conflict is set when other ingresses declare the same host
+	conflict *hostConflict
+}
+
+// shadowed tells whether the record of the host is written by another ingress
+func (h hostWeight) shadowed() bool {
+	return h.conflict != nil && h.conflict.owner != ""
 }
 
 type IngressReconciler struct {
 	client.Client
-	Log              logr.Logger
-	Scheme           *runtime.Scheme
-	ClusterName      string
-	BindingDomain    string
-	AWSRegion        string
-	AnnotationFilter annotationFilter
-	DevMode          bool
+	Log         logr.Logger
+	Scheme      *runtime.Scheme
+	ClusterName string
+	// BindingDomains are the domains DNS records are created for, every host is bound when empty
+	BindingDomains []BindingDomain
+	// ExcludedHosts are patterns of hosts never bound, see isExcluded
+	ExcludedHosts []string
+	AWSRegion     string
+	// AnnotationFilter, LabelFilter, IngressClass and NamespaceSelector select
+	// the ingresses handled by the controller, all of them when empty
+	AnnotationFilter  labels.Selector
+	LabelFilter       labels.Selector
+	IngressClass      string
+	NamespaceSelector labels.Selector
+	DevMode           bool
+	// IncludeTLSHosts creates DNS records for the hosts of the TLS section too
+	IncludeTLSHosts  bool
 	AnnotationPrefix string
-}
-
-func NewAnnotationFilter(filter string) annotationFilter {
-	filterKeyValue := strings.Split(filter, "=")
-	if len(filterKeyValue) == 2 {
-		return annotationFilter{
-			key:   filterKeyValue[0],
-			value: filterKeyValue[1],
-		}
-	}
-
-	return annotationFilter{}
+	WeightStore      *trafficweight.WeightStore
+	// Propagation, when set, is told about the ingresses reconciled with the current weight
+	Propagation *trafficweight.PropagationTracker
+	// Recorder, when set, emits events on the ingresses explaining their DNS weights
+	Recorder record.EventRecorder
+	// DryRun computes and logs the changes to the DNSEndpoints without writing them
+	DryRun bool
+	// ControllerID identifies this instance in the ownership labels of the
+	// DNSEndpoints, the annotation prefix when empty
+	ControllerID string
+	// RecordTTL is the TTL of the records in seconds, the provider default when 0.
+	// It is overridden by the binding domains, the record-ttl annotation and the weight backend.
+	RecordTTL int64
+	// SetIdentifier renders the set identifier of the records, the cluster name when nil
+	SetIdentifier *template.Template
+	// PreviousSetIdentifier, when set, keeps the records with the identifier
+	// rendered by the previous template, drained, while migrating to SetIdentifier
+	PreviousSetIdentifier *template.Template
+	// HostConflictPolicy resolves the hosts declared by several ingresses, HostConflictMerge when empty
+	HostConflictPolicy HostConflictPolicy
+	// OutOfScopeAction is applied to the DNSEndpoint of the ingresses leaving
+	// the scope of the controller, OutOfScopeDelete when empty
+	OutOfScopeAction OutOfScopeAction
+	// hostsIndexed is set once the ingresses are indexed by hostIndex, so the
+	// ingresses declaring a host are found without listing all of them
+	hostsIndexed bool
 }
 
 func (r *IngressReconciler) annotationKey(key string) string {
 	return fmt.Sprintf("%s/%s", r.AnnotationPrefix, key)
 }
 
-func (r *IngressReconciler) ingressHasAnnotationKeyValue(ingress netv1.Ingress, key, value string) bool {
-	for k, v := range ingress.Annotations {
-		if k == key && v == value {
-			return true
-		}
-	}
-	return false
-}
-
 func (r *IngressReconciler) ingressHasAnnotationKey(ingress netv1.Ingress, key string) bool {
 	for k := range ingress.Annotations {
 		if k == key {
@@ -78,17 +110,14 @@ func (r *IngressReconciler) ingressHasAnnotationKey(ingress netv1.Ingress, key s
 	return false
 }
 
-func (r *IngressReconciler) ingressAnnotationMatchFilter(ingress netv1.Ingress) bool {
-	if (annotationFilter{}) == r.AnnotationFilter {
-		return true
-	}
-	return r.ingressHasAnnotationKeyValue(ingress, r.AnnotationFilter.key, r.AnnotationFilter.value)
-}
-
 func (r *IngressReconciler) filterIngressRulesByHost(rules []netv1.IngressRule) []netv1.IngressRule {
 	rulesToBind := []netv1.IngressRule{}
 	for _, rule := range rules {
-		if strings.HasSuffix(rule.Host, r.BindingDomain) {
+		if _, err := wildcardBase(rule.Host); isWildcardHost(rule.Host) && err != nil {
+			r.Log.Info("Skipping the rule", "reason", err.Error())
+			continue
+		}
+		if _, ok := r.bindingDomain(rule.Host); ok {
 			rulesToBind = append(rulesToBind, rule)
 		}
 	}
@@ -109,95 +138,152 @@ func (r *IngressReconciler) isIngressWeighted(ingress netv1.Ingress) bool {
 	return r.ingressHasAnnotationKey(ingress, r.annotationKey("traffic-weight"))
 }
 
-func (r *IngressReconciler) calculateIngressWeight(ingress netv1.Ingress) (uint, error) {
-	backendPercentage := float64(trafficweight.Store.DesiredWeight)
-	if backendPercentage < 0 {
-		return 0, fmt.Errorf("Cannot handle negative backend weights")
-	}
-	if backendPercentage > 100 {
-		backendPercentage = float64(100.0)
-	}
-	backendPercentage = float64(backendPercentage) / float64(100)
+func (r *IngressReconciler) calculateIngressWeight(ingress netv1.Ingress, backendWeight int) (uint, error) {
 	userDesiredWeight, err := strconv.ParseFloat(ingress.Annotations[r.annotationKey("traffic-weight")], 64)
 	if err != nil {
 		return 0, fmt.Errorf("Cannot parse annotation %v with value '%v'", r.annotationKey("traffic-weight"), ingress.Annotations[r.annotationKey("traffic-weight")])
 	}
-	if userDesiredWeight < 0 {
-		return 0, fmt.Errorf("Cannot handle negative traffic weights")
-	}
-	if userDesiredWeight > 100 {
-		userDesiredWeight = float64(100.0)
-	}
-	calculatedWeight := backendPercentage * userDesiredWeight
-	desiredWeight := uint(math.Ceil(calculatedWeight))
-	return desiredWeight, err
+	return trafficweight.IngressWeight(backendWeight, userDesiredWeight)
 }
 
-func (r *IngressReconciler) newDnsEndpoint(ctx context.Context, dnsEndpoint *externaldnsk8siov1alpha1.DNSEndpoint, target string, ingress netv1.Ingress, owner metav1.OwnerReference) {
+func (r *IngressReconciler) newDnsEndpoint(ctx context.Context, dnsEndpoint *externaldnsk8siov1alpha1.DNSEndpoint, target string, ingress netv1.Ingress, owner metav1.OwnerReference) ([]hostWeight, error) {
 	var desiredWeight uint
 	var err error
-	var healthCheckProperty *externaldnsk8siov1alpha1.ProviderSpecificProperty
 	dnsEndpoint.Name = ingress.ObjectMeta.Name
 	dnsEndpoint.Namespace = ingress.ObjectMeta.Namespace
 	dnsEndpoint.SetOwnerReferences([]metav1.OwnerReference{owner})
-	desiredWeight = uint(trafficweight.Store.DesiredWeight)
-	if trafficweight.Store.AWSHealthCheckID != "" {
-		healthCheckProperty = &externaldnsk8siov1alpha1.ProviderSpecificProperty{
-			Name:  "aws/health-check-id",
-			Value: trafficweight.Store.AWSHealthCheckID,
-		}
-	}
+	r.labelOwnership(dnsEndpoint)
+	// Use a single snapshot so all the endpoints get the same weight configuration
+	store := r.WeightStore.Get()
+	desiredWeight = uint(store.DesiredWeight)
 	if r.isIngressWeighted(ingress) {
-		desiredWeight, err = r.calculateIngressWeight(ingress)
+		desiredWeight, err = r.calculateIngressWeight(ingress, store.DesiredWeight)
 		if err != nil {
 			log := r.Log.WithValues("IngressName", ingress.ObjectMeta.Name).WithValues("IngressNamespace", ingress.ObjectMeta.Namespace)
 			log.Error(err, "something went wrong calculating the weight, doing nothing")
-			return
-		}
-		if len(dnsEndpoint.ObjectMeta.Annotations) == 0 {
-			dnsEndpoint.ObjectMeta.Annotations = make(map[string]string)
+			ingressMetrics.WeightCalculationError.WithLabelValues(ingress.Namespace, ingress.Name).Inc()
+			return nil, err
 		}
 	}
+	rules, err := r.hostRules(ingress)
+	if err != nil {
+		r.Log.WithValues("IngressName", ingress.ObjectMeta.Name, "IngressNamespace", ingress.ObjectMeta.Namespace).Error(err, "invalid hostnames, doing nothing")
+		return nil, err
+	}
+	setIdentifier, err := r.setIdentifier(ingress)
+	if err != nil {
+		r.Log.WithValues("IngressName", ingress.ObjectMeta.Name, "IngressNamespace", ingress.ObjectMeta.Namespace).Error(err, "invalid set identifier, doing nothing")
+		return nil, err
+	}
+	previousSetIdentifier, migrating := r.migratedSetIdentifier(ingress, setIdentifier)
+	ingressTTL, err := r.ingressRecordTTL(ingress)
+	if err != nil {
+		r.Log.WithValues("IngressName", ingress.ObjectMeta.Name, "IngressNamespace", ingress.ObjectMeta.Namespace).Error(err, "invalid record ttl, doing nothing")
+		return nil, err
+	}
+	breakdown := newWeightBreakdown(store, ingress.Annotations[r.annotationKey("traffic-weight")])
 	dnsEndpoint.Spec = externaldnsk8siov1alpha1.DNSEndpointSpec{Endpoints: []*externaldnsk8siov1alpha1.Endpoint{}}
-	for _, rule := range r.filterIngressRulesByHost(ingress.Spec.Rules) {
+	hosts := []hostWeight{}
+	rulesToBind := r.filterIngressRulesByHost(rules)
+	conflicts := r.hostConflicts(ctx, ingress, target, rulesToBind, store)
+	var drainedElsewhere map[string]bool
+	if migrating {
+		drainedElsewhere = r.drainedElsewhere(ctx, ingress, rulesToBind, store)
+	}
+	for _, rule := range rulesToBind {
+		conflict := conflicts[normalizeDomain(rule.Host)]
+		if conflict != nil && conflict.owner != "" {
+			// The record of the host is written by the other ingress
+			hosts = append(hosts, hostWeight{host: rule.Host, conflict: conflict})
+			continue
+		}
 
-		if !r.ingressRuleHasPods(ctx, ingress.ObjectMeta.Namespace, &rule) {
-			desiredWeight = 0
+		withoutPods := !r.ingressRuleHasPods(ctx, ingress.ObjectMeta.Namespace, &rule)
+		hostIngressWeight := desiredWeight
+		if conflict != nil && conflict.merged {
+			withoutPods = withoutPods || conflict.withoutPods
+			hostIngressWeight = min(hostIngressWeight, conflict.weight)
+		}
+		domain, _ := r.bindingDomain(rule.Host)
+		healthCheckID := store.AWSHealthCheckID
+		if domain.AWSHealthCheckID != "" {
+			healthCheckID = domain.AWSHealthCheckID
 		}
+		if healthCheckID == noHealthCheck {
+			healthCheckID = ""
+		}
+		// Only this host is zeroed, other hosts of the ingress keep their weight
+		hostDesiredWeight := breakdown.addHost(rule.Host, hostIngressWeight, withoutPods, healthCheckID)
+		hosts = append(hosts, hostWeight{host: rule.Host, weight: hostDesiredWeight, withoutPods: withoutPods, conflict: conflict})
 
 		providerSpecificProperties := externaldnsk8siov1alpha1.ProviderSpecific{
 			externaldnsk8siov1alpha1.ProviderSpecificProperty{
 				Name:  "aws/weight",
-				Value: strconv.FormatUint(uint64(desiredWeight), 10),
+				Value: strconv.FormatUint(uint64(hostDesiredWeight), 10),
 			},
 		}
-		if healthCheckProperty != nil {
-			providerSpecificProperties = append(providerSpecificProperties, *healthCheckProperty)
+		if healthCheckID != "" {
+			providerSpecificProperties = append(providerSpecificProperties, externaldnsk8siov1alpha1.ProviderSpecificProperty{
+				Name:  "aws/health-check-id",
+				Value: healthCheckID,
+			})
+		}
+		recordType := RecordTypeCNAME
+		if domain.RecordType == RecordTypeA {
+			// The load balancer is a hostname, A records are only possible as Route53 aliases
+			recordType = RecordTypeA
+			providerSpecificProperties = append(providerSpecificProperties, externaldnsk8siov1alpha1.ProviderSpecificProperty{
+				Name:  "alias",
+				Value: "true",
+			})
 		}
-		dnsEndpoint.Spec.Endpoints = append(dnsEndpoint.Spec.Endpoints, &externaldnsk8siov1alpha1.Endpoint{
+		endpoint := &externaldnsk8siov1alpha1.Endpoint{
 			DNSName: rule.Host,
 			Targets: externaldnsk8siov1alpha1.Targets{
 				target,
 			},
-			RecordType:       "CNAME",
-			SetIdentifier:    r.ClusterName,
+			RecordType:       recordType,
+			RecordTTL:        externaldnsk8siov1alpha1.TTL(r.recordTTL(store, ingressTTL, domain)),
+			SetIdentifier:    setIdentifier,
 			ProviderSpecific: providerSpecificProperties,
-		},
-		)
+		}
+		dnsEndpoint.Spec.Endpoints = append(dnsEndpoint.Spec.Endpoints, endpoint)
+		if migrating && !drainedElsewhere[normalizeDomain(rule.Host)] {
+			// Changed in the same DNSEndpoint update, so the traffic moves to the new record at once
+			dnsEndpoint.Spec.Endpoints = append(dnsEndpoint.Spec.Endpoints, drainedEndpoint(endpoint, previousSetIdentifier))
+		}
+	}
+	if err := breakdown.annotate(dnsEndpoint, r.annotationKey("weight-breakdown")); err != nil {
+		r.Log.Error(err, "Unable to encode the weight breakdown")
 	}
+	return hosts, nil
 }
 
 func (r *IngressReconciler) reconcileDNSEntries(ctx context.Context, ingress netv1.Ingress, ownerRef metav1.OwnerReference) error {
 	log := r.Log.WithValues("IngressName", ingress.ObjectMeta.Name).WithValues("IngressNamespace", ingress.ObjectMeta.Namespace)
 
-	if !r.ingressAnnotationMatchFilter(ingress) {
-		log.Info("Ingress object doesn't match annotation filter. Skipping")
-		return nil
+	matches, err := r.ingressMatchesFilter(ctx, ingress)
+	if err != nil {
+		return err
+	}
+	if !matches {
+		log.Info("Ingress object doesn't match the ingress filters, releasing its dns endpoint")
+		ingressMetrics.forget(ingress.Namespace, ingress.Name)
+		return r.releaseDNSEndpoint(ctx, ingress, log)
+	}
+	if !r.bindsHosts(ingress) {
+		log.Info("Ingress object has no host in the binding domains, releasing its dns endpoint")
+		ingressMetrics.forget(ingress.Namespace, ingress.Name)
+		return r.releaseDNSEndpoint(ctx, ingress, log)
 	}
 
 	target, err := r.getTargetFromIngress(ingress)
 	if err != nil {
 		log.Info("Ingress object doesn't have target assigned. Skipping")
+		r.reportStatus(ctx, &ingress, trafficStatus{
+			Reason:  ReasonNoLoadBalancerStatus,
+			Message: "the ingress has no load balancer status, its DNS records are not updated",
+		})
 		return nil
 	}
 
@@ -209,12 +295,67 @@ func (r *IngressReconciler) reconcileDNSEntries(ctx context.Context, ingress net
 			Namespace: ingress.GetNamespace(),
 		},
 	}
-	var f controllerutil.MutateFn = func() error {
-		r.newDnsEndpoint(ctx, dnsEndpoint, target, ingress, ownerRef)
+	existing := &externaldnsk8siov1alpha1.DNSEndpoint{}
+	err = r.Get(ctx, client.ObjectKeyFromObject(dnsEndpoint), existing)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && !r.ownsDNSEndpoint(existing, &ingress) {
+		owner := describeOwner(existing)
+		log.Info("The dns endpoint is owned by someone else, refusing to adopt it", "owner", owner)
+		ingressMetrics.conflict(ingress.Namespace, ingress.Name)
+		r.reportStatus(ctx, &ingress, trafficStatus{
+			Reason:  ReasonOwnershipConflict,
+			Message: fmt.Sprintf("the DNSEndpoint %s/%s is owned by %s, its DNS records are not updated", existing.Namespace, existing.Name, owner),
+		})
+		return nil
+	}
+	var hosts []hostWeight
+	var weightErr error
+	if r.DryRun {
+		hosts, err = r.dryRunDNSEntries(ctx, ingress, target, ownerRef)
+		var calculationErr *WeightCalculationError
+		if errors.As(err, &calculationErr) {
+			weightErr, err = calculationErr.Err, nil
+		}
+	} else {
+		var f controllerutil.MutateFn = func() error {
+			hosts, weightErr = r.newDnsEndpoint(ctx, dnsEndpoint, target, ingress, ownerRef)
+			return nil
+		}
+		_, err = ctrl.CreateOrUpdate(ctx, r.Client, dnsEndpoint, f)
+	}
+	if err != nil {
+		return err
+	}
+	if weightErr != nil {
+		reason := ReasonWeightCalculationFailed
+		var hostnamesErr *InvalidHostnamesError
+		var setIdentifierErr *InvalidSetIdentifierError
+		var recordTTLErr *InvalidRecordTTLError
+		if errors.As(weightErr, &hostnamesErr) {
+			reason = ReasonInvalidHostnames
+		} else if errors.As(weightErr, &setIdentifierErr) {
+			reason = ReasonInvalidSetIdentifier
+		} else if errors.As(weightErr, &recordTTLErr) {
+			reason = ReasonInvalidRecordTTL
+		}
+		r.reportStatus(ctx, &ingress, trafficStatus{
+			Reason:  reason,
+			Message: fmt.Sprintf("DNS weights not updated: %v", weightErr),
+		})
 		return nil
 	}
-	_, err = ctrl.CreateOrUpdate(ctx, r.Client, dnsEndpoint, f)
-	return err
+	conflicts, err := r.wildcardConflicts(ctx, ingress, hosts)
+	if err != nil {
+		log.Error(err, "Unable to check the wildcard conflicts")
+	}
+	for i := range hosts {
+		hosts[i].wildcardConflicts = conflicts[hosts[i].host]
+	}
+	ingressMetrics.record(ingress.Namespace, ingress.Name, hosts)
+	r.reportStatus(ctx, &ingress, newTrafficStatus(hosts))
+	return nil
 }
 
 func (r *IngressReconciler) endpointBeingDeleted(ctx context.Context, obj types.NamespacedName) bool {
@@ -289,9 +430,7 @@ func (r *IngressReconciler) endpointsHasPods(endpoints *v1.Endpoints) bool {
 
 func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("IngressName", req.NamespacedName)
-
-	trafficStoreMetrics.DesiredWeight.Set(float64(trafficweight.Store.DesiredWeight))
-	trafficStoreMetrics.CurrentWeight.Set(float64(trafficweight.Store.CurrentWeight))
+	weight := r.WeightStore.Get().DesiredWeight
 
 	var ingress netv1.Ingress
 	controller := true
@@ -300,20 +439,16 @@ func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	if err := r.Get(ctx, req.NamespacedName, &ingress); err != nil {
 		if apierrors.IsNotFound(err) {
 			log.Info("The ingress object does not exist. Ensuring the dns endpoint does not exist either")
+			ingressMetrics.forget(req.Namespace, req.Name)
 			// the ingress was deleted, maybe the controller was offline meanwhile deleted?
 			// anyhow, we should remove the associated resources if they exist
 			// As defined in reconcileDNSEntries there is a single DNSEntry created per ingress.
 			// Shall this change, we should change the logic
-			err = r.Client.Delete(
-				ctx,
-				&externaldnsk8siov1alpha1.DNSEndpoint{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      req.Name,
-						Namespace: req.Namespace,
-					},
-				},
-			)
-			return ctrl.Result{}, client.IgnoreNotFound(err)
+			err = r.deleteOrphanDNSEndpoint(ctx, req.NamespacedName, log)
+			if err == nil {
+				r.Propagation.Done(req.NamespacedName, weight)
+			}
+			return ctrl.Result{}, err
 		}
 
 		log.Info("Unable to fetch Ingress, skipping")
@@ -345,6 +480,7 @@ func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		}
 	}
 
+	r.Propagation.Done(req.NamespacedName, weight)
 	return ctrl.Result{}, nil
 }
 
@@ -358,12 +494,23 @@ func (r *IngressReconciler) SetupWithManager(mgr ctrl.Manager, events chan event
 
 	endpointMapper := &endpointsMapper{
 		Client: r.Client,
+		Filter: r.ingressMatchesObjectFilters,
 	}
+	ingressPredicate := r.ingressPredicate()
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(ing).
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &netv1.Ingress{}, hostIndex, r.indexHosts); err != nil {
+		return err
+	}
+	r.hostsIndexed = true
+
+	managed := ctrl.NewControllerManagedBy(mgr).
+		For(ing, builder.WithPredicates(ingressPredicate)).
 		Watches(&v1.Endpoints{}, handler.EnqueueRequestsFromMapFunc(endpointMapper.mapToIngressRequests)).
+		Watches(&netv1.Ingress{}, handler.EnqueueRequestsFromMapFunc(r.mapHostConflicts)).
 		Owns(&externaldnsk8siov1alpha1.DNSEndpoint{}).
-		WatchesRawSource(source.Channel[client.Object](events, &handler.EnqueueRequestForObject{})).
-		Complete(r)
+		WatchesRawSource(source.Channel[client.Object](events, &handler.EnqueueRequestForObject{}, source.WithPredicates[client.Object, reconcile.Request](ingressPredicate)))
+	if r.NamespaceSelector != nil && !r.NamespaceSelector.Empty() {
+		managed = managed.Watches(&v1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.mapNamespaceToIngresses), builder.WithPredicates(predicate.LabelChangedPredicate{}))
+	}
+	return managed.Complete(r)
 }
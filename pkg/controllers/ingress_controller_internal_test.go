@@ -24,16 +24,20 @@ import (
 
 func TestMissingIngressDeletesDNSEndpoints(t *testing.T) {
 	extendedScheme := NewScheme()
+	controller := true
+	owner := []metav1.OwnerReference{{Kind: "Ingress", Name: "ingress-name", Controller: &controller}}
 	k8sClient := fake.NewClientBuilder().WithScheme(extendedScheme).WithObjects(
-		&endpoint.DNSEndpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "namespace1", Name: "ingress-name"}},
-		&endpoint.DNSEndpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "namespace2", Name: "ingress-name"}},
+		&endpoint.DNSEndpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "namespace1", Name: "ingress-name", OwnerReferences: owner}},
+		&endpoint.DNSEndpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "namespace2", Name: "ingress-name", OwnerReferences: owner}},
+		&endpoint.DNSEndpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "namespace3", Name: "ingress-name"}},
 	).Build()
 	ep := &endpoint.DNSEndpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "namespace1", Name: "ingress-name"}}
 	key := client.ObjectKeyFromObject(ep)
 
 	reconciler := IngressReconciler{
-		Client: k8sClient,
-		Log:    logruslogr.NewLogr(&logrus.Logger{}),
+		Client:      k8sClient,
+		Log:         logruslogr.NewLogr(&logrus.Logger{}),
+		WeightStore: trafficweight.NewWeightStore(trafficweight.StoreConfig{}),
 	}
 
 	reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "namespace1", Name: "ingress-name"}})
@@ -43,11 +47,15 @@ func TestMissingIngressDeletesDNSEndpoints(t *testing.T) {
 	ep = &endpoint.DNSEndpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "namespace2", Name: "ingress-name"}}
 	key = client.ObjectKeyFromObject(ep)
 	assert.NoError(t, k8sClient.Get(context.Background(), key, ep))
+
+	// DNSEndpoints not created by the controller are left untouched
+	reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "namespace3", Name: "ingress-name"}})
+	assert.NoError(t, k8sClient.Get(context.Background(), types.NamespacedName{Namespace: "namespace3", Name: "ingress-name"}, ep))
 }
 
 func TestFilterIngressRulesByHost(t *testing.T) {
 	reconciler := IngressReconciler{
-		BindingDomain: "foo.io",
+		BindingDomains: []BindingDomain{{Domain: "foo.io"}},
 	}
 	filtered := reconciler.filterIngressRulesByHost([]netv1.IngressRule{
 		{
@@ -169,11 +177,12 @@ func TestReconcileIngressShouldCreateDNSEndpointsWithCorrectWeight(t *testing.T)
 	reconciler := IngressReconciler{
 		Client: k8sClient,
 		Log:    logruslogr.NewLogr(&logrus.Logger{}),
+		WeightStore: trafficweight.NewWeightStore(trafficweight.StoreConfig{
+			DesiredWeight: 100,
+			CurrentWeight: 100,
+		}),
 	}
 
-	trafficweight.Store.DesiredWeight = 100
-	trafficweight.Store.CurrentWeight = 100
-
 	reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}})
 
 	ep := &endpoint.DNSEndpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "cpr-dev", Name: "test-app"}}
@@ -195,11 +204,12 @@ func TestIngressWithMissingPodsHaveZeroWeight(t *testing.T) {
 	reconciler := IngressReconciler{
 		Client: k8sClient,
 		Log:    logruslogr.NewLogr(&logrus.Logger{}),
+		WeightStore: trafficweight.NewWeightStore(trafficweight.StoreConfig{
+			DesiredWeight: 100,
+			CurrentWeight: 100,
+		}),
 	}
 
-	trafficweight.Store.DesiredWeight = 100
-	trafficweight.Store.CurrentWeight = 100
-
 	reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}})
 
 	ep := &endpoint.DNSEndpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "cpr-dev", Name: "test-app"}}
@@ -221,11 +231,12 @@ func TestIngressWithPartialMissingPodsHaveZeroWeight(t *testing.T) {
 	reconciler := IngressReconciler{
 		Client: k8sClient,
 		Log:    logruslogr.NewLogr(&logrus.Logger{}),
+		WeightStore: trafficweight.NewWeightStore(trafficweight.StoreConfig{
+			DesiredWeight: 100,
+			CurrentWeight: 100,
+		}),
 	}
 
-	trafficweight.Store.DesiredWeight = 100
-	trafficweight.Store.CurrentWeight = 100
-
 	reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}})
 
 	ep := &endpoint.DNSEndpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "cpr-dev", Name: "test-app"}}
@@ -247,11 +258,12 @@ func TestIngressWithMissingPodsFaultyEndpointsHaveZeroWeight(t *testing.T) {
 	reconciler := IngressReconciler{
 		Client: k8sClient,
 		Log:    logruslogr.NewLogr(&logrus.Logger{}),
+		WeightStore: trafficweight.NewWeightStore(trafficweight.StoreConfig{
+			DesiredWeight: 100,
+			CurrentWeight: 100,
+		}),
 	}
 
-	trafficweight.Store.DesiredWeight = 100
-	trafficweight.Store.CurrentWeight = 100
-
 	reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}})
 
 	ep := &endpoint.DNSEndpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "cpr-dev", Name: "test-app"}}
@@ -261,6 +273,59 @@ func TestIngressWithMissingPodsFaultyEndpointsHaveZeroWeight(t *testing.T) {
 	assert.Equal(t, "0", ep.Spec.Endpoints[0].ProviderSpecific[0].Value)
 }
 
+func TestDNSEndpointWeightBreakdown(t *testing.T) {
+	ingress := mockIngress(
+		func(ing *netv1.Ingress) {
+			ing.Annotations = map[string]string{"dns.adevinta.com/traffic-weight": "50"}
+		},
+		ingressWithRules(
+			newRule(
+				ruleWithHost("without-pods.domain.tld"),
+				ruleWithHTTPPaths(newHTTPIngressPath(pathWithBackendServiceName("without-pods"))),
+			),
+			newRule(
+				ruleWithHost("test-app.domain.tld"),
+				ruleWithHTTPPaths(newHTTPIngressPath(pathWithBackendServiceName("test-app"))),
+			),
+		),
+	)
+	k8sClient := fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(
+		ingress,
+		mockEndpoint(epWithName("test-app")),
+		mockEndpoint(epWithName("without-pods"), epWithoutSubset()),
+	).Build()
+
+	reconciler := IngressReconciler{
+		Client:           k8sClient,
+		Log:              logruslogr.NewLogr(&logrus.Logger{}),
+		AnnotationPrefix: "dns.adevinta.com",
+		WeightStore: trafficweight.NewWeightStore(trafficweight.StoreConfig{
+			DesiredWeight:    80,
+			CurrentWeight:    80,
+			AWSHealthCheckID: "health-check",
+			Version:          4,
+		}),
+	}
+
+	_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}})
+	assert.NoError(t, err)
+
+	ep := &endpoint.DNSEndpoint{}
+	assert.NoError(t, k8sClient.Get(context.Background(), types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}, ep))
+	assert.JSONEq(t, `{
+		"clusterWeight": 80,
+		"annotationWeight": "50",
+		"backendVersion": 4,
+		"hosts": {
+			"without-pods.domain.tld": {"readinessFactor": 0, "weight": 0, "healthCheckID": "health-check"},
+			"test-app.domain.tld": {"readinessFactor": 1, "weight": 40, "healthCheckID": "health-check"}
+		}
+	}`, ep.Annotations["dns.adevinta.com/weight-breakdown"])
+	// A host without pods does not zero the following hosts
+	assert.Equal(t, "0", ep.Spec.Endpoints[0].ProviderSpecific[0].Value)
+	assert.Equal(t, "40", ep.Spec.Endpoints[1].ProviderSpecific[0].Value)
+}
+
 func mockIngress(mutators ...func(*netv1.Ingress)) *netv1.Ingress {
 	ing := netv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
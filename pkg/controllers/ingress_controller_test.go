@@ -41,9 +41,10 @@ func TestIngressController(t *testing.T) {
 		Client:           k8sClient,
 		AWSRegion:        "eu-west-7",
 		ClusterName:      "foolanito",
-		BindingDomain:    "foo.io",
+		BindingDomains:   []BindingDomain{{Domain: "foo.io"}},
 		Log:              logruslogr.NewLogr(&logrus.Logger{}),
 		AnnotationPrefix: "dns.adevinta.com",
+		WeightStore:      trafficweight.NewWeightStore(trafficweight.StoreConfig{}),
 	}
 
 	controller := true
@@ -91,6 +92,9 @@ func TestIngressController(t *testing.T) {
 			OwnerReferences: []metav1.OwnerReference{
 				ownerRef,
 			},
+			Annotations: map[string]string{
+				"dns.adevinta.com/weight-breakdown": `{"clusterWeight":0,"backendVersion":0,"hosts":{"three.foo.io":{"readinessFactor":0,"weight":0},"two.foo.io":{"readinessFactor":0,"weight":0},"zero.foo.io":{"readinessFactor":0,"weight":0}}}`,
+			},
 		},
 		Spec: externaldnsk8siov1alpha1.DNSEndpointSpec{
 			Endpoints: []*externaldnsk8siov1alpha1.Endpoint{
@@ -163,7 +167,8 @@ func TestIngressController(t *testing.T) {
 
 	t.Run("Should create a DNSentry that match the annotation filter", func(t *testing.T) {
 		reconciler.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(&ing).Build()
-		reconciler.AnnotationFilter = NewAnnotationFilter("foo=bar")
+		reconciler.AnnotationFilter, err = NewAnnotationFilter("foo=bar")
+		require.NoError(t, err)
 
 		err := reconciler.reconcileDNSEntries(context.Background(), ing, ownerRef)
 		assert.NoError(t, err)
@@ -178,7 +183,8 @@ func TestIngressController(t *testing.T) {
 
 	t.Run("Should not create a DNSentry that does not match the annotation filter", func(t *testing.T) {
 		reconciler.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(&ing).Build()
-		reconciler.AnnotationFilter = NewAnnotationFilter("foo=notbar")
+		reconciler.AnnotationFilter, err = NewAnnotationFilter("foo=notbar")
+		require.NoError(t, err)
 
 		err := reconciler.reconcileDNSEntries(context.Background(), ing, ownerRef)
 
@@ -189,14 +195,18 @@ func TestIngressController(t *testing.T) {
 	})
 
 	t.Run("if we dont set --aws-health-check-id ingress shouldnt have health property", func(t *testing.T) {
-		trafficweight.Store.AWSHealthCheckID = ""
+		reconciler.WeightStore.Update(func(store *trafficweight.StoreConfig) {
+			store.AWSHealthCheckID = ""
+		})
 		forged := &externaldnsk8siov1alpha1.DNSEndpoint{}
 		reconciler.newDnsEndpoint(context.Background(), forged, "bar-celona", ing, ownerRef)
 		assert.Equal(t, expected, *forged)
 	})
 
 	t.Run("if we set --aws-health-check-id, ingress should have health property", func(t *testing.T) {
-		trafficweight.Store.AWSHealthCheckID = "one-healthcheck-id"
+		reconciler.WeightStore.Update(func(store *trafficweight.StoreConfig) {
+			store.AWSHealthCheckID = "one-healthcheck-id"
+		})
 		oldRules := ing.Spec.Rules
 		ing.Spec.Rules = []netv1.IngressRule{
 			{Host: "healthyDomain.foo.io"},
@@ -208,6 +218,9 @@ func TestIngressController(t *testing.T) {
 				OwnerReferences: []metav1.OwnerReference{
 					ownerRef,
 				},
+				Annotations: map[string]string{
+					"dns.adevinta.com/weight-breakdown": `{"clusterWeight":0,"backendVersion":0,"hosts":{"healthyDomain.foo.io":{"readinessFactor":0,"weight":0,"healthCheckID":"one-healthcheck-id"}}}`,
+				},
 			},
 			Spec: externaldnsk8siov1alpha1.DNSEndpointSpec{
 				Endpoints: []*externaldnsk8siov1alpha1.Endpoint{
@@ -250,7 +263,6 @@ func TestIngressController(t *testing.T) {
 		}
 
 		for _, testValues := range weightTests {
-			trafficweight.Store.DesiredWeight = testValues.backendPercentage
 			ingressObject := netv1.Ingress{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:        "ingress-calculated-weight-tests",
@@ -259,7 +271,7 @@ func TestIngressController(t *testing.T) {
 				},
 			}
 
-			calculated, err := reconciler.calculateIngressWeight(ingressObject)
+			calculated, err := reconciler.calculateIngressWeight(ingressObject, testValues.backendPercentage)
 			assert.Equal(t, testValues.expectedWeight, calculated)
 			assert.NoError(t, err)
 		}
@@ -275,7 +287,6 @@ func TestIngressController(t *testing.T) {
 			{-50, -50},
 		}
 		for _, testValues := range weightTests {
-			trafficweight.Store.DesiredWeight = testValues.backendPercentage
 			ingressObject := netv1.Ingress{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:        "ingress-calculated-weight-tests",
@@ -284,7 +295,7 @@ func TestIngressController(t *testing.T) {
 				},
 			}
 
-			_, err := reconciler.calculateIngressWeight(ingressObject)
+			_, err := reconciler.calculateIngressWeight(ingressObject, testValues.backendPercentage)
 			assert.Error(t, err)
 		}
 	})
@@ -298,7 +309,7 @@ func TestIngressController(t *testing.T) {
 			},
 		}
 
-		_, err := reconciler.calculateIngressWeight(ingressObject)
+		_, err := reconciler.calculateIngressWeight(ingressObject, 100)
 		assert.Error(t, err)
 	})
 
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reasons used in the ingress events and traffic status annotation
+const (
+	ReasonWeightApplied           = "WeightApplied"
+	ReasonHostWithoutPods         = "HostWithoutPods"
+	ReasonWeightCalculationFailed = "WeightCalculationFailed"
+	ReasonNoLoadBalancerStatus    = "NoLoadBalancerStatus"
+	ReasonWildcardConflict        = "WildcardConflict"
+	ReasonInvalidHostnames        = "InvalidHostnames"
+	ReasonOwnershipConflict       = "OwnershipConflict"
+	ReasonHostConflict            = "HostConflict"
+	ReasonInvalidSetIdentifier    = "InvalidSetIdentifier"
+	ReasonInvalidRecordTTL        = "InvalidRecordTTL"
+)
+
+// trafficStatus summarizes in an ingress annotation what the controller did with it
+type trafficStatus struct {
+	// Hosts holds the effective weight of every host
+	Hosts          map[string]uint `json:"hosts,omitempty"`
+	Reason         string          `json:"reason"`
+	Message        string          `json:"message,omitempty"`
+	LastUpdateTime string          `json:"lastUpdateTime,omitempty"`
+}
+
+func newTrafficStatus(hosts []hostWeight) trafficStatus {
+	status := trafficStatus{Hosts: map[string]uint{}, Reason: ReasonWeightApplied}
+	zeroed := []string{}
+	for _, host := range hosts {
+		if host.shadowed() {
+			continue
+		}
+		status.Hosts[host.host] = host.weight
+		if host.withoutPods {
+			zeroed = append(zeroed, host.host)
+		}
+	}
+	if len(zeroed) > 0 {
+		status.Reason = ReasonHostWithoutPods
+		status.Message = fmt.Sprintf("weight set to 0 for hosts without ready pods: %v", zeroed)
+	}
+	declared := []string{}
+	for _, host := range hosts {
+		if host.conflict == nil {
+			continue
+		}
+		others := strings.Join(host.conflict.ingresses, ", ")
+		switch {
+		case host.shadowed():
+			declared = append(declared, fmt.Sprintf("%s is also declared by %s, its record is written by %s", host.host, others, host.conflict.owner))
+		case host.conflict.merged:
+			declared = append(declared, fmt.Sprintf("%s is also declared by %s, merged in the record written by this ingress", host.host, others))
+		default:
+			declared = append(declared, fmt.Sprintf("%s is also declared by %s, its record is written by this ingress", host.host, others))
+		}
+	}
+	if len(declared) > 0 {
+		if status.Reason == ReasonWeightApplied {
+			status.Reason = ReasonHostConflict
+		} else {
+			status.Message += "; "
+		}
+		status.Message += fmt.Sprintf("hosts declared by several ingresses: %s", strings.Join(declared, "; "))
+	}
+	conflicts := []string{}
+	for _, host := range hosts {
+		if len(host.wildcardConflicts) > 0 {
+			conflicts = append(conflicts, fmt.Sprintf("%s overlaps with %s", host.host, strings.Join(host.wildcardConflicts, ", ")))
+		}
+	}
+	if len(conflicts) > 0 {
+		if status.Reason == ReasonWeightApplied {
+			status.Reason = ReasonWildcardConflict
+		} else {
+			status.Message += "; "
+		}
+		status.Message += fmt.Sprintf("the most specific records take precedence over the wildcards: %s", strings.Join(conflicts, "; "))
+	}
+	return status
+}
+
+func sameTrafficStatus(a, b trafficStatus) bool {
+	if a.Reason != b.Reason || a.Message != b.Message {
+		return false
+	}
+	// Empty hosts are not encoded in the annotation
+	if len(a.Hosts) == 0 && len(b.Hosts) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a.Hosts, b.Hosts)
+}
+
+func (r *IngressReconciler) event(ingress *netv1.Ingress, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(ingress, eventType, reason, message)
+}
+
+// reportStatus writes the traffic status annotation of the ingress and emits
+// the matching event when the status changed.
+// Statuses without hosts keep the hosts previously reported.
+// Nothing is reported in dry-run mode.
+func (r *IngressReconciler) reportStatus(ctx context.Context, ingress *netv1.Ingress, status trafficStatus) {
+	if r.DryRun {
+		// Nothing is written in dry-run mode, the changes are logged instead
+		return
+	}
+	key := r.annotationKey("traffic-status")
+	previous := trafficStatus{}
+	if value, ok := ingress.Annotations[key]; ok {
+		// An invalid annotation is overwritten
+		_ = json.Unmarshal([]byte(value), &previous)
+	}
+	if status.Hosts == nil {
+		status.Hosts = previous.Hosts
+	}
+	if sameTrafficStatus(previous, status) {
+		return
+	}
+	status.LastUpdateTime = time.Now().UTC().Format(time.RFC3339)
+
+	eventType := v1.EventTypeWarning
+	if status.Reason == ReasonWeightApplied {
+		eventType = v1.EventTypeNormal
+	}
+	message := status.Message
+	if message == "" {
+		message = fmt.Sprintf("DNS weights set to %v", status.Hosts)
+	}
+	r.event(ingress, eventType, status.Reason, message)
+
+	value, err := json.Marshal(status)
+	if err != nil {
+		r.Log.Error(err, "Unable to encode the traffic status")
+		return
+	}
+	patch := client.MergeFrom(ingress.DeepCopy())
+	if ingress.Annotations == nil {
+		ingress.Annotations = map[string]string{}
+	}
+	ingress.Annotations[key] = string(value)
+	if err := r.Patch(ctx, ingress, patch); err != nil {
+		r.Log.WithValues("IngressName", ingress.Name, "IngressNamespace", ingress.Namespace).Error(err, "Unable to update the traffic status annotation")
+	}
+}
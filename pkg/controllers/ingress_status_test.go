@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func ingressTrafficStatus(t *testing.T, k8sClient client.Client) trafficStatus {
+	t.Helper()
+	ingress := netv1.Ingress{}
+	require.NoError(t, k8sClient.Get(context.Background(), types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}, &ingress))
+	status := trafficStatus{}
+	require.NoError(t, json.Unmarshal([]byte(ingress.Annotations["dns.adevinta.com/traffic-status"]), &status))
+	return status
+}
+
+func TestIngressTrafficStatus(t *testing.T) {
+	reconcile := func(t *testing.T, recorder *record.FakeRecorder, objects ...client.Object) client.Client {
+		t.Helper()
+		k8sClient := fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(objects...).Build()
+		reconciler := IngressReconciler{
+			Client:           k8sClient,
+			Log:              logruslogr.NewLogr(&logrus.Logger{}),
+			AnnotationPrefix: "dns.adevinta.com",
+			Recorder:         recorder,
+			WeightStore: trafficweight.NewWeightStore(trafficweight.StoreConfig{
+				DesiredWeight: 50,
+				CurrentWeight: 50,
+			}),
+		}
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}})
+		require.NoError(t, err)
+		return k8sClient
+	}
+
+	t.Run("applied weights are reported", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(10)
+		k8sClient := reconcile(t, recorder, mockIngress(), mockEndpoint(epWithName("test-app")), mockEndpoint(epWithName("test-app-a")))
+
+		status := ingressTrafficStatus(t, k8sClient)
+		assert.Equal(t, ReasonWeightApplied, status.Reason)
+		assert.Equal(t, map[string]uint{"test-app.domain.tld": 50}, status.Hosts)
+		assert.NotEmpty(t, status.LastUpdateTime)
+		require.Len(t, recorder.Events, 1)
+		assert.Equal(t, "Normal WeightApplied DNS weights set to map[test-app.domain.tld:50]", <-recorder.Events)
+	})
+
+	t.Run("hosts without pods are reported", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(10)
+		k8sClient := reconcile(t, recorder, mockIngress(), mockEndpoint(epWithName("test-app"), epWithoutSubset()), mockEndpoint(epWithName("test-app-a")))
+
+		status := ingressTrafficStatus(t, k8sClient)
+		assert.Equal(t, ReasonHostWithoutPods, status.Reason)
+		assert.Equal(t, map[string]uint{"test-app.domain.tld": 0}, status.Hosts)
+		require.Len(t, recorder.Events, 1)
+		assert.Equal(t, "Warning HostWithoutPods weight set to 0 for hosts without ready pods: [test-app.domain.tld]", <-recorder.Events)
+	})
+
+	t.Run("weight calculation failures keep the previous hosts", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(10)
+		ingress := mockIngress(func(ing *netv1.Ingress) {
+			ing.Annotations = map[string]string{
+				"dns.adevinta.com/traffic-weight": "half",
+				"dns.adevinta.com/traffic-status": `{"hosts":{"test-app.domain.tld":10},"reason":"WeightApplied"}`,
+			}
+		})
+		k8sClient := reconcile(t, recorder, ingress)
+
+		status := ingressTrafficStatus(t, k8sClient)
+		assert.Equal(t, ReasonWeightCalculationFailed, status.Reason)
+		assert.Contains(t, status.Message, "Cannot parse annotation")
+		assert.Equal(t, map[string]uint{"test-app.domain.tld": 10}, status.Hosts)
+		require.Len(t, recorder.Events, 1)
+		assert.Contains(t, <-recorder.Events, "Warning WeightCalculationFailed")
+	})
+
+	t.Run("ingresses without load balancer are reported", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(10)
+		ingress := mockIngress(func(ing *netv1.Ingress) {
+			ing.Status = netv1.IngressStatus{}
+		})
+		k8sClient := reconcile(t, recorder, ingress)
+
+		status := ingressTrafficStatus(t, k8sClient)
+		assert.Equal(t, ReasonNoLoadBalancerStatus, status.Reason)
+		require.Len(t, recorder.Events, 1)
+		assert.Contains(t, <-recorder.Events, "Warning NoLoadBalancerStatus")
+	})
+
+	t.Run("unchanged statuses are not written again", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(10)
+		k8sClient := reconcile(t, recorder, mockIngress(), mockEndpoint(epWithName("test-app")), mockEndpoint(epWithName("test-app-a")))
+		first := ingressTrafficStatus(t, k8sClient)
+		<-recorder.Events
+
+		reconciler := IngressReconciler{
+			Client:           k8sClient,
+			Log:              logruslogr.NewLogr(&logrus.Logger{}),
+			AnnotationPrefix: "dns.adevinta.com",
+			Recorder:         recorder,
+			WeightStore:      trafficweight.NewWeightStore(trafficweight.StoreConfig{DesiredWeight: 50, CurrentWeight: 50}),
+		}
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}})
+		require.NoError(t, err)
+		assert.Equal(t, first, ingressTrafficStatus(t, k8sClient))
+		assert.Len(t, recorder.Events, 0)
+	})
+}
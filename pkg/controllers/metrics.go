@@ -5,32 +5,129 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
-type TrafficStoreMetrics struct {
-	DesiredWeight prometheus.Gauge
-	CurrentWeight prometheus.Gauge
+type IngressMetrics struct {
+	HostWeight             *prometheus.GaugeVec
+	HostWithoutPods        *prometheus.GaugeVec
+	WeightCalculationError *prometheus.CounterVec
+	PendingChanges         *prometheus.GaugeVec
+	WildcardConflicts      *prometheus.GaugeVec
+	ReleasedDNSEndpoints   *prometheus.CounterVec
+	OrphanSweepErrors      *prometheus.CounterVec
+	OwnershipConflicts     *prometheus.GaugeVec
+	HostConflicts          *prometheus.GaugeVec
 }
 
 var (
-	trafficStoreMetrics = TrafficStoreMetrics{
-		DesiredWeight: prometheus.NewGauge(prometheus.GaugeOpts{
-			// cluster_traffic_controller_ingress_weight_desired
-			Namespace: "cluster",
-			Subsystem: "traffic_controller",
-			Name:      "ingress_weight_desired",
-			Help:      "The desired weight of the ingress",
-		}),
-		CurrentWeight: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				// cluster_traffic_controller_ingress_weight_current
-				Namespace: "cluster",
-				Subsystem: "traffic_controller",
-				Name:      "ingress_weight_current",
-				Help:      "The current weight of the cluster",
-			},
-		),
+	ingressMetrics = IngressMetrics{
+		HostWeight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			// cluster_traffic_controller_host_weight
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "host_weight",
+			Help:      "The weight set in the DNS record of the host",
+		}, []string{"namespace", "ingress", "host"}),
+		HostWithoutPods: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			// cluster_traffic_controller_host_without_pods
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "host_without_pods",
+			Help:      "Whether the weight of the host is set to 0 because its services have no ready pods",
+		}, []string{"namespace", "ingress", "host"}),
+		WeightCalculationError: prometheus.NewCounterVec(prometheus.CounterOpts{
+			// cluster_traffic_controller_weight_calculation_errors_total
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "weight_calculation_errors_total",
+			Help:      "The number of errors calculating the weight of an ingress, like unparsable annotations",
+		}, []string{"namespace", "ingress"}),
+		PendingChanges: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			// cluster_traffic_controller_dry_run_pending_changes
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "dry_run_pending_changes",
+			Help:      "The number of DNS records of the ingress that would be changed, in dry-run mode",
+		}, []string{"namespace", "ingress"}),
+		WildcardConflicts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			// cluster_traffic_controller_host_wildcard_conflicts
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "host_wildcard_conflicts",
+			Help:      "The number of hosts of other ingresses overlapping with the host through a wildcard",
+		}, []string{"namespace", "ingress", "host"}),
+		ReleasedDNSEndpoints: prometheus.NewCounterVec(prometheus.CounterOpts{
+			// cluster_traffic_controller_released_dns_endpoints_total
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "released_dns_endpoints_total",
+			Help:      "The number of DNSEndpoints deleted or zeroed because their ingress is out of scope or no longer exists",
+		}, []string{"reason", "action"}),
+		OrphanSweepErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			// cluster_traffic_controller_orphan_sweep_errors_total
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "orphan_sweep_errors_total",
+			Help:      "The number of errors checking or releasing a DNSEndpoint while sweeping the orphans",
+		}, []string{"namespace", "dns_endpoint"}),
+		OwnershipConflicts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			// cluster_traffic_controller_dns_endpoint_ownership_conflicts
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "dns_endpoint_ownership_conflicts",
+			Help:      "Whether the DNSEndpoint of the ingress is owned by someone else, so its DNS records are not updated",
+		}, []string{"namespace", "ingress"}),
+		HostConflicts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			// cluster_traffic_controller_host_conflicts
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "host_conflicts",
+			Help:      "The number of other ingresses declaring the host",
+		}, []string{"namespace", "ingress", "host"}),
 	}
 )
 
+// record replaces the host series of the ingress with the given hosts
+func (m IngressMetrics) record(namespace, ingress string, hosts []hostWeight) {
+	m.forgetHosts(namespace, ingress)
+	m.OwnershipConflicts.DeleteLabelValues(namespace, ingress)
+	for _, host := range hosts {
+		if host.conflict != nil {
+			m.HostConflicts.WithLabelValues(namespace, ingress, host.host).Set(float64(len(host.conflict.ingresses)))
+		}
+		if host.shadowed() {
+			continue
+		}
+		m.HostWeight.WithLabelValues(namespace, ingress, host.host).Set(float64(host.weight))
+		withoutPods := 0.0
+		if host.withoutPods {
+			withoutPods = 1
+		}
+		m.HostWithoutPods.WithLabelValues(namespace, ingress, host.host).Set(withoutPods)
+		m.WildcardConflicts.WithLabelValues(namespace, ingress, host.host).Set(float64(len(host.wildcardConflicts)))
+	}
+}
+
+// forget removes the series of an ingress no longer handled
+func (m IngressMetrics) forget(namespace, ingress string) {
+	m.forgetHosts(namespace, ingress)
+	m.PendingChanges.DeleteLabelValues(namespace, ingress)
+	m.OwnershipConflicts.DeleteLabelValues(namespace, ingress)
+	m.WeightCalculationError.DeleteLabelValues(namespace, ingress)
+}
+
+// conflict replaces the host series of the ingress, whose DNSEndpoint is owned by someone else
+func (m IngressMetrics) conflict(namespace, ingress string) {
+	m.forgetHosts(namespace, ingress)
+	m.OwnershipConflicts.WithLabelValues(namespace, ingress).Set(1)
+}
+
+func (m IngressMetrics) forgetHosts(namespace, ingress string) {
+	labels := prometheus.Labels{"namespace": namespace, "ingress": ingress}
+	m.HostWeight.DeletePartialMatch(labels)
+	m.HostWithoutPods.DeletePartialMatch(labels)
+	m.WildcardConflicts.DeletePartialMatch(labels)
+	m.HostConflicts.DeletePartialMatch(labels)
+}
+
 func init() {
-	metrics.Registry.MustRegister(trafficStoreMetrics.DesiredWeight, trafficStoreMetrics.CurrentWeight)
+	metrics.Registry.MustRegister(ingressMetrics.HostWeight, ingressMetrics.HostWithoutPods, ingressMetrics.WeightCalculationError, ingressMetrics.PendingChanges, ingressMetrics.WildcardConflicts, ingressMetrics.ReleasedDNSEndpoints, ingressMetrics.OrphanSweepErrors, ingressMetrics.OwnershipConflicts, ingressMetrics.HostConflicts)
 }
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func metricValue(t *testing.T, collector prometheus.Collector) float64 {
+	t.Helper()
+	metric, ok := collector.(prometheus.Metric)
+	require.True(t, ok)
+	m := &dto.Metric{}
+	require.NoError(t, metric.Write(m))
+	if m.Counter != nil {
+		return m.Counter.GetValue()
+	}
+	return m.Gauge.GetValue()
+}
+
+func seriesCount(t *testing.T, collector prometheus.Collector) int {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 100)
+	collector.Collect(ch)
+	close(ch)
+	return len(ch)
+}
+
+func TestIngressMetrics(t *testing.T) {
+	newReconciler := func(objects ...client.Object) IngressReconciler {
+		return IngressReconciler{
+			Client:           fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(objects...).Build(),
+			Log:              logruslogr.NewLogr(&logrus.Logger{}),
+			AnnotationPrefix: "dns.adevinta.com",
+			WeightStore: trafficweight.NewWeightStore(trafficweight.StoreConfig{
+				DesiredWeight: 100,
+				CurrentWeight: 100,
+			}),
+		}
+	}
+
+	t.Run("hosts weights are exposed", func(t *testing.T) {
+		ingress := mockIngress(
+			withObjectNamespace[*netv1.Ingress]("metrics-weights"),
+			func(ing *netv1.Ingress) {
+				ing.Annotations = map[string]string{"dns.adevinta.com/traffic-weight": "50"}
+			},
+		)
+		reconciler := newReconciler(
+			ingress,
+			mockEndpoint(epWithName("test-app"), withObjectNamespace[*v1.Endpoints]("metrics-weights")),
+			mockEndpoint(epWithName("test-app-a"), withObjectNamespace[*v1.Endpoints]("metrics-weights")),
+		)
+
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "metrics-weights", Name: "test-app"}})
+		require.NoError(t, err)
+		assert.Equal(t, 50.0, metricValue(t, ingressMetrics.HostWeight.WithLabelValues("metrics-weights", "test-app", "test-app.domain.tld")))
+		assert.Equal(t, 0.0, metricValue(t, ingressMetrics.HostWithoutPods.WithLabelValues("metrics-weights", "test-app", "test-app.domain.tld")))
+	})
+
+	t.Run("hosts without pods are exposed", func(t *testing.T) {
+		ingress := mockIngress(withObjectNamespace[*netv1.Ingress]("metrics-without-pods"))
+		reconciler := newReconciler(
+			ingress,
+			mockEndpoint(epWithName("test-app"), withObjectNamespace[*v1.Endpoints]("metrics-without-pods"), epWithoutSubset()),
+			mockEndpoint(epWithName("test-app-a"), withObjectNamespace[*v1.Endpoints]("metrics-without-pods")),
+		)
+
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "metrics-without-pods", Name: "test-app"}})
+		require.NoError(t, err)
+		assert.Equal(t, 0.0, metricValue(t, ingressMetrics.HostWeight.WithLabelValues("metrics-without-pods", "test-app", "test-app.domain.tld")))
+		assert.Equal(t, 1.0, metricValue(t, ingressMetrics.HostWithoutPods.WithLabelValues("metrics-without-pods", "test-app", "test-app.domain.tld")))
+	})
+
+	t.Run("weight calculation errors are counted", func(t *testing.T) {
+		ingress := mockIngress(
+			withObjectNamespace[*netv1.Ingress]("metrics-errors"),
+			func(ing *netv1.Ingress) {
+				ing.Annotations = map[string]string{"dns.adevinta.com/traffic-weight": "half"}
+			},
+		)
+		reconciler := newReconciler(ingress)
+
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "metrics-errors", Name: "test-app"}})
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, metricValue(t, ingressMetrics.WeightCalculationError.WithLabelValues("metrics-errors", "test-app")))
+
+		before := seriesCount(t, ingressMetrics.WeightCalculationError)
+		require.NoError(t, reconciler.Delete(context.Background(), ingress))
+		_, err = reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "metrics-errors", Name: "test-app"}})
+		require.NoError(t, err)
+		assert.Equal(t, before-1, seriesCount(t, ingressMetrics.WeightCalculationError), "the errors of deleted ingresses are no longer exposed")
+	})
+
+	t.Run("reconciled ingresses are reported to the propagation tracker", func(t *testing.T) {
+		ingress := mockIngress(withObjectNamespace[*netv1.Ingress]("metrics-propagation"))
+		reconciler := newReconciler(ingress)
+		reconciler.Propagation = trafficweight.NewPropagationTracker()
+		reconciled := types.NamespacedName{Namespace: "metrics-propagation", Name: "test-app"}
+		deleted := types.NamespacedName{Namespace: "metrics-propagation", Name: "deleted-app"}
+		reconciler.Propagation.Start(100, []types.NamespacedName{reconciled, deleted})
+
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: reconciled})
+		require.NoError(t, err)
+		assert.Equal(t, 1, reconciler.Propagation.Pending())
+		_, err = reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: deleted})
+		require.NoError(t, err)
+		assert.Equal(t, 0, reconciler.Propagation.Pending())
+	})
+
+	t.Run("deleted ingresses are no longer exposed", func(t *testing.T) {
+		ingressMetrics.record("metrics-deleted", "test-app", []hostWeight{{host: "test-app.domain.tld", weight: 100}})
+		ingressMetrics.record("metrics-deleted", "other-app", []hostWeight{{host: "other-app.domain.tld", weight: 100}})
+		before := seriesCount(t, ingressMetrics.HostWeight)
+		reconciler := newReconciler()
+
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "metrics-deleted", Name: "test-app"}})
+		require.NoError(t, err)
+		assert.Equal(t, before-1, seriesCount(t, ingressMetrics.HostWeight))
+		assert.Equal(t, 100.0, metricValue(t, ingressMetrics.HostWeight.WithLabelValues("metrics-deleted", "other-app", "other-app.domain.tld")))
+	})
+}
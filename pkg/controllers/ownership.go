@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+// Labels identifying the controller instance that wrote a DNSEndpoint.
+// They do not depend on the annotation prefix, so the instances using
+// different prefixes recognise each other's objects.
+const (
+	ControllerIDLabel = "dns.adevinta.com/controller-id"
+	ClusterNameLabel  = "dns.adevinta.com/cluster-name"
+	SourceKindLabel   = "dns.adevinta.com/source-kind"
+)
+
+const ingressSourceKind = "Ingress"
+
+// ValidateOwnershipLabel checks that a value can be used in the ownership labels
+func ValidateOwnershipLabel(name, value string) error {
+	if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+		return fmt.Errorf("invalid %s %q: %s", name, value, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// controllerID identifies this controller instance in the DNSEndpoint labels,
+// the annotation prefix unless ControllerID is set
+func (r *IngressReconciler) controllerID() string {
+	if r.ControllerID != "" {
+		return r.ControllerID
+	}
+	return r.AnnotationPrefix
+}
+
+func (r *IngressReconciler) ownershipLabels() map[string]string {
+	return map[string]string{
+		ControllerIDLabel: r.controllerID(),
+		ClusterNameLabel:  r.ClusterName,
+		SourceKindLabel:   ingressSourceKind,
+	}
+}
+
+func (r *IngressReconciler) labelOwnership(dnsEndpoint *externaldnsk8siov1alpha1.DNSEndpoint) {
+	if dnsEndpoint.Labels == nil {
+		dnsEndpoint.Labels = map[string]string{}
+	}
+	for key, value := range r.ownershipLabels() {
+		dnsEndpoint.Labels[key] = value
+	}
+}
+
+// hasOwnershipLabels tells whether the DNSEndpoint was written by a controller
+// instance setting the ownership labels, not necessarily this one
+func hasOwnershipLabels(dnsEndpoint *externaldnsk8siov1alpha1.DNSEndpoint) bool {
+	_, ok := dnsEndpoint.Labels[ControllerIDLabel]
+	return ok
+}
+
+// ownsDNSEndpoint tells whether the DNSEndpoint belongs to this controller
+// instance. DNSEndpoints written before the ownership labels are adopted when
+// they are controlled by an ingress with the same name, the given one when set.
+func (r *IngressReconciler) ownsDNSEndpoint(dnsEndpoint *externaldnsk8siov1alpha1.DNSEndpoint, ingress *netv1.Ingress) bool {
+	if hasOwnershipLabels(dnsEndpoint) {
+		for key, value := range r.ownershipLabels() {
+			if dnsEndpoint.Labels[key] != value {
+				return false
+			}
+		}
+		return true
+	}
+	if ingress != nil {
+		return metav1.IsControlledBy(dnsEndpoint, ingress)
+	}
+	owner := metav1.GetControllerOf(dnsEndpoint)
+	return owner != nil && owner.Kind == ingressSourceKind && owner.Name == dnsEndpoint.Name
+}
+
+// describeOwner tells who owns a DNSEndpoint not owned by this controller instance
+func describeOwner(dnsEndpoint *externaldnsk8siov1alpha1.DNSEndpoint) string {
+	if hasOwnershipLabels(dnsEndpoint) {
+		return fmt.Sprintf("the controller %q of the cluster %q", dnsEndpoint.Labels[ControllerIDLabel], dnsEndpoint.Labels[ClusterNameLabel])
+	}
+	if owner := metav1.GetControllerOf(dnsEndpoint); owner != nil {
+		return fmt.Sprintf("the %s %s (uid %s)", owner.Kind, owner.Name, owner.UID)
+	}
+	return "no controller"
+}
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestDNSEndpointOwnership(t *testing.T) {
+	key := types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}
+	reconcile := func(t *testing.T, recorder *record.FakeRecorder, objects ...client.Object) client.Client {
+		t.Helper()
+		objects = append(objects, mockEndpoint(epWithName("test-app")), mockEndpoint(epWithName("test-app-a")))
+		k8sClient := fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(objects...).Build()
+		reconciler := IngressReconciler{
+			Client:           k8sClient,
+			Log:              logruslogr.NewLogr(&logrus.Logger{}),
+			AnnotationPrefix: "dns.adevinta.com",
+			ClusterName:      "cluster-1",
+			Recorder:         recorder,
+			WeightStore:      trafficweight.NewWeightStore(trafficweight.StoreConfig{DesiredWeight: 50, CurrentWeight: 50}),
+		}
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		require.NoError(t, err)
+		return k8sClient
+	}
+	withUID := func(ing *netv1.Ingress) { ing.UID = "ingress-uid" }
+
+	t.Run("created DNSEndpoints get the ownership labels", func(t *testing.T) {
+		k8sClient := reconcile(t, record.NewFakeRecorder(10), mockIngress(withUID))
+		dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{}
+		require.NoError(t, k8sClient.Get(context.Background(), key, dnsEndpoint))
+		assert.Equal(t, map[string]string{
+			ControllerIDLabel: "dns.adevinta.com",
+			ClusterNameLabel:  "cluster-1",
+			SourceKindLabel:   "Ingress",
+		}, dnsEndpoint.Labels)
+	})
+
+	t.Run("DNSEndpoints written before the ownership labels are adopted", func(t *testing.T) {
+		legacy := ownedDNSEndpoint("test-app", "ingress-uid")
+		legacy.Labels = map[string]string{"app": "test-app"}
+		k8sClient := reconcile(t, record.NewFakeRecorder(10), mockIngress(withUID), legacy)
+		dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{}
+		require.NoError(t, k8sClient.Get(context.Background(), key, dnsEndpoint))
+		assert.Equal(t, "dns.adevinta.com", dnsEndpoint.Labels[ControllerIDLabel])
+		assert.Equal(t, "test-app", dnsEndpoint.Labels["app"])
+		assert.Equal(t, "test-app.domain.tld", dnsEndpoint.Spec.Endpoints[0].DNSName)
+	})
+
+	t.Run("DNSEndpoints owned by someone else are not adopted", func(t *testing.T) {
+		otherController := ownedDNSEndpoint("test-app", "ingress-uid")
+		otherController.Labels[ControllerIDLabel] = "other.adevinta.com"
+		otherCluster := ownedDNSEndpoint("test-app", "ingress-uid")
+		otherCluster.Labels[ClusterNameLabel] = "cluster-2"
+		handMade := &externaldnsk8siov1alpha1.DNSEndpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "cpr-dev", Name: "test-app"}}
+
+		for _, existing := range []*externaldnsk8siov1alpha1.DNSEndpoint{otherController, otherCluster, handMade} {
+			recorder := record.NewFakeRecorder(10)
+			k8sClient := reconcile(t, recorder, mockIngress(withUID), existing.DeepCopy())
+
+			dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{}
+			require.NoError(t, k8sClient.Get(context.Background(), key, dnsEndpoint))
+			assert.Equal(t, existing.Labels, dnsEndpoint.Labels)
+			assert.Equal(t, existing.Spec, dnsEndpoint.Spec)
+			assert.Equal(t, ReasonOwnershipConflict, ingressTrafficStatus(t, k8sClient).Reason)
+			require.Len(t, recorder.Events, 1)
+			assert.Contains(t, <-recorder.Events, "Warning OwnershipConflict the DNSEndpoint cpr-dev/test-app is owned by")
+			assert.Equal(t, 1.0, metricValue(t, ingressMetrics.OwnershipConflicts.WithLabelValues("cpr-dev", "test-app")))
+		}
+
+		reconcile(t, record.NewFakeRecorder(10), mockIngress(withUID))
+		assert.Equal(t, 0, seriesCount(t, ingressMetrics.OwnershipConflicts), "conflicts are cleared once the DNSEndpoint is written")
+	})
+}
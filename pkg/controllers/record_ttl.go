@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	netv1 "k8s.io/api/networking/v1"
+)
+
+// InvalidRecordTTLError is returned when the record-ttl annotation can not be used
+type InvalidRecordTTLError struct {
+	Annotation string
+	Reason     string
+}
+
+func (e *InvalidRecordTTLError) Error() string {
+	return fmt.Sprintf("invalid annotation %s: %s", e.Annotation, e.Reason)
+}
+
+// ingressRecordTTL returns the TTL of the record-ttl annotation of the ingress, 0 when not set
+func (r *IngressReconciler) ingressRecordTTL(ingress netv1.Ingress) (int64, error) {
+	annotation := r.annotationKey("record-ttl")
+	value, ok := ingress.Annotations[annotation]
+	if !ok {
+		return 0, nil
+	}
+	ttl, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || ttl <= 0 {
+		return 0, &InvalidRecordTTLError{Annotation: annotation, Reason: fmt.Sprintf("expected a positive number of seconds, got %q", value)}
+	}
+	if err := trafficweight.ValidateRecordTTL(ttl); err != nil {
+		return 0, &InvalidRecordTTLError{Annotation: annotation, Reason: err.Error()}
+	}
+	return ttl, nil
+}
+
+// recordTTL returns the TTL of a record, from the first one set of: the
+// override of the weight backend, the annotation of the ingress, the ttl of the
+// binding domain and RecordTTL. The provider default is used when 0.
+func (r *IngressReconciler) recordTTL(store trafficweight.StoreConfig, ingressTTL int64, domain BindingDomain) int64 {
+	for _, ttl := range []int64{store.RecordTTL, ingressTTL, domain.TTL} {
+		if ttl != 0 {
+			return ttl
+		}
+	}
+	return r.RecordTTL
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestRecordTTL(t *testing.T) {
+	reconcile := func(t *testing.T, flagTTL int64, store trafficweight.StoreConfig, domains []BindingDomain, ingress *netv1.Ingress) client.Client {
+		t.Helper()
+		k8sClient := fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(ingress, mockEndpoint(epWithName("test-app")), mockEndpoint(epWithName("test-app-a"))).Build()
+		store.DesiredWeight, store.CurrentWeight = 40, 40
+		reconciler := IngressReconciler{
+			Client:           k8sClient,
+			Log:              logruslogr.NewLogr(&logrus.Logger{}),
+			AnnotationPrefix: "dns.adevinta.com",
+			ClusterName:      "cluster-1",
+			BindingDomains:   domains,
+			RecordTTL:        flagTTL,
+			Recorder:         record.NewFakeRecorder(10),
+			WeightStore:      trafficweight.NewWeightStore(store),
+		}
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}})
+		require.NoError(t, err)
+		return k8sClient
+	}
+	ttls := func(t *testing.T, k8sClient client.Client) map[string]externaldnsk8siov1alpha1.TTL {
+		t.Helper()
+		dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{}
+		require.NoError(t, k8sClient.Get(context.Background(), types.NamespacedName{Namespace: "cpr-dev", Name: "test-app"}, dnsEndpoint))
+		ttls := map[string]externaldnsk8siov1alpha1.TTL{}
+		for _, endpoint := range dnsEndpoint.Spec.Endpoints {
+			ttls[endpoint.DNSName] = endpoint.RecordTTL
+		}
+		return ttls
+	}
+	annotated := func(ttl string) *netv1.Ingress {
+		return mockIngress(func(ing *netv1.Ingress) {
+			ing.Annotations = map[string]string{"dns.adevinta.com/record-ttl": ttl}
+		})
+	}
+	domains := []BindingDomain{{Domain: "domain.tld", TTL: 60}}
+
+	t.Run("the provider default is used when no ttl is set", func(t *testing.T) {
+		k8sClient := reconcile(t, 0, trafficweight.StoreConfig{}, nil, mockIngress())
+		assert.Equal(t, map[string]externaldnsk8siov1alpha1.TTL{"test-app.domain.tld": 0}, ttls(t, k8sClient))
+	})
+
+	t.Run("the flag sets the ttl of all the records", func(t *testing.T) {
+		k8sClient := reconcile(t, 300, trafficweight.StoreConfig{}, nil, mockIngress())
+		assert.Equal(t, map[string]externaldnsk8siov1alpha1.TTL{"test-app.domain.tld": 300}, ttls(t, k8sClient))
+	})
+
+	t.Run("the binding domain overrides the flag", func(t *testing.T) {
+		k8sClient := reconcile(t, 300, trafficweight.StoreConfig{}, domains, mockIngress())
+		assert.Equal(t, map[string]externaldnsk8siov1alpha1.TTL{"test-app.domain.tld": 60}, ttls(t, k8sClient))
+	})
+
+	t.Run("the annotation overrides the binding domain", func(t *testing.T) {
+		k8sClient := reconcile(t, 300, trafficweight.StoreConfig{}, domains, annotated("120"))
+		assert.Equal(t, map[string]externaldnsk8siov1alpha1.TTL{"test-app.domain.tld": 120}, ttls(t, k8sClient))
+	})
+
+	t.Run("the backend overrides the annotation", func(t *testing.T) {
+		k8sClient := reconcile(t, 300, trafficweight.StoreConfig{RecordTTL: 10}, domains, annotated("120"))
+		assert.Equal(t, map[string]externaldnsk8siov1alpha1.TTL{"test-app.domain.tld": 10}, ttls(t, k8sClient))
+	})
+
+	t.Run("invalid annotations are reported", func(t *testing.T) {
+		for _, value := range []string{"soon", "0", "-5", "2147483648"} {
+			k8sClient := reconcile(t, 300, trafficweight.StoreConfig{}, nil, annotated(value))
+			assert.Equal(t, ReasonInvalidRecordTTL, ingressTrafficStatus(t, k8sClient).Reason, value)
+			assert.Empty(t, ttls(t, k8sClient), value)
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	netv1 "k8s.io/api/networking/v1"
+
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+// maxSetIdentifierLength is the longest SetIdentifier accepted by Route53
+const maxSetIdentifierLength = 128
+
+// setIdentifierData holds the fields available in the set identifier templates
+type setIdentifierData struct {
+	Cluster      string
+	Region       string
+	Namespace    string
+	Ingress      string
+	IngressClass string
+}
+
+// InvalidSetIdentifierError is returned when the set identifier of an ingress can not be used
+type InvalidSetIdentifierError struct {
+	SetIdentifier string
+	Reason        string
+}
+
+func (e *InvalidSetIdentifierError) Error() string {
+	return fmt.Sprintf("invalid set identifier %q: %s", e.SetIdentifier, e.Reason)
+}
+
+// NewSetIdentifierTemplate parses the Go template of the record set
+// identifiers, like "{{.Cluster}}-{{.IngressClass}}". The fields are Cluster,
+// Region, Namespace, Ingress and IngressClass. Empty templates use the
+// cluster name.
+func NewSetIdentifierTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("set-identifier").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid set identifier template %q: %w", text, err)
+	}
+	// Catch the unknown fields before any ingress is reconciled
+	sample := setIdentifierData{Cluster: "cluster", Region: "region", Namespace: "namespace", Ingress: "ingress", IngressClass: "class"}
+	if err := tmpl.Execute(&strings.Builder{}, sample); err != nil {
+		return nil, fmt.Errorf("invalid set identifier template %q: %w", text, err)
+	}
+	return tmpl, nil
+}
+
+// renderSetIdentifier returns the set identifier of the records of the ingress
+func (r *IngressReconciler) renderSetIdentifier(tmpl *template.Template, ingress netv1.Ingress) (string, error) {
+	if tmpl == nil {
+		return r.ClusterName, nil
+	}
+	value := &strings.Builder{}
+	err := tmpl.Execute(value, setIdentifierData{
+		Cluster:      r.ClusterName,
+		Region:       r.AWSRegion,
+		Namespace:    ingress.Namespace,
+		Ingress:      ingress.Name,
+		IngressClass: ingressClassName(ingress),
+	})
+	if err != nil {
+		return "", &InvalidSetIdentifierError{Reason: err.Error()}
+	}
+	setIdentifier := strings.TrimSpace(value.String())
+	if setIdentifier == "" {
+		return "", &InvalidSetIdentifierError{Reason: "the set identifier is empty"}
+	}
+	if len(setIdentifier) > maxSetIdentifierLength {
+		return "", &InvalidSetIdentifierError{SetIdentifier: setIdentifier, Reason: fmt.Sprintf("longer than the %d characters accepted by Route53", maxSetIdentifierLength)}
+	}
+	return setIdentifier, nil
+}
+
+// setIdentifier returns the set identifier of the records of the ingress
+func (r *IngressReconciler) setIdentifier(ingress netv1.Ingress) (string, error) {
+	return r.renderSetIdentifier(r.SetIdentifier, ingress)
+}
+
+// migratedSetIdentifier returns the identifier the records of the ingress had
+// before changing the set identifier template, when it differs from the
+// current one
+func (r *IngressReconciler) migratedSetIdentifier(ingress netv1.Ingress, current string) (string, bool) {
+	if r.PreviousSetIdentifier == nil {
+		return "", false
+	}
+	previous, err := r.renderSetIdentifier(r.PreviousSetIdentifier, ingress)
+	if err != nil {
+		r.Log.Error(err, "Unable to render the previous set identifier, its records are deleted", "IngressName", ingress.Name, "IngressNamespace", ingress.Namespace)
+		return "", false
+	}
+	return previous, previous != current
+}
+
+// errNotMigrating is returned for the ingresses whose set identifier did not change
+var errNotMigrating = errors.New("the set identifier did not change")
+
+// drainedSetIdentifier returns the previous set identifier of the records of
+// the ingress, errNotMigrating when it did not change
+func (r *IngressReconciler) drainedSetIdentifier(ingress netv1.Ingress) (string, error) {
+	if r.PreviousSetIdentifier == nil {
+		return "", errNotMigrating
+	}
+	current, err := r.setIdentifier(ingress)
+	if err != nil {
+		return "", err
+	}
+	previous, err := r.renderSetIdentifier(r.PreviousSetIdentifier, ingress)
+	if err != nil {
+		return "", err
+	}
+	if previous == current {
+		return "", errNotMigrating
+	}
+	return previous, nil
+}
+
+// drainedElsewhere returns the normalized hosts whose record with the previous
+// set identifier of the ingress is kept by another ingress. Ingresses with
+// different set identifiers, like the public and internal ingresses of a host,
+// may share the previous one: only the oldest of them able to write it keeps
+// the drained record.
+func (r *IngressReconciler) drainedElsewhere(ctx context.Context, ingress netv1.Ingress, rules []netv1.IngressRule, store trafficweight.StoreConfig) map[string]bool {
+	hosts := []string{}
+	for _, rule := range rules {
+		hosts = append(hosts, rule.Host)
+	}
+	claims, err := r.identifiedHostClaims(ctx, ingress, hosts, r.drainedSetIdentifier)
+	if err != nil {
+		r.Log.WithValues("IngressName", ingress.Name, "IngressNamespace", ingress.Namespace).Error(err, "Unable to check the ingresses draining the same records")
+		return nil
+	}
+	drained := map[string]bool{}
+	for host, others := range claims {
+		for _, other := range others {
+			if claimedBefore(other.ingress, ingress) && r.publishes(ctx, other.ingress, store) {
+				drained[host] = true
+				break
+			}
+		}
+	}
+	return drained
+}
+
+// drainedEndpoint returns a copy of the record with another set identifier and a weight of 0
+func drainedEndpoint(ep *externaldnsk8siov1alpha1.Endpoint, setIdentifier string) *externaldnsk8siov1alpha1.Endpoint {
+	drained := ep.DeepCopy()
+	drained.SetIdentifier = setIdentifier
+	for i, property := range drained.ProviderSpecific {
+		if property.Name == "aws/weight" {
+			drained.ProviderSpecific[i].Value = "0"
+		}
+	}
+	return drained
+}
+
+// PublishedByCluster tells whether the record of the DNSEndpoint is published
+// for the cluster. DNSEndpoints written before the ownership labels are
+// recognised by their set identifier.
+func PublishedByCluster(dnsEndpoint *externaldnsk8siov1alpha1.DNSEndpoint, ep *externaldnsk8siov1alpha1.Endpoint, clusterName string) bool {
+	if hasOwnershipLabels(dnsEndpoint) {
+		return dnsEndpoint.Labels[ClusterNameLabel] == clusterName
+	}
+	return ep.SetIdentifier == clusterName
+}
@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+func mustSetIdentifierTemplate(t *testing.T, text string) *IngressReconciler {
+	t.Helper()
+	tmpl, err := NewSetIdentifierTemplate(text)
+	require.NoError(t, err)
+	return &IngressReconciler{
+		Log:           logruslogr.NewLogr(&logrus.Logger{}),
+		ClusterName:   "cluster-1",
+		AWSRegion:     "eu-west-7",
+		SetIdentifier: tmpl,
+	}
+}
+
+func TestNewSetIdentifierTemplate(t *testing.T) {
+	tmpl, err := NewSetIdentifierTemplate("")
+	assert.NoError(t, err)
+	assert.Nil(t, tmpl, "the cluster name is used")
+
+	_, err = NewSetIdentifierTemplate("{{.Cluster")
+	assert.Error(t, err)
+
+	_, err = NewSetIdentifierTemplate("{{.Cluster}}-{{.Zone}}")
+	assert.Error(t, err, "unknown fields are rejected before reconciling")
+}
+
+func TestRenderSetIdentifier(t *testing.T) {
+	className := "internal"
+	ingress := *mockIngress(func(ing *netv1.Ingress) { ing.Spec.IngressClassName = &className })
+
+	t.Run("the cluster name is used by default", func(t *testing.T) {
+		setIdentifier, err := mustSetIdentifierTemplate(t, "").setIdentifier(ingress)
+		require.NoError(t, err)
+		assert.Equal(t, "cluster-1", setIdentifier)
+	})
+
+	t.Run("the template fields are rendered", func(t *testing.T) {
+		setIdentifier, err := mustSetIdentifierTemplate(t, "{{.Cluster}}-{{.IngressClass}}").setIdentifier(ingress)
+		require.NoError(t, err)
+		assert.Equal(t, "cluster-1-internal", setIdentifier)
+
+		setIdentifier, err = mustSetIdentifierTemplate(t, "{{.Region}}/{{.Namespace}}/{{.Ingress}}").setIdentifier(ingress)
+		require.NoError(t, err)
+		assert.Equal(t, "eu-west-7/cpr-dev/test-app", setIdentifier)
+	})
+
+	t.Run("empty identifiers are rejected", func(t *testing.T) {
+		_, err := mustSetIdentifierTemplate(t, "{{.IngressClass}}").setIdentifier(*mockIngress())
+		var setIdentifierErr *InvalidSetIdentifierError
+		assert.ErrorAs(t, err, &setIdentifierErr)
+	})
+
+	t.Run("identifiers longer than accepted by Route53 are rejected", func(t *testing.T) {
+		_, err := mustSetIdentifierTemplate(t, "{{.Cluster}}-"+strings.Repeat("x", 120)).setIdentifier(ingress)
+		var setIdentifierErr *InvalidSetIdentifierError
+		assert.ErrorAs(t, err, &setIdentifierErr)
+	})
+}
+
+func TestSetIdentifierRecords(t *testing.T) {
+	reconcile := func(t *testing.T, setIdentifier, previousSetIdentifier string, objects ...client.Object) client.Client {
+		t.Helper()
+		objects = append(objects, mockEndpoint(epWithName("test-app")), mockEndpoint(epWithName("test-app-a")))
+		k8sClient := fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(objects...).Build()
+		current, err := NewSetIdentifierTemplate(setIdentifier)
+		require.NoError(t, err)
+		previous, err := NewSetIdentifierTemplate(previousSetIdentifier)
+		require.NoError(t, err)
+		reconciler := IngressReconciler{
+			Client:                k8sClient,
+			Log:                   logruslogr.NewLogr(&logrus.Logger{}),
+			AnnotationPrefix:      "dns.adevinta.com",
+			ClusterName:           "cluster-1",
+			SetIdentifier:         current,
+			PreviousSetIdentifier: previous,
+			Recorder:              record.NewFakeRecorder(10),
+			WeightStore:           trafficweight.NewWeightStore(trafficweight.StoreConfig{DesiredWeight: 40, CurrentWeight: 40}),
+		}
+		ingresses := netv1.IngressList{}
+		require.NoError(t, k8sClient.List(context.Background(), &ingresses))
+		for _, ingress := range ingresses.Items {
+			_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&ingress)})
+			require.NoError(t, err)
+		}
+		return k8sClient
+	}
+	records := func(t *testing.T, k8sClient client.Client, name string) map[string]string {
+		t.Helper()
+		dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{}
+		require.NoError(t, k8sClient.Get(context.Background(), types.NamespacedName{Namespace: "cpr-dev", Name: name}, dnsEndpoint))
+		weights := map[string]string{}
+		for _, endpoint := range dnsEndpoint.Spec.Endpoints {
+			weights[endpoint.DNSName+"/"+endpoint.SetIdentifier] = endpoint.ProviderSpecific[0].Value
+		}
+		return weights
+	}
+	public, internal := "public", "internal"
+
+	t.Run("records get the rendered set identifier", func(t *testing.T) {
+		k8sClient := reconcile(t, "{{.Cluster}}-{{.IngressClass}}", "", mockIngress(func(ing *netv1.Ingress) { ing.Spec.IngressClassName = &public }))
+
+		assert.Equal(t, map[string]string{"test-app.domain.tld/cluster-1-public": "40"}, records(t, k8sClient, "test-app"))
+	})
+
+	t.Run("records with the previous set identifier are drained while migrating", func(t *testing.T) {
+		k8sClient := reconcile(t, "{{.Cluster}}-{{.IngressClass}}", "{{.Cluster}}", mockIngress(func(ing *netv1.Ingress) { ing.Spec.IngressClassName = &public }))
+
+		assert.Equal(t,
+			map[string]string{"test-app.domain.tld/cluster-1-public": "40", "test-app.domain.tld/cluster-1": "0"},
+			records(t, k8sClient, "test-app"),
+		)
+	})
+
+	t.Run("records are not duplicated when the identifier did not change", func(t *testing.T) {
+		k8sClient := reconcile(t, "{{.Cluster}}-{{.IngressClass}}", "{{.Cluster}}-{{.IngressClass}}", mockIngress(func(ing *netv1.Ingress) { ing.Spec.IngressClassName = &public }))
+
+		assert.Equal(t, map[string]string{"test-app.domain.tld/cluster-1-public": "40"}, records(t, k8sClient, "test-app"))
+	})
+
+	t.Run("ingresses with different set identifiers publish the same host", func(t *testing.T) {
+		k8sClient := reconcile(t, "{{.Cluster}}-{{.IngressClass}}", "",
+			mockIngress(func(ing *netv1.Ingress) {
+				ing.Name = "public"
+				ing.CreationTimestamp = metav1.Unix(1, 0)
+				ing.Spec.IngressClassName = &public
+			}),
+			mockIngress(func(ing *netv1.Ingress) {
+				ing.Name = "internal"
+				ing.CreationTimestamp = metav1.Unix(2, 0)
+				ing.Spec.IngressClassName = &internal
+				ing.Status.LoadBalancer.Ingress[0].Hostname = "internal-lb"
+			}),
+		)
+
+		assert.Equal(t, map[string]string{"test-app.domain.tld/cluster-1-public": "40"}, records(t, k8sClient, "public"))
+		assert.Equal(t, map[string]string{"test-app.domain.tld/cluster-1-internal": "40"}, records(t, k8sClient, "internal"))
+	})
+
+	t.Run("a single ingress drains the records of a previous set identifier shared with other ingresses", func(t *testing.T) {
+		publicIngress := mockIngress(func(ing *netv1.Ingress) {
+			ing.Name = "public"
+			ing.CreationTimestamp = metav1.Unix(1, 0)
+			ing.Spec.IngressClassName = &public
+		})
+		internalIngress := mockIngress(func(ing *netv1.Ingress) {
+			ing.Name = "internal"
+			ing.CreationTimestamp = metav1.Unix(2, 0)
+			ing.Spec.IngressClassName = &internal
+			ing.Status.LoadBalancer.Ingress[0].Hostname = "internal-lb"
+		})
+		k8sClient := reconcile(t, "{{.Cluster}}-{{.IngressClass}}", "{{.Cluster}}", publicIngress.DeepCopy(), internalIngress.DeepCopy())
+
+		assert.Equal(t,
+			map[string]string{"test-app.domain.tld/cluster-1-public": "40", "test-app.domain.tld/cluster-1": "0"},
+			records(t, k8sClient, "public"),
+		)
+		assert.Equal(t, map[string]string{"test-app.domain.tld/cluster-1-internal": "40"}, records(t, k8sClient, "internal"))
+
+		// The internal ingress takes the drained record over when the public one can not write it
+		publicIngress.Status.LoadBalancer.Ingress = nil
+		k8sClient = reconcile(t, "{{.Cluster}}-{{.IngressClass}}", "{{.Cluster}}", publicIngress, internalIngress)
+
+		assert.Equal(t,
+			map[string]string{"test-app.domain.tld/cluster-1-internal": "40", "test-app.domain.tld/cluster-1": "0"},
+			records(t, k8sClient, "internal"),
+		)
+	})
+
+	t.Run("the ingresses draining the same records are reconciled together", func(t *testing.T) {
+		current, err := NewSetIdentifierTemplate("{{.Cluster}}-{{.IngressClass}}")
+		require.NoError(t, err)
+		previous, err := NewSetIdentifierTemplate("{{.Cluster}}")
+		require.NoError(t, err)
+		publicIngress := mockIngress(func(ing *netv1.Ingress) { ing.Name = "public"; ing.Spec.IngressClassName = &public })
+		reconciler := IngressReconciler{
+			Client: fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(
+				publicIngress,
+				mockIngress(func(ing *netv1.Ingress) { ing.Name = "internal"; ing.Spec.IngressClassName = &internal }),
+			).Build(),
+			Log:                   logruslogr.NewLogr(&logrus.Logger{}),
+			AnnotationPrefix:      "dns.adevinta.com",
+			ClusterName:           "cluster-1",
+			SetIdentifier:         current,
+			PreviousSetIdentifier: previous,
+		}
+		assert.Equal(t,
+			[]ctrl.Request{{NamespacedName: types.NamespacedName{Namespace: "cpr-dev", Name: "internal"}}},
+			reconciler.mapHostConflicts(context.Background(), publicIngress),
+		)
+	})
+
+	t.Run("ingresses with an invalid set identifier are reported", func(t *testing.T) {
+		k8sClient := reconcile(t, "{{.IngressClass}}", "", mockIngress())
+
+		status := ingressTrafficStatus(t, k8sClient)
+		assert.Equal(t, ReasonInvalidSetIdentifier, status.Reason)
+		assert.Empty(t, records(t, k8sClient, "test-app"))
+	})
+}
+
+func TestPublishedByCluster(t *testing.T) {
+	ep := &externaldnsk8siov1alpha1.Endpoint{DNSName: "test-app.domain.tld", SetIdentifier: "cluster-1-public"}
+
+	labeled := &externaldnsk8siov1alpha1.DNSEndpoint{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+		ControllerIDLabel: "dns.adevinta.com",
+		ClusterNameLabel:  "cluster-1",
+		SourceKindLabel:   "Ingress",
+	}}}
+	assert.True(t, PublishedByCluster(labeled, ep, "cluster-1"))
+	assert.False(t, PublishedByCluster(labeled, ep, "cluster-2"))
+
+	unlabeled := &externaldnsk8siov1alpha1.DNSEndpoint{}
+	assert.False(t, PublishedByCluster(unlabeled, ep, "cluster-1"))
+	assert.True(t, PublishedByCluster(unlabeled, &externaldnsk8siov1alpha1.Endpoint{SetIdentifier: "cluster-1"}, "cluster-1"))
+}
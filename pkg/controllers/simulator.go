@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+)
+
+// SimulatedCluster describes a cluster in a traffic simulation
+type SimulatedCluster struct {
+	Name string
+	// Weight is the cluster weight applied by the controller
+	Weight    int
+	Ingresses []netv1.Ingress
+	// UnreadyServices have no ready pods, the services of the ingresses are ready otherwise
+	UnreadyServices []types.NamespacedName
+}
+
+// SimulationOptions holds the controller flags affecting the DNS weights
+type SimulationOptions struct {
+	AnnotationPrefix string
+	AnnotationFilter string
+	LabelFilter      string
+	IngressClass     string
+	BindingDomains   []BindingDomain
+	ExcludedHosts    []string
+	IncludeTLSHosts  bool
+	// HostConflictPolicy resolves the hosts declared by several ingresses of a cluster
+	HostConflictPolicy HostConflictPolicy
+	// SetIdentifierTemplate renders the set identifiers of the records, the cluster name when empty
+	SetIdentifierTemplate string
+}
+
+// SimulatedRecord is the DNS record an ingress gets for a host in a cluster
+type SimulatedRecord struct {
+	Cluster   string
+	Namespace string
+	Ingress   string
+	Host      string
+	Weight    uint
+	// WithoutPods is set when the weight is zeroed because the host services have no ready pods
+	WithoutPods bool
+	// Traffic is the percentage of the host traffic received by the record
+	Traffic float64
+}
+
+// simulatedEndpoints answers the Endpoints lookups of the reconciler with the
+// readiness assumptions, the Ingress lists with the cluster ingresses, and
+// the DNSEndpoint lookups as not found, so no ingress has an ownership conflict
+type simulatedEndpoints struct {
+	client.Client
+	unready   map[types.NamespacedName]bool
+	ingresses []netv1.Ingress
+}
+
+func (c simulatedEndpoints) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	ingresses, ok := list.(*netv1.IngressList)
+	if !ok {
+		return fmt.Errorf("unexpected list of %T in a simulation", list)
+	}
+	ingresses.Items = c.ingresses
+	return nil
+}
+
+func (c simulatedEndpoints) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if _, ok := obj.(*externaldnsk8siov1alpha1.DNSEndpoint); ok {
+		return apierrors.NewNotFound(schema.GroupResource{Group: "externaldns.k8s.io", Resource: "dnsendpoints"}, key.Name)
+	}
+	endpoints, ok := obj.(*v1.Endpoints)
+	if !ok {
+		return fmt.Errorf("unexpected lookup of %T %s in a simulation", obj, key)
+	}
+	endpoints.Name = key.Name
+	endpoints.Namespace = key.Namespace
+	if !c.unready[key] {
+		endpoints.Subsets = []v1.EndpointSubset{{Addresses: []v1.EndpointAddress{{IP: "127.0.0.1"}}}}
+	}
+	return nil
+}
+
+// Simulate computes the DNS records the controllers of the clusters would
+// publish for their ingresses, and how the traffic of every host is split
+// between these records. Records are sorted by host, cluster and ingress.
+func Simulate(ctx context.Context, options SimulationOptions, clusters []SimulatedCluster) ([]SimulatedRecord, error) {
+	annotationFilter, err := NewAnnotationFilter(options.AnnotationFilter)
+	if err != nil {
+		return nil, err
+	}
+	labelFilter, err := NewSelector(options.LabelFilter)
+	if err != nil {
+		return nil, err
+	}
+	setIdentifier, err := NewSetIdentifierTemplate(options.SetIdentifierTemplate)
+	if err != nil {
+		return nil, err
+	}
+	records := []SimulatedRecord{}
+	for _, cluster := range clusters {
+		unready := map[types.NamespacedName]bool{}
+		for _, service := range cluster.UnreadyServices {
+			unready[service] = true
+		}
+		reconciler := IngressReconciler{
+			Client:             simulatedEndpoints{unready: unready, ingresses: cluster.Ingresses},
+			Log:                logr.Discard(),
+			ClusterName:        cluster.Name,
+			BindingDomains:     options.BindingDomains,
+			ExcludedHosts:      options.ExcludedHosts,
+			IncludeTLSHosts:    options.IncludeTLSHosts,
+			HostConflictPolicy: options.HostConflictPolicy,
+			SetIdentifier:      setIdentifier,
+			AnnotationFilter:   annotationFilter,
+			LabelFilter:        labelFilter,
+			IngressClass:       options.IngressClass,
+			AnnotationPrefix:   options.AnnotationPrefix,
+			// The load balancers of the ingresses are assumed to be provisioned
+			DevMode: true,
+			WeightStore: trafficweight.NewWeightStore(trafficweight.StoreConfig{
+				DesiredWeight: cluster.Weight,
+				CurrentWeight: cluster.Weight,
+			}),
+		}
+		for _, ingress := range cluster.Ingresses {
+			if !reconciler.ingressMatchesObjectFilters(ingress) {
+				continue
+			}
+			dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{}
+			target, _ := reconciler.getTargetFromIngress(ingress)
+			hosts, err := reconciler.newDnsEndpoint(ctx, dnsEndpoint, target, ingress, metav1.OwnerReference{})
+			if err != nil {
+				return nil, fmt.Errorf("cluster %s, ingress %s/%s: %w", cluster.Name, ingress.Namespace, ingress.Name, err)
+			}
+			for _, host := range hosts {
+				if host.shadowed() {
+					continue
+				}
+				records = append(records, SimulatedRecord{
+					Cluster:     cluster.Name,
+					Namespace:   ingress.Namespace,
+					Ingress:     ingress.Name,
+					Host:        host.host,
+					Weight:      host.weight,
+					WithoutPods: host.withoutPods,
+				})
+			}
+		}
+	}
+
+	// Records are keyed by their index to split the traffic of each host
+	byHost := map[string]map[string]uint{}
+	for i, record := range records {
+		if byHost[record.Host] == nil {
+			byHost[record.Host] = map[string]uint{}
+		}
+		byHost[record.Host][strconv.Itoa(i)] = record.Weight
+	}
+	for _, weights := range byHost {
+		for key, traffic := range trafficweight.TrafficSplit(weights) {
+			i, _ := strconv.Atoi(key)
+			records[i].Traffic = traffic
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Ingress < b.Ingress
+	})
+	return records, nil
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSimulate(t *testing.T) {
+	annotated := func(weight string) netv1.Ingress {
+		return *mockIngress(func(ing *netv1.Ingress) {
+			ing.Annotations = map[string]string{"dns.adevinta.com/traffic-weight": weight}
+		})
+	}
+	options := SimulationOptions{AnnotationPrefix: "dns.adevinta.com"}
+
+	t.Run("weights are split like the controller does", func(t *testing.T) {
+		records, err := Simulate(context.Background(), options, []SimulatedCluster{
+			{Name: "cluster-1", Weight: 50, Ingresses: []netv1.Ingress{annotated("25")}},
+			{Name: "cluster-2", Weight: 50, Ingresses: []netv1.Ingress{annotated("75")}},
+		})
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, SimulatedRecord{Cluster: "cluster-1", Namespace: "cpr-dev", Ingress: "test-app", Host: "test-app.domain.tld", Weight: 13, Traffic: 100 * 13.0 / 51}, records[0])
+		assert.Equal(t, SimulatedRecord{Cluster: "cluster-2", Namespace: "cpr-dev", Ingress: "test-app", Host: "test-app.domain.tld", Weight: 38, Traffic: 100 * 38.0 / 51}, records[1])
+	})
+
+	t.Run("ingresses without annotation get the cluster weight", func(t *testing.T) {
+		records, err := Simulate(context.Background(), options, []SimulatedCluster{
+			{Name: "cluster-1", Weight: 100, Ingresses: []netv1.Ingress{*mockIngress()}},
+			{Name: "cluster-2", Weight: 100, Ingresses: []netv1.Ingress{annotated("50")}},
+		})
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, uint(100), records[0].Weight)
+		assert.Equal(t, uint(50), records[1].Weight)
+		assert.InDelta(t, 66.67, records[0].Traffic, 0.01)
+	})
+
+	t.Run("hosts without ready pods are zeroed", func(t *testing.T) {
+		records, err := Simulate(context.Background(), options, []SimulatedCluster{
+			{Name: "cluster-1", Weight: 100, Ingresses: []netv1.Ingress{*mockIngress()}, UnreadyServices: []types.NamespacedName{{Namespace: "cpr-dev", Name: "test-app-a"}}},
+			{Name: "cluster-2", Weight: 10, Ingresses: []netv1.Ingress{*mockIngress()}},
+		})
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, uint(0), records[0].Weight)
+		assert.True(t, records[0].WithoutPods)
+		assert.Equal(t, 100.0, records[1].Traffic)
+	})
+
+	t.Run("hosts outside the binding domain and filtered ingresses are ignored", func(t *testing.T) {
+		filtered := mockIngress(func(ing *netv1.Ingress) {
+			ing.Annotations = map[string]string{"team": "other"}
+		})
+		records, err := Simulate(context.Background(), SimulationOptions{AnnotationPrefix: "dns.adevinta.com", AnnotationFilter: "team=mine"}, []SimulatedCluster{
+			{Name: "cluster-1", Weight: 100, Ingresses: []netv1.Ingress{*filtered}},
+		})
+		require.NoError(t, err)
+		assert.Empty(t, records)
+
+		records, err = Simulate(context.Background(), SimulationOptions{AnnotationPrefix: "dns.adevinta.com", BindingDomains: []BindingDomain{{Domain: "other.tld"}}}, []SimulatedCluster{
+			{Name: "cluster-1", Weight: 100, Ingresses: []netv1.Ingress{*mockIngress()}},
+		})
+		require.NoError(t, err)
+		assert.Empty(t, records)
+	})
+
+	t.Run("hosts declared by several ingresses get a single record", func(t *testing.T) {
+		older := mockIngress(func(ing *netv1.Ingress) {
+			ing.Name = "older"
+			ing.CreationTimestamp = metav1.Unix(1, 0)
+		})
+		newer := mockIngress(func(ing *netv1.Ingress) {
+			ing.Name = "newer"
+			ing.CreationTimestamp = metav1.Unix(2, 0)
+			ing.Annotations = map[string]string{"dns.adevinta.com/traffic-weight": "50"}
+		})
+		for policy, weight := range map[HostConflictPolicy]uint{"": 50, HostConflictMerge: 50, HostConflictOldest: 100} {
+			records, err := Simulate(context.Background(), SimulationOptions{AnnotationPrefix: "dns.adevinta.com", HostConflictPolicy: policy}, []SimulatedCluster{
+				{Name: "cluster-1", Weight: 100, Ingresses: []netv1.Ingress{*older, *newer}},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []SimulatedRecord{
+				{Cluster: "cluster-1", Namespace: "cpr-dev", Ingress: "older", Host: "test-app.domain.tld", Weight: weight, Traffic: 100},
+			}, records, policy)
+		}
+	})
+
+	t.Run("invalid annotations are reported", func(t *testing.T) {
+		_, err := Simulate(context.Background(), options, []SimulatedCluster{
+			{Name: "cluster-1", Weight: 100, Ingresses: []netv1.Ingress{annotated("half")}},
+		})
+		assert.ErrorContains(t, err, "cluster cluster-1, ingress cpr-dev/test-app")
+	})
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"encoding/json"
+
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+// weightBreakdown records in the DNSEndpoint how its weights were calculated
+type weightBreakdown struct {
+	// ClusterWeight is the weight of the cluster read from the backend
+	ClusterWeight int `json:"clusterWeight"`
+	// AnnotationWeight is the traffic-weight annotation of the ingress, if any
+	AnnotationWeight string `json:"annotationWeight,omitempty"`
+	// BackendVersion is the version of the cluster weight in the backend
+	BackendVersion int                            `json:"backendVersion"`
+	Hosts          map[string]hostWeightBreakdown `json:"hosts,omitempty"`
+}
+
+type hostWeightBreakdown struct {
+	// ReadinessFactor is 0 when the services of the host have no ready pods, 1 otherwise
+	ReadinessFactor uint `json:"readinessFactor"`
+	// Weight is the weight set in the DNS record
+	Weight uint `json:"weight"`
+	// HealthCheckID is the health check set in the DNS record, from the
+	// binding domain of the host or the cluster, if any
+	HealthCheckID string `json:"healthCheckID,omitempty"`
+}
+
+func newWeightBreakdown(store trafficweight.StoreConfig, annotationWeight string) *weightBreakdown {
+	return &weightBreakdown{
+		ClusterWeight:    store.DesiredWeight,
+		AnnotationWeight: annotationWeight,
+		BackendVersion:   store.Version,
+		Hosts:            map[string]hostWeightBreakdown{},
+	}
+}
+
+// addHost records the readiness and health check of host and returns its
+// weight given the weight of the ingress
+func (b *weightBreakdown) addHost(host string, ingressWeight uint, withoutPods bool, healthCheckID string) uint {
+	factor := uint(1)
+	if withoutPods {
+		factor = 0
+	}
+	b.Hosts[host] = hostWeightBreakdown{ReadinessFactor: factor, Weight: ingressWeight * factor, HealthCheckID: healthCheckID}
+	return ingressWeight * factor
+}
+
+func (b *weightBreakdown) annotate(dnsEndpoint *externaldnsk8siov1alpha1.DNSEndpoint, key string) error {
+	value, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	if dnsEndpoint.Annotations == nil {
+		dnsEndpoint.Annotations = map[string]string{}
+	}
+	dnsEndpoint.Annotations[key] = string(value)
+	return nil
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	netv1 "k8s.io/api/networking/v1"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+func isWildcardHost(host string) bool {
+	return strings.Contains(host, "*")
+}
+
+// wildcardBase returns the domain below which a wildcard host matches, like
+// apps.foo.io for *.apps.foo.io. As in Ingress rules, the wildcard must be
+// the whole first label.
+func wildcardBase(host string) (string, error) {
+	base, ok := strings.CutPrefix(normalizeDomain(host), "*.")
+	if !ok || base == "" || isWildcardHost(base) {
+		return "", fmt.Errorf("invalid wildcard host %q, only a leading *. label is allowed", host)
+	}
+	return base, nil
+}
+
+// hostName returns the name a host record is for, the base of wildcard hosts
+func hostName(host string) string {
+	if base, err := wildcardBase(host); err == nil {
+		return base
+	}
+	return normalizeDomain(host)
+}
+
+// coveredByWildcard tells whether the wildcard record would answer for host
+// if host had no record of its own. When both exist, DNS answers with the
+// most specific one, so the traffic of host is not split like the one of the
+// wildcard.
+func coveredByWildcard(wildcard, host string) bool {
+	base, err := wildcardBase(wildcard)
+	if err != nil || normalizeDomain(wildcard) == normalizeDomain(host) {
+		return false
+	}
+	return strings.HasSuffix(hostName(host), "."+base)
+}
+
+// wildcardConflicts returns, for the hosts of the ingress, the hosts of the
+// other DNSEndpoints of the cluster they shadow or are shadowed by
+func (r *IngressReconciler) wildcardConflicts(ctx context.Context, ingress netv1.Ingress, hosts []hostWeight) (map[string][]string, error) {
+	var dnsEndpoints externaldnsk8siov1alpha1.DNSEndpointList
+	if err := r.List(ctx, &dnsEndpoints); err != nil {
+		return nil, err
+	}
+	conflicts := map[string][]string{}
+	for _, dnsEndpoint := range dnsEndpoints.Items {
+		if dnsEndpoint.Namespace == ingress.Namespace && dnsEndpoint.Name == ingress.Name {
+			// Hosts of the same ingress overlap on purpose
+			continue
+		}
+		for _, ep := range dnsEndpoint.Spec.Endpoints {
+			if !PublishedByCluster(&dnsEndpoint, ep, r.ClusterName) {
+				continue
+			}
+			for _, host := range hosts {
+				if coveredByWildcard(host.host, ep.DNSName) || coveredByWildcard(ep.DNSName, host.host) {
+					conflicts[host.host] = append(conflicts[host.host], fmt.Sprintf("%s (%s/%s)", ep.DNSName, dnsEndpoint.Namespace, dnsEndpoint.Name))
+				}
+			}
+		}
+	}
+	for host := range conflicts {
+		sort.Strings(conflicts[host])
+		// The records of a host with several set identifiers are reported once
+		conflicts[host] = slices.Compact(conflicts[host])
+	}
+	return conflicts, nil
+}
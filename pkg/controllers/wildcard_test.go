@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	logruslogr "github.com/adevinta/go-log-toolkit"
+	"github.com/adevinta/k8s-traffic-controller/pkg/trafficweight"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	externaldnsk8siov1alpha1 "sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestWildcardHosts(t *testing.T) {
+	reconciler := IngressReconciler{BindingDomains: []BindingDomain{{Domain: "foo.io"}}, ExcludedHosts: []string{"private.foo.io"}}
+	cases := map[string]bool{
+		"*.foo.io":           true,
+		"*.apps.foo.io":      true,
+		"*.io":               false,
+		"*.evilfoo.io":       false,
+		"*.private.foo.io":   false,
+		"apps.*.foo.io":      false,
+		"*apps.foo.io":       false,
+		"*.*.foo.io":         false,
+		"*.apps.foo.io.evil": false,
+	}
+	for host, bound := range cases {
+		_, ok := reconciler.bindingDomain(host)
+		assert.Equal(t, bound, ok, host)
+	}
+
+	assert.True(t, coveredByWildcard("*.apps.foo.io", "api.apps.foo.io"))
+	assert.True(t, coveredByWildcard("*.apps.foo.io", "v1.api.apps.foo.io"))
+	assert.True(t, coveredByWildcard("*.foo.io", "*.apps.foo.io"))
+	assert.False(t, coveredByWildcard("*.apps.foo.io", "apps.foo.io"))
+	assert.False(t, coveredByWildcard("*.apps.foo.io", "*.apps.foo.io"))
+	assert.False(t, coveredByWildcard("*.apps.foo.io", "api.other.foo.io"))
+	assert.False(t, coveredByWildcard("api.apps.foo.io", "v1.api.apps.foo.io"))
+}
+
+func TestWildcardConflicts(t *testing.T) {
+	wildcard := mockIngress(
+		withObjectNamespace[*netv1.Ingress]("wildcard-conflicts"),
+		func(ing *netv1.Ingress) {
+			ing.Spec.Rules[0].Host = "*.apps.domain.tld"
+		},
+	)
+	specific := &externaldnsk8siov1alpha1.DNSEndpoint{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "api"},
+		Spec: externaldnsk8siov1alpha1.DNSEndpointSpec{Endpoints: []*externaldnsk8siov1alpha1.Endpoint{
+			{DNSName: "api.apps.domain.tld", SetIdentifier: "cluster-1"},
+			{DNSName: "api.other.domain.tld", SetIdentifier: "cluster-1"},
+		}},
+	}
+	otherCluster := &externaldnsk8siov1alpha1.DNSEndpoint{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "web"},
+		Spec: externaldnsk8siov1alpha1.DNSEndpointSpec{Endpoints: []*externaldnsk8siov1alpha1.Endpoint{
+			{DNSName: "web.apps.domain.tld", SetIdentifier: "cluster-2"},
+		}},
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(NewScheme()).WithObjects(
+		wildcard, specific, otherCluster,
+		mockEndpoint(epWithName("test-app"), withObjectNamespace[*v1.Endpoints]("wildcard-conflicts")),
+		mockEndpoint(epWithName("test-app-a"), withObjectNamespace[*v1.Endpoints]("wildcard-conflicts")),
+	).Build()
+	reconciler := IngressReconciler{
+		Client:           k8sClient,
+		Log:              logruslogr.NewLogr(&logrus.Logger{}),
+		AnnotationPrefix: "dns.adevinta.com",
+		ClusterName:      "cluster-1",
+		WeightStore:      trafficweight.NewWeightStore(trafficweight.StoreConfig{DesiredWeight: 100, CurrentWeight: 100}),
+	}
+
+	key := types.NamespacedName{Namespace: "wildcard-conflicts", Name: "test-app"}
+	_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+
+	dnsEndpoint := &externaldnsk8siov1alpha1.DNSEndpoint{}
+	require.NoError(t, k8sClient.Get(context.Background(), key, dnsEndpoint))
+	require.Len(t, dnsEndpoint.Spec.Endpoints, 1)
+	assert.Equal(t, "*.apps.domain.tld", dnsEndpoint.Spec.Endpoints[0].DNSName)
+	assert.Equal(t, "cluster-1", dnsEndpoint.Spec.Endpoints[0].SetIdentifier)
+	assert.Equal(t, externaldnsk8siov1alpha1.ProviderSpecific{{Name: "aws/weight", Value: "100"}}, dnsEndpoint.Spec.Endpoints[0].ProviderSpecific)
+
+	ingress := &netv1.Ingress{}
+	require.NoError(t, k8sClient.Get(context.Background(), key, ingress))
+	status := trafficStatus{}
+	require.NoError(t, json.Unmarshal([]byte(ingress.Annotations["dns.adevinta.com/traffic-status"]), &status))
+	assert.Equal(t, ReasonWildcardConflict, status.Reason)
+	assert.Contains(t, status.Message, "*.apps.domain.tld overlaps with api.apps.domain.tld (other/api)")
+	assert.NotContains(t, status.Message, "web.apps.domain.tld")
+	assert.Equal(t, 1.0, metricValue(t, ingressMetrics.WildcardConflicts.WithLabelValues("wildcard-conflicts", "test-app", "*.apps.domain.tld")))
+}
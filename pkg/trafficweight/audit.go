@@ -0,0 +1,183 @@
+package trafficweight
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	log "github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	awssession "github.com/adevinta/k8s-traffic-controller/pkg/aws"
+)
+
+type AuditResult string
+
+const (
+	AuditResultApplied               AuditResult = "Applied"
+	AuditResultLimited               AuditResult = "Limited"
+	AuditResultRejected              AuditResult = "Rejected"
+	AuditResultEnqueueFailed         AuditResult = "EnqueueFailed"
+	AuditResultAcknowledgementFailed AuditResult = "AcknowledgementFailed"
+)
+
+// AuditSourceOutageFallback is the source of the weight changes applied by the OutagePolicy
+const AuditSourceOutageFallback = "outage-fallback"
+
+// AuditEntry records a weight change observed by the ConfigReconciler
+type AuditEntry struct {
+	Time time.Time
+	// OldWeight is the weight applied before the change
+	OldWeight int
+	// NewWeight is the weight applied after the change, which differs from
+	// DesiredWeight when the change is limited or rejected
+	NewWeight     int
+	DesiredWeight int
+	// Version of the DesiredWeight in the backend
+	Version int
+	// Source is the backend the weight was read from
+	Source string
+	// ChangedBy is who changed the weight, when the backend provides it
+	ChangedBy string
+	// AffectedHosts is the number of hosts reconciled with the new weight
+	AffectedHosts int
+	Result        AuditResult
+	Error         string
+}
+
+// AuditSink stores the audit entries
+type AuditSink interface {
+	Record(ctx context.Context, entry AuditEntry) error
+}
+
+// AuditSinks records the entries in all the sinks
+type AuditSinks []AuditSink
+
+func (s AuditSinks) Record(ctx context.Context, entry AuditEntry) error {
+	var errs []error
+	for _, sink := range s {
+		if err := sink.Record(ctx, entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to record the audit entry: %v", errs)
+	}
+	return nil
+}
+
+// LogAuditSink writes the audit entries to a dedicated logger
+type LogAuditSink struct {
+	Log log.Logger
+}
+
+func (s *LogAuditSink) Record(ctx context.Context, entry AuditEntry) error {
+	s.Log.Info("Weight change",
+		"time", entry.Time.UTC().Format(time.RFC3339),
+		"oldWeight", entry.OldWeight,
+		"newWeight", entry.NewWeight,
+		"desiredWeight", entry.DesiredWeight,
+		"version", entry.Version,
+		"source", entry.Source,
+		"changedBy", entry.ChangedBy,
+		"affectedHosts", entry.AffectedHosts,
+		"result", entry.Result,
+		"error", entry.Error,
+	)
+	return nil
+}
+
+// EventAuditSink emits the audit entries as Kubernetes Events on the controller pod
+type EventAuditSink struct {
+	Recorder record.EventRecorder
+	Pod      *corev1.Pod
+}
+
+func NewEventAuditSink(recorder record.EventRecorder, namespace, name string) *EventAuditSink {
+	pod := &corev1.Pod{}
+	pod.Namespace = namespace
+	pod.Name = name
+	return &EventAuditSink{Recorder: recorder, Pod: pod}
+}
+
+func (s *EventAuditSink) Record(ctx context.Context, entry AuditEntry) error {
+	eventType := corev1.EventTypeNormal
+	switch entry.Result {
+	case AuditResultApplied, AuditResultLimited:
+	default:
+		eventType = corev1.EventTypeWarning
+	}
+	message := fmt.Sprintf("Weight changed from %d to %d (desired %d, version %d) from %s", entry.OldWeight, entry.NewWeight, entry.DesiredWeight, entry.Version, entry.Source)
+	if entry.ChangedBy != "" {
+		message += fmt.Sprintf(" by %s", entry.ChangedBy)
+	}
+	if entry.Error != "" {
+		message += fmt.Sprintf(": %s", entry.Error)
+	}
+	s.Recorder.Event(s.Pod, eventType, "Weight"+string(entry.Result), message)
+	return nil
+}
+
+// auditItem is an entry of the DynamoDB history table, keyed by ClusterName and Time
+type auditItem struct {
+	ClusterName   string
+	Time          string
+	OldWeight     int
+	NewWeight     int
+	DesiredWeight int
+	Version       int
+	Source        string
+	ChangedBy     string `dynamodbav:",omitempty"`
+	AffectedHosts int
+	Result        string
+	Error         string `dynamodbav:",omitempty"`
+}
+
+// DynamoDBAuditSink appends the audit entries to a DynamoDB history table.
+// The table must have ClusterName as partition key and Time as sort key.
+type DynamoDBAuditSink struct {
+	clusterName string
+	tableName   string
+	service     dynamodbiface.DynamoDBAPI
+}
+
+func NewDynamoDBAuditSink(clusterName, awsRegion, tableName string) (*DynamoDBAuditSink, error) {
+	session, err := awssession.NewAwsSession(&awssession.SessionParameters{Region: awsRegion, MaxRetries: 10})
+	if err != nil {
+		return nil, fmt.Errorf("error trying to create AWS session: %w", err)
+	}
+	return &DynamoDBAuditSink{clusterName: clusterName, tableName: tableName, service: dynamodb.New(session)}, nil
+}
+
+func (s *DynamoDBAuditSink) Record(ctx context.Context, entry AuditEntry) error {
+	item, err := dynamodbattribute.MarshalMap(auditItem{
+		ClusterName:   s.clusterName,
+		Time:          entry.Time.UTC().Format(time.RFC3339Nano),
+		OldWeight:     entry.OldWeight,
+		NewWeight:     entry.NewWeight,
+		DesiredWeight: entry.DesiredWeight,
+		Version:       entry.Version,
+		Source:        entry.Source,
+		ChangedBy:     entry.ChangedBy,
+		AffectedHosts: entry.AffectedHosts,
+		Result:        string(entry.Result),
+		Error:         entry.Error,
+	})
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	_, err = s.service.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+		// The history is append only
+		ConditionExpression: aws.String("attribute_not_exists(ClusterName)"),
+	})
+	observeRequest("PutItem", start, err)
+	return err
+}
@@ -0,0 +1,165 @@
+package trafficweight
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+type recordingAuditSink struct {
+	entries []AuditEntry
+}
+
+func (s *recordingAuditSink) Record(ctx context.Context, entry AuditEntry) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+type mockAuditTable struct {
+	dynamodbiface.DynamoDBAPI
+	put *dynamodb.PutItemInput
+}
+
+func (m *mockAuditTable) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	m.put = input
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestConfigReconcilerAudit(t *testing.T) {
+	t.Parallel()
+	events := make(chan event.GenericEvent, 10)
+	cache := &fakeCache{ing: &netv1.IngressList{Items: []netv1.Ingress{{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar"},
+		Spec: netv1.IngressSpec{Rules: []netv1.IngressRule{
+			{Host: "foo.cheap.io"},
+			{Host: "bar.cheap.io"},
+		}},
+	}}}}
+
+	t.Run("applied changes are recorded", func(t *testing.T) {
+		sink := &recordingAuditSink{}
+		fake := &testBackend{weight: 80}
+		reconciler := &ConfigReconciler{Backend: fake, Store: NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100}), Cache: cache, Events: events, Audit: sink, Source: "fake"}
+
+		require.NoError(t, reconciler.doReconcile(context.Background()))
+		require.Len(t, sink.entries, 1)
+		entry := sink.entries[0]
+		assert.NotZero(t, entry.Time)
+		entry.Time = time.Time{}
+		assert.Equal(t, AuditEntry{OldWeight: 100, NewWeight: 80, DesiredWeight: 80, Source: "fake", AffectedHosts: 2, Result: AuditResultApplied}, entry)
+
+		// Nothing changed
+		require.NoError(t, reconciler.doReconcile(context.Background()))
+		assert.Len(t, sink.entries, 1)
+	})
+
+	t.Run("limited changes are recorded", func(t *testing.T) {
+		sink := &recordingAuditSink{}
+		fake := &testBackend{weight: 0}
+		reconciler := &ConfigReconciler{Backend: fake, Store: NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100}), Cache: cache, Events: events, Audit: sink, Limits: WeightChangeLimits{MaxDeltaPerInterval: 20}}
+
+		require.NoError(t, reconciler.doReconcile(context.Background()))
+		require.Len(t, sink.entries, 1)
+		assert.Equal(t, AuditResultLimited, sink.entries[0].Result)
+		assert.Equal(t, 80, sink.entries[0].NewWeight)
+		assert.Equal(t, 0, sink.entries[0].DesiredWeight)
+	})
+
+	t.Run("rejected changes are recorded once", func(t *testing.T) {
+		sink := &recordingAuditSink{}
+		fake := &testBackend{weight: 0}
+		reconciler := &ConfigReconciler{Backend: fake, Store: NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100}), Cache: cache, Events: events, Audit: sink, Limits: WeightChangeLimits{MaxDeltaPerChange: 50}}
+
+		assert.Error(t, reconciler.doReconcile(context.Background()))
+		assert.Error(t, reconciler.doReconcile(context.Background()))
+		require.Len(t, sink.entries, 1)
+		assert.Equal(t, AuditResultRejected, sink.entries[0].Result)
+		assert.Equal(t, 100, sink.entries[0].NewWeight)
+		assert.NotEmpty(t, sink.entries[0].Error)
+	})
+
+	t.Run("acknowledgement failures are recorded", func(t *testing.T) {
+		sink := &recordingAuditSink{}
+		fake := &testBackend{weight: 80, err: assert.AnError}
+		reconciler := &ConfigReconciler{Backend: fake, Store: NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100}), Cache: cache, Events: events, Audit: sink}
+
+		assert.Error(t, reconciler.doReconcile(context.Background()))
+		require.Len(t, sink.entries, 1)
+		assert.Equal(t, AuditResultAcknowledgementFailed, sink.entries[0].Result)
+		assert.Equal(t, 80, sink.entries[0].NewWeight)
+	})
+
+	t.Run("outage fallbacks are recorded", func(t *testing.T) {
+		sink := &recordingAuditSink{}
+		fake := &testBackend{readErr: assert.AnError}
+		reconciler := &ConfigReconciler{
+			Backend:       fake,
+			Store:         NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100}),
+			Cache:         cache,
+			Events:        events,
+			Audit:         sink,
+			OutagePolicy:  OutagePolicy{Mode: OutagePolicyFallback, FallbackWeight: 0},
+			LastKnownGood: noLastKnownGoodStore{},
+			Log:           testLogger,
+		}
+
+		reconciler.tick(context.Background())
+		require.Len(t, sink.entries, 1)
+		assert.Equal(t, AuditSourceOutageFallback, sink.entries[0].Source)
+		assert.Equal(t, AuditResultApplied, sink.entries[0].Result)
+		assert.Equal(t, 0, sink.entries[0].NewWeight)
+	})
+}
+
+func TestAuditSinks(t *testing.T) {
+	entry := AuditEntry{
+		Time:          time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+		OldWeight:     100,
+		NewWeight:     0,
+		DesiredWeight: 0,
+		Version:       3,
+		Source:        "dynamoDB",
+		ChangedBy:     "jane",
+		AffectedHosts: 2,
+		Result:        AuditResultApplied,
+	}
+
+	t.Run("entries are emitted as events", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(1)
+		sink := NewEventAuditSink(recorder, "platform", "traffic-controller-0")
+		require.NoError(t, sink.Record(context.Background(), entry))
+		assert.Equal(t, "Normal WeightApplied Weight changed from 100 to 0 (desired 0, version 3) from dynamoDB by jane", <-recorder.Events)
+	})
+
+	t.Run("entries are appended to the history table", func(t *testing.T) {
+		table := &mockAuditTable{}
+		sink := &DynamoDBAuditSink{clusterName: "lolo", tableName: "history", service: table}
+		require.NoError(t, sink.Record(context.Background(), entry))
+		require.NotNil(t, table.put)
+		assert.Equal(t, "history", aws.StringValue(table.put.TableName))
+		assert.Equal(t, "attribute_not_exists(ClusterName)", aws.StringValue(table.put.ConditionExpression))
+		assert.Equal(t, "lolo", aws.StringValue(table.put.Item["ClusterName"].S))
+		assert.Equal(t, "2024-03-01T10:00:00Z", aws.StringValue(table.put.Item["Time"].S))
+		assert.Equal(t, "jane", aws.StringValue(table.put.Item["ChangedBy"].S))
+		assert.Equal(t, "Applied", aws.StringValue(table.put.Item["Result"].S))
+		assert.NotContains(t, table.put.Item, "Error")
+	})
+
+	t.Run("entries are recorded in all the sinks", func(t *testing.T) {
+		first, second := &recordingAuditSink{}, &recordingAuditSink{}
+		require.NoError(t, AuditSinks{first, second}.Record(context.Background(), entry))
+		assert.Len(t, first.entries, 1)
+		assert.Len(t, second.entries, 1)
+	})
+}
@@ -1,12 +1,14 @@
 package trafficweight
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
@@ -19,20 +21,48 @@ type dynamodbBackend struct {
 	awsRegion   string
 	service     dynamodbiface.DynamoDBAPI
 	tableName   string
+	// backoff used to retry throttled or conflicting writes
+	backoff wait.Backoff
+	// now returns the time recorded in AppliedAt, defaults to time.Now
+	now func() time.Time
+	// dryRun never creates the row of the cluster, a missing row reads as initial
+	dryRun  bool
+	initial StoreConfig
 }
 
-func NewDynamodbBackend(logger logr.Logger, clusterName string, awsRegion string, tableName string) TrafficWeightBackend {
+var defaultWriteBackoff = wait.Backoff{
+	Steps:    5,
+	Duration: 200 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
+
+// NewDynamodbBackend returns the backend of the cluster row of the table,
+// created from the store when missing, unless in dryRun.
+func NewDynamodbBackend(ctx context.Context, logger logr.Logger, store *WeightStore, clusterName string, awsRegion string, tableName string, dryRun bool) (TrafficWeightBackend, error) {
 	logger = logger.WithValues("Backend", "dynamoDB")
-	backend := dynamodbBackend{Log: logger, clusterName: clusterName, awsRegion: awsRegion, tableName: tableName}
+	backend := dynamodbBackend{Log: logger, clusterName: clusterName, awsRegion: awsRegion, tableName: tableName, backoff: defaultWriteBackoff, dryRun: dryRun}
 	session, err := awssession.NewAwsSession(&awssession.SessionParameters{Region: backend.awsRegion, MaxRetries: 10})
 	if err != nil {
-		log.Fatalf("Error trying to create AWS session. %s", err)
+		return nil, fmt.Errorf("error trying to create AWS session: %w", err)
 	}
 
 	backend.service = dynamodb.New(session)
-	backend.initializeRowIfNotExist(Store)
+	backend.initialize(ctx, store.Get())
+
+	return &backend, nil
+}
 
-	return &backend
+// initialize creates the row of the cluster from initial when missing
+func (b *dynamodbBackend) initialize(ctx context.Context, initial StoreConfig) {
+	if b.dryRun {
+		// The row is left to the controller acknowledging the weights
+		b.initial = initial
+		return
+	}
+	if err := b.initializeRowIfNotExist(ctx, initial); err != nil {
+		b.Log.Error(err, "Unable to initialize the cluster configuration")
+	}
 }
 
 type Item struct {
@@ -40,6 +70,20 @@ type Item struct {
 	DesiredWeight int
 	CurrentWeight int
 	HealthCheckID string
+	// Force allows DesiredWeight changes exceeding the configured WeightChangeLimits
+	Force bool
+	// Version is expected to be incremented by every writer changing DesiredWeight
+	Version int
+	// AppliedVersion is the Version of the DesiredWeight acknowledged in CurrentWeight
+	AppliedVersion int
+	// AppliedAt is the RFC3339 time at which CurrentWeight was acknowledged
+	AppliedAt string
+	// ChangedBy is optionally set by the writers changing DesiredWeight to identify themselves
+	ChangedBy string `dynamodbav:",omitempty"`
+	// DrainedWeight is the DesiredWeight before the cluster was drained, set until it is restored
+	DrainedWeight *int `dynamodbav:",omitempty"`
+	// RecordTTL, when set, overrides the TTL in seconds of the DNS records of the cluster
+	RecordTTL int64 `dynamodbav:",omitempty"`
 }
 
 type DynamoNoResultsError struct {
@@ -48,8 +92,17 @@ type DynamoNoResultsError struct {
 
 func (e *DynamoNoResultsError) Error() string { return e.msg }
 
-func (b *dynamodbBackend) ReadItem() (*Item, error) {
-	result, err := b.service.GetItem(&dynamodb.GetItemInput{
+// observeRequest records the duration and the error of a DynamoDB request started at start
+func observeRequest(operation string, start time.Time, err error) {
+	backendMetrics.DynamoDBRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		backendMetrics.DynamoDBRequestErrors.WithLabelValues(operation, requestErrorType(err)).Inc()
+	}
+}
+
+func (b *dynamodbBackend) ReadItem(ctx context.Context) (*Item, error) {
+	start := time.Now()
+	result, err := b.service.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(b.tableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			"ClusterName": {
@@ -57,6 +110,7 @@ func (b *dynamodbBackend) ReadItem() (*Item, error) {
 			},
 		},
 	})
+	observeRequest("GetItem", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -74,22 +128,45 @@ func (b *dynamodbBackend) ReadItem() (*Item, error) {
 	return &item, nil
 }
 
-func (b *dynamodbBackend) ReadWeight() (int, error) {
-	item, err := b.ReadItem()
+func (b *dynamodbBackend) ReadWeight(ctx context.Context) (StoreConfig, error) {
+	item, err := b.ReadItem(ctx)
+	if _, ok := err.(*DynamoNoResultsError); ok && b.dryRun {
+		return b.initial, nil
+	}
 	if err != nil {
-		return 0, err
+		return StoreConfig{}, err
 	}
 
-	return item.DesiredWeight, nil
+	return StoreConfig{
+		DesiredWeight: item.DesiredWeight,
+		CurrentWeight: item.CurrentWeight,
+		Version:       item.Version,
+		Force:         item.Force,
+		ChangedBy:     item.ChangedBy,
+		RecordTTL:     item.RecordTTL,
+	}, nil
 }
 
-func (b *dynamodbBackend) OnWeightUpdate(store StoreConfig) error {
-	currentWeight := aws.String(fmt.Sprintf("%d", store.CurrentWeight))
-	return b.write(&dynamodb.Update{
+func (b *dynamodbBackend) OnWeightUpdate(ctx context.Context, store StoreConfig) error {
+	now := time.Now
+	if b.now != nil {
+		now = b.now
+	}
+
+	update := &dynamodb.Update{
 		TableName: aws.String(b.tableName),
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":c": {
-				N: currentWeight,
+				N: aws.String(fmt.Sprintf("%d", store.CurrentWeight)),
+			},
+			":t": {
+				S: aws.String(now().UTC().Format(time.RFC3339)),
+			},
+			":desired": {
+				N: aws.String(fmt.Sprintf("%d", store.DesiredWeight)),
+			},
+			":version": {
+				N: aws.String(fmt.Sprintf("%d", store.Version)),
 			},
 		},
 		Key: map[string]*dynamodb.AttributeValue{
@@ -97,23 +174,31 @@ func (b *dynamodbBackend) OnWeightUpdate(store StoreConfig) error {
 				S: aws.String(b.clusterName),
 			},
 		},
-		UpdateExpression: aws.String("SET CurrentWeight = :c"),
-	})
+		UpdateExpression: aws.String("SET CurrentWeight = :c, AppliedAt = :t, AppliedVersion = :version"),
+		// Only acknowledge the desired weight we read, if an operator changed it
+		// in between, the new value will be picked up on the next read.
+		ConditionExpression: aws.String("DesiredWeight = :desired AND Version = :version"),
+	}
+	if store.Version == 0 {
+		update.ConditionExpression = aws.String("DesiredWeight = :desired AND (attribute_not_exists(Version) OR Version = :version)")
+	}
+	return b.write(ctx, update, ErrStaleVersion)
 }
 
-func (b *dynamodbBackend) initializeRowIfNotExist(store StoreConfig) {
-	_, err := b.ReadWeight()
+func (b *dynamodbBackend) initializeRowIfNotExist(ctx context.Context, store StoreConfig) error {
+	_, err := b.ReadWeight(ctx)
 	if _, ok := err.(*DynamoNoResultsError); ok {
 		b.Log.Info(fmt.Sprintf("Coudn't find previous configuration. Creating it..."))
-		b.initializeClusterRow(Store)
+		return b.initializeClusterRow(ctx, store)
 	}
+	return nil
 }
 
-func (b *dynamodbBackend) initializeClusterRow(store StoreConfig) error {
+func (b *dynamodbBackend) initializeClusterRow(ctx context.Context, store StoreConfig) error {
 	desiredWeight := aws.String(fmt.Sprintf("%d", store.DesiredWeight))
 	currentWeight := aws.String(fmt.Sprintf("%d", store.CurrentWeight))
 
-	return b.write(&dynamodb.Update{
+	return b.write(ctx, &dynamodb.Update{
 		TableName: aws.String(b.tableName),
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":d": {
@@ -129,10 +214,20 @@ func (b *dynamodbBackend) initializeClusterRow(store StoreConfig) error {
 			},
 		},
 		UpdateExpression: aws.String("SET DesiredWeight = :d, CurrentWeight = :c"),
-	})
+	}, nil)
+}
+
+func (b *dynamodbBackend) writeBackoff() wait.Backoff {
+	if b.backoff.Steps < 1 {
+		// A zero backoff would never attempt the write
+		return wait.Backoff{Steps: 1}
+	}
+	return b.backoff
 }
 
-func (b *dynamodbBackend) write(update *dynamodb.Update) error {
+// write applies the update, retrying the throttled and conflicting writes.
+// A failed condition of the update is returned wrapped in conditionErr.
+func (b *dynamodbBackend) write(ctx context.Context, update *dynamodb.Update, conditionErr error) error {
 	input := &dynamodb.TransactWriteItemsInput{
 		TransactItems: []*dynamodb.TransactWriteItem{
 			&dynamodb.TransactWriteItem{
@@ -140,17 +235,101 @@ func (b *dynamodbBackend) write(update *dynamodb.Update) error {
 			},
 		},
 	}
-
-	_, err := b.service.TransactWriteItems(input)
-	if err != nil {
-		switch t := err.(type) {
-		case *dynamodb.TableAlreadyExistsException:
-			b.Log.Error(err, " There is an already on going transaction (more than one controller running?)")
-		case *dynamodb.TransactionCanceledException:
-			b.Log.Error(err, " failed to write items: %s\n%v", t.Message(), t.CancellationReasons)
-		default:
-			log.Fatalf("failed to write items: %v", err)
+	var lastErr error
+	err := wait.ExponentialBackoffWithContext(ctx, b.writeBackoff(), func(ctx context.Context) (bool, error) {
+		start := time.Now()
+		_, err := b.service.TransactWriteItemsWithContext(ctx, input)
+		observeRequest("TransactWriteItems", start, err)
+		if err != nil {
+			writeErr := newBackendWriteError(err)
+			if writeErr.Reason == WriteErrorTransactionConflict {
+				b.Log.Error(err, " There is an already on going transaction (more than one controller running?)")
+			} else {
+				b.Log.Error(err, " failed to write items", "reason", writeErr.Reason)
+			}
+			if writeErr.Retryable() {
+				lastErr = writeErr
+				return false, nil
+			}
+			if writeErr.Reason == WriteErrorConditionalCheckFailed && conditionErr != nil {
+				return false, fmt.Errorf("cluster %s: %w: %w", b.clusterName, conditionErr, writeErr)
+			}
+			return false, writeErr
 		}
+		return true, nil
+	})
+	if wait.Interrupted(err) && lastErr != nil {
+		return lastErr
 	}
 	return err
 }
+
+var _ WeightWriter = &dynamodbBackend{}
+
+func (b *dynamodbBackend) changeValues(change WeightChange) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		":zero":  {N: aws.String("0")},
+		":one":   {N: aws.String("1")},
+		":by":    {S: aws.String(change.ChangedBy)},
+		":force": {BOOL: aws.Bool(change.Force)},
+	}
+}
+
+// changeExpression is the update shared by all the DesiredWeight changes
+const changeExpression = "Version = if_not_exists(Version, :zero) + :one, ChangedBy = :by, Force = :force"
+
+func (b *dynamodbBackend) key() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"ClusterName": {S: aws.String(b.clusterName)},
+	}
+}
+
+func (b *dynamodbBackend) SetDesiredWeight(ctx context.Context, weight int, change WeightChange) error {
+	if err := ValidateWeight(weight); err != nil {
+		return err
+	}
+	values := b.changeValues(change)
+	values[":d"] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", weight))}
+	return b.write(ctx, &dynamodb.Update{
+		TableName:                 aws.String(b.tableName),
+		Key:                       b.key(),
+		ExpressionAttributeValues: values,
+		UpdateExpression:          aws.String("SET DesiredWeight = :d, " + changeExpression + " REMOVE DrainedWeight"),
+		ConditionExpression:       aws.String("attribute_exists(ClusterName)"),
+	}, ErrClusterNotFound)
+}
+
+func (b *dynamodbBackend) Drain(ctx context.Context, change WeightChange) error {
+	values := b.changeValues(change)
+	values[":d"] = &dynamodb.AttributeValue{N: aws.String("0")}
+	return b.write(ctx, &dynamodb.Update{
+		TableName:                 aws.String(b.tableName),
+		Key:                       b.key(),
+		ExpressionAttributeValues: values,
+		UpdateExpression:          aws.String("SET DrainedWeight = if_not_exists(DrainedWeight, DesiredWeight), DesiredWeight = :d, " + changeExpression),
+		ConditionExpression:       aws.String("attribute_exists(ClusterName)"),
+	}, ErrClusterNotFound)
+}
+
+func (b *dynamodbBackend) Restore(ctx context.Context, change WeightChange) error {
+	item, err := b.ReadItem(ctx)
+	if _, ok := err.(*DynamoNoResultsError); ok {
+		return fmt.Errorf("cluster %s: %w", b.clusterName, ErrClusterNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if item.DrainedWeight == nil {
+		return ErrNotDrained
+	}
+	values := b.changeValues(change)
+	values[":d"] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", *item.DrainedWeight))}
+	return b.write(ctx, &dynamodb.Update{
+		TableName:                 aws.String(b.tableName),
+		Key:                       b.key(),
+		ExpressionAttributeValues: values,
+		UpdateExpression:          aws.String("SET DesiredWeight = :d, " + changeExpression + " REMOVE DrainedWeight"),
+		// Do not restore twice if someone else restored it in between
+		ConditionExpression: aws.String("attribute_exists(DrainedWeight)"),
+	}, ErrNotDrained)
+}
@@ -1,13 +1,18 @@
 package trafficweight
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 
 	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
@@ -17,33 +22,74 @@ type mockDynamoDBClient struct {
 	written       *dynamodb.TransactWriteItemsInput
 	currentWeight *string
 	desiredWeight *string
+	version       *string
+	drainedWeight *string
+	recordTTL     *string
+	force         bool
+	// writeErrs are returned, in order, by the next calls to TransactWriteItems
+	writeErrs []error
+	writes    int
 }
 
-func (m *mockDynamoDBClient) GetItem(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+func (m *mockDynamoDBClient) GetItemWithContext(aws.Context, *dynamodb.GetItemInput, ...request.Option) (*dynamodb.GetItemOutput, error) {
 	if m.written == nil {
 		return &dynamodb.GetItemOutput{
 			Item: map[string]*dynamodb.AttributeValue{},
 		}, nil
 	}
-	return &dynamodb.GetItemOutput{
-		Item: map[string]*dynamodb.AttributeValue{
-			"ClusterName": &dynamodb.AttributeValue{
-				S: aws.String("lolo"),
-			},
-			"DesiredWeight": &dynamodb.AttributeValue{
-				N: m.desiredWeight,
-			},
-			"CurrentWeight": &dynamodb.AttributeValue{
-				N: m.currentWeight,
-			},
-			"foo": &dynamodb.AttributeValue{
-				N: m.currentWeight,
-			},
+	item := map[string]*dynamodb.AttributeValue{
+		"ClusterName": &dynamodb.AttributeValue{
+			S: aws.String("lolo"),
+		},
+		"DesiredWeight": &dynamodb.AttributeValue{
+			N: m.desiredWeight,
 		},
+		"CurrentWeight": &dynamodb.AttributeValue{
+			N: m.currentWeight,
+		},
+		"foo": &dynamodb.AttributeValue{
+			N: m.currentWeight,
+		},
+		"Force": &dynamodb.AttributeValue{
+			BOOL: aws.Bool(m.force),
+		},
+	}
+	if m.version != nil {
+		item["Version"] = &dynamodb.AttributeValue{N: m.version}
+	}
+	if m.drainedWeight != nil {
+		item["DrainedWeight"] = &dynamodb.AttributeValue{N: m.drainedWeight}
+	}
+	if m.recordTTL != nil {
+		item["RecordTTL"] = &dynamodb.AttributeValue{N: m.recordTTL}
+	}
+	return &dynamodb.GetItemOutput{
+		Item: item,
 	}, nil
 }
 
-func (m *mockDynamoDBClient) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+func (m *mockDynamoDBClient) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	m.writes++
+	if len(m.writeErrs) > 0 {
+		err := m.writeErrs[0]
+		m.writeErrs = m.writeErrs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Only the weight acknowledgements are conditioned to the values read
+	if _, acknowledgement := input.TransactItems[0].Update.ExpressionAttributeValues[":desired"]; acknowledgement {
+		values := input.TransactItems[0].Update.ExpressionAttributeValues
+		version := aws.StringValue(m.version)
+		if version == "" {
+			version = "0"
+		}
+		if *values[":desired"].N != aws.StringValue(m.desiredWeight) || *values[":version"].N != version {
+			return nil, &dynamodb.TransactionCanceledException{
+				CancellationReasons: []*dynamodb.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}},
+			}
+		}
+	}
 	m.written = input
 	if v, found := input.TransactItems[0].Update.ExpressionAttributeValues[":c"]; found {
 		m.currentWeight = v.N
@@ -55,20 +101,68 @@ func (m *mockDynamoDBClient) TransactWriteItems(input *dynamodb.TransactWriteIte
 }
 
 func TestOnWeightUpdate(t *testing.T) {
-	mockSvc := &mockDynamoDBClient{}
+	mockSvc := &mockDynamoDBClient{
+		desiredWeight: aws.String("10"),
+	}
 
 	dynamoBackend := dynamodbBackend{
 		service: mockSvc,
+		now:     func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) },
 	}
-	Store.DesiredWeight = 10
 
-	assert.Equal(t, dynamoBackend.OnWeightUpdate(StoreConfig{CurrentWeight: 35, DesiredWeight: Store.DesiredWeight}), nil)
+	assert.Equal(t, dynamoBackend.OnWeightUpdate(context.Background(), StoreConfig{CurrentWeight: 35, DesiredWeight: 10}), nil)
 	assert.Equal(t, mockSvc.written.TransactItems[0].Update.ExpressionAttributeValues, map[string]*dynamodb.AttributeValue{
 		":c": {
 			N: aws.String("35"),
 		},
+		":t": {
+			S: aws.String("2020-01-02T03:04:05Z"),
+		},
+		":desired": {
+			N: aws.String("10"),
+		},
+		":version": {
+			N: aws.String("0"),
+		},
+	})
+	assert.Equal(t, *mockSvc.written.TransactItems[0].Update.UpdateExpression, "SET CurrentWeight = :c, AppliedAt = :t, AppliedVersion = :version")
+	assert.Equal(t, *mockSvc.written.TransactItems[0].Update.ConditionExpression, "DesiredWeight = :desired AND (attribute_not_exists(Version) OR Version = :version)")
+}
+
+func TestOnWeightUpdateAcknowledgesTheObservedVersion(t *testing.T) {
+	mockSvc := &mockDynamoDBClient{
+		written:       &dynamodb.TransactWriteItemsInput{},
+		desiredWeight: aws.String("50"),
+		currentWeight: aws.String("100"),
+		version:       aws.String("7"),
+	}
+
+	dynamoBackend := dynamodbBackend{
+		service: mockSvc,
+		now:     func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) },
+	}
+
+	observed, err := dynamoBackend.ReadWeight(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, StoreConfig{DesiredWeight: 50, CurrentWeight: 100, Version: 7}, observed)
+
+	observed.CurrentWeight = 50
+	assert.NoError(t, dynamoBackend.OnWeightUpdate(context.Background(), observed))
+	update := mockSvc.written.TransactItems[0].Update
+	assert.Equal(t, "DesiredWeight = :desired AND Version = :version", *update.ConditionExpression)
+	assert.Equal(t, "7", *update.ExpressionAttributeValues[":version"].N)
+	assert.Equal(t, "50", *update.ExpressionAttributeValues[":desired"].N)
+	assert.Equal(t, "50", *mockSvc.currentWeight)
+
+	t.Run("a desired weight changed after the read is not acknowledged", func(t *testing.T) {
+		mockSvc.desiredWeight = aws.String("0")
+		mockSvc.version = aws.String("8")
+		err := dynamoBackend.OnWeightUpdate(context.Background(), StoreConfig{CurrentWeight: 20, DesiredWeight: 50, Version: 7})
+		var writeErr *BackendWriteError
+		assert.ErrorAs(t, err, &writeErr)
+		assert.Equal(t, WriteErrorConditionalCheckFailed, writeErr.Reason)
+		assert.Equal(t, "50", *mockSvc.currentWeight)
 	})
-	assert.Equal(t, *mockSvc.written.TransactItems[0].Update.UpdateExpression, "SET CurrentWeight = :c")
 }
 
 func TestInitializeClusterRow(t *testing.T) {
@@ -77,9 +171,8 @@ func TestInitializeClusterRow(t *testing.T) {
 	dynamoBackend := dynamodbBackend{
 		service: mockSvc,
 	}
-	Store.DesiredWeight = 10
 
-	assert.Equal(t, dynamoBackend.initializeClusterRow(StoreConfig{CurrentWeight: 35, DesiredWeight: Store.DesiredWeight}), nil)
+	assert.Equal(t, dynamoBackend.initializeClusterRow(context.Background(), StoreConfig{CurrentWeight: 35, DesiredWeight: 10}), nil)
 	assert.Equal(t, mockSvc.written.TransactItems[0].Update.ExpressionAttributeValues, map[string]*dynamodb.AttributeValue{
 		":d": {
 			N: aws.String("10"),
@@ -90,8 +183,8 @@ func TestInitializeClusterRow(t *testing.T) {
 	})
 	assert.Equal(t, *mockSvc.written.TransactItems[0].Update.UpdateExpression, "SET DesiredWeight = :d, CurrentWeight = :c")
 
-	w, e := dynamoBackend.ReadWeight()
-	assert.Equal(t, Store.DesiredWeight, w)
+	w, e := dynamoBackend.ReadWeight(context.Background())
+	assert.Equal(t, 10, w.DesiredWeight)
 	assert.Nil(t, e)
 }
 
@@ -103,19 +196,246 @@ func TestInitializeRowIfNotExists(t *testing.T) {
 		Log:     zap.New(zap.UseDevMode(true)),
 	}
 
-	w, e := dynamoBackend.ReadWeight()
-	assert.Equal(t, 0, w)
+	w, e := dynamoBackend.ReadWeight(context.Background())
+	assert.Equal(t, 0, w.DesiredWeight)
 	assert.NotNil(t, e)
 
-	Store.DesiredWeight = 50
-	dynamoBackend.initializeRowIfNotExist(StoreConfig{CurrentWeight: 35, DesiredWeight: Store.DesiredWeight})
-	w, e = dynamoBackend.ReadWeight()
-	assert.Equal(t, Store.DesiredWeight, w)
+	dynamoBackend.initializeRowIfNotExist(context.Background(), StoreConfig{CurrentWeight: 35, DesiredWeight: 50})
+	w, e = dynamoBackend.ReadWeight(context.Background())
+	assert.Equal(t, 50, w.DesiredWeight)
 	assert.Nil(t, e)
 
-	Store.DesiredWeight = 100
-	dynamoBackend.initializeRowIfNotExist(StoreConfig{CurrentWeight: 35, DesiredWeight: Store.DesiredWeight})
-	w, e = dynamoBackend.ReadWeight()
-	assert.Equal(t, 50, w)
+	dynamoBackend.initializeRowIfNotExist(context.Background(), StoreConfig{CurrentWeight: 35, DesiredWeight: 100})
+	w, e = dynamoBackend.ReadWeight(context.Background())
+	assert.Equal(t, 50, w.DesiredWeight)
 	assert.Nil(t, e)
 }
+
+func TestInitializeInDryRun(t *testing.T) {
+	mockSvc := &mockDynamoDBClient{}
+
+	dynamoBackend := dynamodbBackend{
+		service: mockSvc,
+		Log:     zap.New(zap.UseDevMode(true)),
+		dryRun:  true,
+	}
+
+	dynamoBackend.initialize(context.Background(), StoreConfig{CurrentWeight: 35, DesiredWeight: 50})
+	assert.Equal(t, 0, mockSvc.writes, "the row is not created")
+
+	w, e := dynamoBackend.ReadWeight(context.Background())
+	assert.NoError(t, e)
+	assert.Equal(t, StoreConfig{CurrentWeight: 35, DesiredWeight: 50}, w, "the missing row reads as the initial configuration")
+
+	mockSvc.written = &dynamodb.TransactWriteItemsInput{}
+	mockSvc.desiredWeight, mockSvc.currentWeight = aws.String("20"), aws.String("20")
+	w, e = dynamoBackend.ReadWeight(context.Background())
+	assert.NoError(t, e)
+	assert.Equal(t, 20, w.DesiredWeight, "the existing row is read")
+}
+
+func TestReadWeightReturnsTheForceFlag(t *testing.T) {
+	mockSvc := &mockDynamoDBClient{}
+	dynamoBackend := dynamodbBackend{
+		service: mockSvc,
+	}
+
+	_, e := dynamoBackend.ReadWeight(context.Background())
+	assert.NotNil(t, e)
+
+	assert.Nil(t, dynamoBackend.initializeClusterRow(context.Background(), StoreConfig{CurrentWeight: 100, DesiredWeight: 0}))
+	store, e := dynamoBackend.ReadWeight(context.Background())
+	assert.Nil(t, e)
+	assert.False(t, store.Force)
+
+	mockSvc.force = true
+	store, e = dynamoBackend.ReadWeight(context.Background())
+	assert.Nil(t, e)
+	assert.True(t, store.Force)
+}
+
+func TestReadWeightReturnsTheRecordTTL(t *testing.T) {
+	mockSvc := &mockDynamoDBClient{}
+	dynamoBackend := dynamodbBackend{
+		service: mockSvc,
+	}
+	assert.Nil(t, dynamoBackend.initializeClusterRow(context.Background(), StoreConfig{CurrentWeight: 100, DesiredWeight: 100}))
+
+	store, e := dynamoBackend.ReadWeight(context.Background())
+	assert.Nil(t, e)
+	assert.Equal(t, int64(0), store.RecordTTL)
+
+	mockSvc.recordTTL = aws.String("30")
+	store, e = dynamoBackend.ReadWeight(context.Background())
+	assert.Nil(t, e)
+	assert.Equal(t, int64(30), store.RecordTTL)
+}
+
+func TestWriteErrors(t *testing.T) {
+	backoff := wait.Backoff{Steps: 3, Duration: time.Millisecond}
+
+	t.Run("throttled writes are retried", func(t *testing.T) {
+		mockSvc := &mockDynamoDBClient{
+			desiredWeight: aws.String("0"),
+			writeErrs: []error{
+				awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "slow down", nil),
+				awserr.New(dynamodb.ErrCodeRequestLimitExceeded, "slow down", nil),
+			},
+		}
+		dynamoBackend := dynamodbBackend{
+			service: mockSvc,
+			backoff: backoff,
+			Log:     zap.New(zap.UseDevMode(true)),
+		}
+		assert.NoError(t, dynamoBackend.OnWeightUpdate(context.Background(), StoreConfig{CurrentWeight: 35}))
+		assert.Equal(t, 3, mockSvc.writes)
+		assert.Equal(t, "35", *mockSvc.currentWeight)
+	})
+
+	t.Run("transaction conflicts are retried and returned once exhausted", func(t *testing.T) {
+		conflict := &dynamodb.TransactionCanceledException{
+			CancellationReasons: []*dynamodb.CancellationReason{{Code: aws.String("TransactionConflict")}},
+		}
+		mockSvc := &mockDynamoDBClient{
+			writeErrs: []error{conflict, conflict, conflict},
+		}
+		dynamoBackend := dynamodbBackend{
+			service: mockSvc,
+			backoff: backoff,
+			Log:     zap.New(zap.UseDevMode(true)),
+		}
+		err := dynamoBackend.OnWeightUpdate(context.Background(), StoreConfig{CurrentWeight: 35})
+		var writeErr *BackendWriteError
+		assert.ErrorAs(t, err, &writeErr)
+		assert.Equal(t, WriteErrorTransactionConflict, writeErr.Reason)
+		assert.Equal(t, 3, mockSvc.writes)
+		assert.Nil(t, mockSvc.written)
+	})
+
+	t.Run("conditional check failures are not retried and tell what failed", func(t *testing.T) {
+		canceled := &dynamodb.TransactionCanceledException{
+			CancellationReasons: []*dynamodb.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}},
+		}
+		for name, tc := range map[string]struct {
+			write    func(dynamodbBackend) error
+			expected error
+		}{
+			"stale acknowledgement": {
+				write: func(b dynamodbBackend) error {
+					return b.OnWeightUpdate(context.Background(), StoreConfig{CurrentWeight: 35})
+				},
+				expected: ErrStaleVersion,
+			},
+			"unknown cluster": {
+				write:    func(b dynamodbBackend) error { return b.SetDesiredWeight(context.Background(), 35, WeightChange{}) },
+				expected: ErrClusterNotFound,
+			},
+			"drained unknown cluster": {
+				write:    func(b dynamodbBackend) error { return b.Drain(context.Background(), WeightChange{}) },
+				expected: ErrClusterNotFound,
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				mockSvc := &mockDynamoDBClient{
+					writeErrs: []error{canceled, canceled, canceled},
+				}
+				dynamoBackend := dynamodbBackend{
+					service: mockSvc,
+					backoff: backoff,
+					Log:     zap.New(zap.UseDevMode(true)),
+				}
+				err := tc.write(dynamoBackend)
+				assert.ErrorIs(t, err, tc.expected)
+				var writeErr *BackendWriteError
+				assert.ErrorAs(t, err, &writeErr)
+				assert.Equal(t, WriteErrorConditionalCheckFailed, writeErr.Reason)
+				assert.Equal(t, 1, mockSvc.writes)
+			})
+		}
+	})
+
+	t.Run("restoring an unknown cluster tells it is not found", func(t *testing.T) {
+		dynamoBackend := dynamodbBackend{
+			service: &mockDynamoDBClient{},
+			Log:     zap.New(zap.UseDevMode(true)),
+		}
+		assert.ErrorIs(t, dynamoBackend.Restore(context.Background(), WeightChange{}), ErrClusterNotFound)
+	})
+
+	t.Run("unknown errors are returned without retrying", func(t *testing.T) {
+		mockSvc := &mockDynamoDBClient{
+			writeErrs: []error{assert.AnError},
+		}
+		dynamoBackend := dynamodbBackend{
+			service: mockSvc,
+			backoff: backoff,
+			Log:     zap.New(zap.UseDevMode(true)),
+		}
+		err := dynamoBackend.OnWeightUpdate(context.Background(), StoreConfig{CurrentWeight: 35})
+		var writeErr *BackendWriteError
+		assert.ErrorAs(t, err, &writeErr)
+		assert.Equal(t, WriteErrorUnknown, writeErr.Reason)
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Equal(t, 1, mockSvc.writes)
+	})
+}
+
+func TestDynamoDBWeightWriter(t *testing.T) {
+	newBackend := func(mockSvc *mockDynamoDBClient) dynamodbBackend {
+		return dynamodbBackend{
+			service:     mockSvc,
+			clusterName: "lolo",
+			tableName:   "traffic-controller",
+			Log:         zap.New(zap.UseDevMode(true)),
+		}
+	}
+
+	t.Run("desired weights are set with a new version", func(t *testing.T) {
+		mockSvc := &mockDynamoDBClient{}
+		backend := newBackend(mockSvc)
+		assert.NoError(t, backend.SetDesiredWeight(context.Background(), 30, WeightChange{ChangedBy: "jane", Force: true}))
+		update := mockSvc.written.TransactItems[0].Update
+		assert.Equal(t, "SET DesiredWeight = :d, Version = if_not_exists(Version, :zero) + :one, ChangedBy = :by, Force = :force REMOVE DrainedWeight", *update.UpdateExpression)
+		assert.Equal(t, "attribute_exists(ClusterName)", *update.ConditionExpression)
+		assert.Equal(t, "30", *update.ExpressionAttributeValues[":d"].N)
+		assert.Equal(t, "jane", *update.ExpressionAttributeValues[":by"].S)
+		assert.True(t, *update.ExpressionAttributeValues[":force"].BOOL)
+		assert.Equal(t, "lolo", *update.Key["ClusterName"].S)
+	})
+
+	t.Run("invalid weights are rejected", func(t *testing.T) {
+		mockSvc := &mockDynamoDBClient{}
+		backend := newBackend(mockSvc)
+		assert.Error(t, backend.SetDesiredWeight(context.Background(), 101, WeightChange{}))
+		assert.Error(t, backend.SetDesiredWeight(context.Background(), -1, WeightChange{}))
+		assert.Equal(t, 0, mockSvc.writes)
+	})
+
+	t.Run("drains remember the previous weight", func(t *testing.T) {
+		mockSvc := &mockDynamoDBClient{}
+		backend := newBackend(mockSvc)
+		assert.NoError(t, backend.Drain(context.Background(), WeightChange{}))
+		update := mockSvc.written.TransactItems[0].Update
+		assert.Equal(t, "SET DrainedWeight = if_not_exists(DrainedWeight, DesiredWeight), DesiredWeight = :d, Version = if_not_exists(Version, :zero) + :one, ChangedBy = :by, Force = :force", *update.UpdateExpression)
+		assert.Equal(t, "0", *update.ExpressionAttributeValues[":d"].N)
+	})
+
+	t.Run("restores set the weight before the drain", func(t *testing.T) {
+		mockSvc := &mockDynamoDBClient{desiredWeight: aws.String("0"), currentWeight: aws.String("0"), drainedWeight: aws.String("70")}
+		// The mock only returns the item once written
+		mockSvc.written = &dynamodb.TransactWriteItemsInput{}
+		backend := newBackend(mockSvc)
+		assert.NoError(t, backend.Restore(context.Background(), WeightChange{ChangedBy: "jane"}))
+		update := mockSvc.written.TransactItems[0].Update
+		assert.Equal(t, "70", *update.ExpressionAttributeValues[":d"].N)
+		assert.Equal(t, "attribute_exists(DrainedWeight)", *update.ConditionExpression)
+	})
+
+	t.Run("clusters not drained are not restored", func(t *testing.T) {
+		mockSvc := &mockDynamoDBClient{desiredWeight: aws.String("50"), currentWeight: aws.String("50")}
+		mockSvc.written = &dynamodb.TransactWriteItemsInput{}
+		backend := newBackend(mockSvc)
+		assert.ErrorIs(t, backend.Restore(context.Background(), WeightChange{}), ErrNotDrained)
+		assert.Equal(t, 0, mockSvc.writes)
+	})
+}
@@ -0,0 +1,90 @@
+package trafficweight
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type WriteErrorReason string
+
+const (
+	WriteErrorThrottled              WriteErrorReason = "Throttled"
+	WriteErrorConditionalCheckFailed WriteErrorReason = "ConditionalCheckFailed"
+	WriteErrorTransactionConflict    WriteErrorReason = "TransactionConflict"
+	WriteErrorUnknown                WriteErrorReason = "Unknown"
+)
+
+// BackendWriteError is returned when the weight backend fails to persist a change
+type BackendWriteError struct {
+	Reason WriteErrorReason
+	err    error
+}
+
+func (e *BackendWriteError) Error() string {
+	return fmt.Sprintf("failed to write items (%s): %v", e.Reason, e.err)
+}
+
+func (e *BackendWriteError) Unwrap() error { return e.err }
+
+// Retryable reports whether retrying the same write may succeed. Failed
+// conditions are not: they fail again until the item is changed.
+func (e *BackendWriteError) Retryable() bool {
+	switch e.Reason {
+	case WriteErrorThrottled, WriteErrorTransactionConflict:
+		return true
+	default:
+		return false
+	}
+}
+
+func newBackendWriteError(err error) *BackendWriteError {
+	if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		return &BackendWriteError{Reason: cancellationReason(canceled.CancellationReasons), err: err}
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		switch awsErr.Code() {
+		case dynamodb.ErrCodeProvisionedThroughputExceededException,
+			dynamodb.ErrCodeRequestLimitExceeded,
+			dynamodb.ErrCodeTransactionInProgressException,
+			"ThrottlingException":
+			return &BackendWriteError{Reason: WriteErrorThrottled, err: err}
+		case dynamodb.ErrCodeConditionalCheckFailedException:
+			return &BackendWriteError{Reason: WriteErrorConditionalCheckFailed, err: err}
+		case dynamodb.ErrCodeTransactionConflictException:
+			return &BackendWriteError{Reason: WriteErrorTransactionConflict, err: err}
+		}
+	}
+	return &BackendWriteError{Reason: WriteErrorUnknown, err: err}
+}
+
+// requestErrorType classifies the errors returned by DynamoDB for the metrics
+func requestErrorType(err error) string {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == request.CanceledErrorCode {
+		return "Canceled"
+	}
+	return string(newBackendWriteError(err).Reason)
+}
+
+func cancellationReason(reasons []*dynamodb.CancellationReason) WriteErrorReason {
+	for _, reason := range reasons {
+		if reason == nil {
+			continue
+		}
+		switch aws.StringValue(reason.Code) {
+		case "ConditionalCheckFailed":
+			return WriteErrorConditionalCheckFailed
+		case "TransactionConflict":
+			return WriteErrorTransactionConflict
+		case "ThrottlingError", "ProvisionedThroughputExceeded", "RequestLimitExceeded":
+			return WriteErrorThrottled
+		}
+	}
+	return WriteErrorUnknown
+}
@@ -0,0 +1,68 @@
+package trafficweight
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/go-logr/logr"
+
+	awssession "github.com/adevinta/k8s-traffic-controller/pkg/aws"
+)
+
+// DynamoDBTable gives access to the weights of all the clusters sharing a DynamoDB table
+type DynamoDBTable struct {
+	Log       logr.Logger
+	tableName string
+	service   dynamodbiface.DynamoDBAPI
+}
+
+func NewDynamoDBTable(logger logr.Logger, awsRegion, tableName string) (*DynamoDBTable, error) {
+	session, err := awssession.NewAwsSession(&awssession.SessionParameters{Region: awsRegion, MaxRetries: 10})
+	if err != nil {
+		return nil, fmt.Errorf("error trying to create AWS session: %w", err)
+	}
+	return &DynamoDBTable{Log: logger, tableName: tableName, service: dynamodb.New(session)}, nil
+}
+
+// Clusters returns the entries of all the clusters, sorted by name
+func (t *DynamoDBTable) Clusters(ctx context.Context) ([]Item, error) {
+	items := []Item{}
+	input := &dynamodb.ScanInput{TableName: aws.String(t.tableName)}
+	for {
+		start := time.Now()
+		output, err := t.service.ScanWithContext(ctx, input)
+		observeRequest("Scan", start, err)
+		if err != nil {
+			return nil, err
+		}
+		page := []Item{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(output.Items, &page); err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ClusterName < items[j].ClusterName })
+	return items, nil
+}
+
+// Cluster returns the backend of an existing cluster entry.
+// Unlike NewDynamodbBackend, the entry is never created.
+func (t *DynamoDBTable) Cluster(clusterName string) ClusterBackend {
+	return &dynamodbBackend{
+		Log:         t.Log.WithValues("Backend", "dynamoDB", "Cluster", clusterName),
+		clusterName: clusterName,
+		tableName:   t.tableName,
+		service:     t.service,
+		backoff:     defaultWriteBackoff,
+	}
+}
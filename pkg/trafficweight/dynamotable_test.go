@@ -0,0 +1,58 @@
+package trafficweight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockScanClient struct {
+	dynamodbiface.DynamoDBAPI
+	pages [][]map[string]*dynamodb.AttributeValue
+	scans int
+}
+
+func clusterItem(name, desired, current string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"ClusterName":   {S: aws.String(name)},
+		"DesiredWeight": {N: aws.String(desired)},
+		"CurrentWeight": {N: aws.String(current)},
+	}
+}
+
+func (m *mockScanClient) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	page := m.scans
+	m.scans++
+	output := &dynamodb.ScanOutput{Items: m.pages[page]}
+	if page+1 < len(m.pages) {
+		output.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{"ClusterName": {S: aws.String("next")}}
+	}
+	return output, nil
+}
+
+func TestDynamoDBTable(t *testing.T) {
+	mockSvc := &mockScanClient{pages: [][]map[string]*dynamodb.AttributeValue{
+		{clusterItem("prod02", "50", "40")},
+		{clusterItem("prod01", "100", "100")},
+	}}
+	table := &DynamoDBTable{tableName: "traffic-controller", service: mockSvc, Log: testLogger}
+
+	clusters, err := table.Clusters(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, mockSvc.scans)
+	require.Len(t, clusters, 2)
+	assert.Equal(t, "prod01", clusters[0].ClusterName)
+	assert.Equal(t, 100, clusters[0].DesiredWeight)
+	assert.Equal(t, "prod02", clusters[1].ClusterName)
+	assert.Equal(t, 40, clusters[1].CurrentWeight)
+
+	backend := table.Cluster("prod01").(*dynamodbBackend)
+	assert.Equal(t, "prod01", backend.clusterName)
+	assert.Equal(t, "traffic-controller", backend.tableName)
+}
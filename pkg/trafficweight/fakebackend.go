@@ -1,20 +1,85 @@
 package trafficweight
 
-import "github.com/go-logr/logr"
+import (
+	"context"
+	"sync"
 
+	"github.com/go-logr/logr"
+)
+
+// FakeBackend keeps the weight configuration in memory. As with the other
+// backends, the weight changes are only applied by the ConfigReconciler
+// reading them, through the WeightChangeLimits and the audit.
 type FakeBackend struct {
 	Log logr.Logger
+
+	mu sync.Mutex
+	// config is returned by ReadWeight, initially the configuration of the store
+	config StoreConfig
+	// drained holds the weight before Drain
+	drained *int
 }
 
-func NewFakeBackend(logger logr.Logger) TrafficWeightBackend {
-	backend := FakeBackend{Log: logger}
+func NewFakeBackend(logger logr.Logger, store *WeightStore) TrafficWeightBackend {
+	backend := FakeBackend{Log: logger, config: store.Get()}
 	return &backend
 }
 
-func (b *FakeBackend) ReadWeight() (int, error) {
-	return Store.DesiredWeight, nil
+func (b *FakeBackend) ReadWeight(ctx context.Context) (StoreConfig, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.config, nil
+}
+
+func (b *FakeBackend) OnWeightUpdate(ctx context.Context, config StoreConfig) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Only acknowledge the desired weight read, as the DynamoDB backend
+	if config.DesiredWeight != b.config.DesiredWeight || config.Version != b.config.Version {
+		return &BackendWriteError{Reason: WriteErrorConditionalCheckFailed, err: ErrStaleVersion}
+	}
+	b.config.CurrentWeight = config.CurrentWeight
+	return nil
+}
+
+var _ WeightWriter = &FakeBackend{}
+
+func (b *FakeBackend) setDesiredWeight(weight int, change WeightChange) {
+	b.config.DesiredWeight = weight
+	b.config.Version++
+	b.config.ChangedBy = change.ChangedBy
+	b.config.Force = change.Force
+}
+
+func (b *FakeBackend) SetDesiredWeight(ctx context.Context, weight int, change WeightChange) error {
+	if err := ValidateWeight(weight); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drained = nil
+	b.setDesiredWeight(weight, change)
+	return nil
+}
+
+func (b *FakeBackend) Drain(ctx context.Context, change WeightChange) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.drained == nil {
+		weight := b.config.DesiredWeight
+		b.drained = &weight
+	}
+	b.setDesiredWeight(0, change)
+	return nil
 }
 
-func (b *FakeBackend) OnWeightUpdate(config StoreConfig) error {
+func (b *FakeBackend) Restore(ctx context.Context, change WeightChange) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.drained == nil {
+		return ErrNotDrained
+	}
+	b.setDesiredWeight(*b.drained, change)
+	b.drained = nil
 	return nil
 }
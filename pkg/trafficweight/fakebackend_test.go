@@ -1,21 +1,99 @@
 package trafficweight
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
 func TestNewCliBackend(t *testing.T) {
 
-	fakeBackend := NewFakeBackend(zap.New(zap.UseDevMode(true)))
+	store := NewWeightStore(StoreConfig{DesiredWeight: 20, CurrentWeight: 20})
+	fakeBackend := NewFakeBackend(zap.New(zap.UseDevMode(true)), store)
 
-	Store.DesiredWeight = 200
+	store.Update(func(config *StoreConfig) {
+		config.DesiredWeight = 200
+	})
 
-	w, e := fakeBackend.ReadWeight()
+	w, e := fakeBackend.ReadWeight(context.Background())
 
-	assert.Equal(t, w, 200)
+	assert.Equal(t, w.DesiredWeight, 20, "the backend starts from the configuration of the store")
 	assert.Nil(t, e)
-	assert.Equal(t, fakeBackend.OnWeightUpdate(StoreConfig{}), nil)
+	assert.Equal(t, fakeBackend.OnWeightUpdate(context.Background(), StoreConfig{DesiredWeight: 20, CurrentWeight: 20}), nil)
+	assert.ErrorIs(t, fakeBackend.OnWeightUpdate(context.Background(), StoreConfig{DesiredWeight: 10, CurrentWeight: 10}), ErrStaleVersion)
+}
+
+func TestFakeBackendWeightWriter(t *testing.T) {
+	store := NewWeightStore(StoreConfig{DesiredWeight: 70, CurrentWeight: 70})
+	fakeBackend := NewFakeBackend(zap.New(zap.UseDevMode(true)), store).(*FakeBackend)
+	read := func() StoreConfig {
+		config, err := fakeBackend.ReadWeight(context.Background())
+		require.NoError(t, err)
+		return config
+	}
+
+	assert.ErrorIs(t, fakeBackend.Restore(context.Background(), WeightChange{}), ErrNotDrained)
+
+	assert.NoError(t, fakeBackend.Drain(context.Background(), WeightChange{ChangedBy: "jane"}))
+	assert.NoError(t, fakeBackend.Drain(context.Background(), WeightChange{ChangedBy: "jane"}))
+	assert.Equal(t, 0, read().DesiredWeight)
+	assert.Equal(t, "jane", read().ChangedBy)
+	assert.Equal(t, 2, read().Version)
+	assert.Equal(t, 70, store.Get().DesiredWeight, "the change is applied by the ConfigReconciler")
+
+	assert.NoError(t, fakeBackend.Restore(context.Background(), WeightChange{}))
+	assert.Equal(t, 70, read().DesiredWeight)
+	assert.ErrorIs(t, fakeBackend.Restore(context.Background(), WeightChange{}), ErrNotDrained)
+
+	assert.Error(t, fakeBackend.SetDesiredWeight(context.Background(), 200, WeightChange{}))
+	assert.NoError(t, fakeBackend.SetDesiredWeight(context.Background(), 20, WeightChange{}))
+	assert.Equal(t, 20, read().DesiredWeight)
+}
+
+func TestFakeBackendChangesAreReconciled(t *testing.T) {
+	events := make(chan event.GenericEvent, 10)
+	cache := &fakeCache{ing: &netv1.IngressList{}}
+	newReconciler := func(limits WeightChangeLimits) (*ConfigReconciler, *FakeBackend, *recordingAuditSink) {
+		store := NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100})
+		backend := NewFakeBackend(testLogger, store).(*FakeBackend)
+		sink := &recordingAuditSink{}
+		return &ConfigReconciler{Backend: backend, Store: store, Cache: cache, Events: events, Limits: limits, Audit: sink, Log: testLogger}, backend, sink
+	}
+
+	t.Run("changes are limited and audited", func(t *testing.T) {
+		reconciler, backend, sink := newReconciler(WeightChangeLimits{MaxDeltaPerInterval: 20})
+		require.NoError(t, backend.Drain(context.Background(), WeightChange{ChangedBy: "jane"}))
+
+		require.NoError(t, reconciler.doReconcile(context.Background()))
+		assert.Equal(t, 80, reconciler.Store.Get().CurrentWeight)
+		require.Len(t, sink.entries, 1)
+		assert.Equal(t, AuditResultLimited, sink.entries[0].Result)
+		assert.Equal(t, "jane", sink.entries[0].ChangedBy)
+		config, err := backend.ReadWeight(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 80, config.CurrentWeight, "the applied weight is acknowledged")
+
+		require.NoError(t, reconciler.doReconcile(context.Background()))
+		assert.Equal(t, 60, reconciler.Store.Get().CurrentWeight)
+	})
+
+	t.Run("changes exceeding the limits are rejected unless forced", func(t *testing.T) {
+		reconciler, backend, sink := newReconciler(WeightChangeLimits{MaxDeltaPerChange: 50})
+		require.NoError(t, backend.SetDesiredWeight(context.Background(), 0, WeightChange{}))
+
+		assert.Error(t, reconciler.doReconcile(context.Background()))
+		assert.Equal(t, 100, reconciler.Store.Get().CurrentWeight)
+		require.Len(t, sink.entries, 1)
+		assert.Equal(t, AuditResultRejected, sink.entries[0].Result)
+
+		require.NoError(t, backend.SetDesiredWeight(context.Background(), 0, WeightChange{Force: true}))
+		require.NoError(t, reconciler.doReconcile(context.Background()))
+		assert.Equal(t, 0, reconciler.Store.Get().CurrentWeight)
+		assert.Equal(t, AuditResultApplied, sink.entries[len(sink.entries)-1].Result)
+	})
 }
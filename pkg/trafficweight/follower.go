@@ -0,0 +1,63 @@
+package trafficweight
+
+import (
+	"context"
+	"time"
+
+	log "github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// ConfigFollower periodically reads the weight applied by the leader from the
+// backend and copies it into the Store, without ever writing to the backend.
+// It runs in every replica until it is elected, so a new leader starts from the
+// weight currently applied instead of the one read at startup.
+type ConfigFollower struct {
+	Backend  TrafficWeightBackend
+	Store    *WeightStore
+	Interval time.Duration
+	// Elected is closed once this replica becomes the leader, see manager.Manager.Elected
+	Elected <-chan struct{}
+	Log     log.Logger
+}
+
+var _ manager.Runnable = &ConfigFollower{}
+var _ manager.LeaderElectionRunnable = &ConfigFollower{}
+
+func (f *ConfigFollower) NeedLeaderElection() bool {
+	return false
+}
+
+func (f *ConfigFollower) Start(ctx context.Context) error {
+	storeMetrics.record(f.Store.Get())
+	ticker := time.NewTicker(f.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.Elected:
+			// The ConfigReconciler takes over
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			readCtx, cancel := context.WithTimeout(ctx, f.Interval)
+			f.sync(readCtx)
+			cancel()
+		}
+	}
+}
+
+func (f *ConfigFollower) sync(ctx context.Context) {
+	config, err := f.Backend.ReadWeight(ctx)
+	if err != nil {
+		backendMetrics.ReadErrors.Inc()
+		f.Log.Error(err, "Error reading ingress weight from store backend")
+		return
+	}
+	backendMetrics.readSucceeded()
+	// The leader acknowledges in CurrentWeight the weight it applied, which may
+	// be a step towards DesiredWeight when the changes are limited.
+	// The Version is kept so this replica can acknowledge the weight once
+	// elected, see ConfigReconciler.Start.
+	storeMetrics.record(f.Store.Follow(config))
+}
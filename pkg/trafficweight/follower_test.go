@@ -0,0 +1,232 @@
+package trafficweight
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// sharedBackend is a backend shared by several replicas, recording who writes to it
+type sharedBackend struct {
+	mu      sync.Mutex
+	desired int
+	current int
+	version int
+	writes  map[string]int
+	// rejected counts the acknowledgements failing their condition
+	rejected int
+}
+
+func (b *sharedBackend) setDesired(weight int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.desired = weight
+	b.version++
+}
+
+func (b *sharedBackend) get() (current int, writes map[string]int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	writes = map[string]int{}
+	for k, v := range b.writes {
+		writes[k] = v
+	}
+	return b.current, writes
+}
+
+// as returns a view of the backend used by the replica name
+func (b *sharedBackend) as(name string) TrafficWeightBackend {
+	return &sharedBackendReplica{backend: b, name: name}
+}
+
+type sharedBackendReplica struct {
+	backend *sharedBackend
+	name    string
+}
+
+func (r *sharedBackendReplica) ReadWeight(context.Context) (StoreConfig, error) {
+	r.backend.mu.Lock()
+	defer r.backend.mu.Unlock()
+	return StoreConfig{DesiredWeight: r.backend.desired, CurrentWeight: r.backend.current, Version: r.backend.version}, nil
+}
+
+func (r *sharedBackendReplica) OnWeightUpdate(ctx context.Context, store StoreConfig) error {
+	r.backend.mu.Lock()
+	defer r.backend.mu.Unlock()
+	r.backend.writes[r.name]++
+	if store.DesiredWeight != r.backend.desired || store.Version != r.backend.version {
+		r.backend.rejected++
+		return &BackendWriteError{Reason: WriteErrorConditionalCheckFailed}
+	}
+	r.backend.current = store.CurrentWeight
+	return nil
+}
+
+// replica simulates a manager running the weight loops with leader election
+type replica struct {
+	store      *WeightStore
+	reconciler *ConfigReconciler
+	follower   *ConfigFollower
+	elected    chan struct{}
+}
+
+func newReplica(backend TrafficWeightBackend, initial StoreConfig) *replica {
+	store := NewWeightStore(initial)
+	elected := make(chan struct{})
+	return &replica{
+		store:   store,
+		elected: elected,
+		reconciler: &ConfigReconciler{
+			Backend:  backend,
+			Store:    store,
+			Cache:    &fakeCache{ing: &netv1.IngressList{}},
+			Events:   make(chan event.GenericEvent),
+			Interval: time.Millisecond,
+			Limits:   WeightChangeLimits{MaxDeltaPerInterval: 20},
+			Log:      testLogger,
+		},
+		follower: &ConfigFollower{
+			Backend:  backend,
+			Store:    store,
+			Interval: time.Millisecond,
+			Elected:  elected,
+			Log:      testLogger,
+		},
+	}
+}
+
+// run starts the runnables the way the manager does: the follower right away
+// and the reconciler once elected.
+func (r *replica) run(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.follower.Start(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		select {
+		case <-r.elected:
+			r.reconciler.Start(ctx)
+		case <-ctx.Done():
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+func TestOnlyTheLeaderWritesTheWeight(t *testing.T) {
+	t.Parallel()
+	backend := &sharedBackend{desired: 100, current: 100, writes: map[string]int{}}
+	initial := StoreConfig{DesiredWeight: 100, CurrentWeight: 100}
+	first := newReplica(backend.as("first"), initial)
+	second := newReplica(backend.as("second"), initial)
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	defer stopFirst()
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	firstDone := first.run(firstCtx)
+	secondDone := second.run(secondCtx)
+	close(first.elected)
+
+	backend.setDesired(50)
+	assert.Eventually(t, func() bool {
+		current, _ := backend.get()
+		return current == 50
+	}, 5*time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return second.store.Get() == StoreConfig{DesiredWeight: 50, CurrentWeight: 50, Version: 1}
+	}, 5*time.Second, time.Millisecond)
+	_, writes := backend.get()
+	assert.Greater(t, writes["first"], 0)
+	assert.Equal(t, 0, writes["second"])
+
+	// The leader goes away and the second replica takes over from the applied weight
+	stopFirst()
+	<-firstDone
+	_, writes = backend.get()
+	firstWrites := writes["first"]
+	close(second.elected)
+
+	backend.setDesired(0)
+	assert.Eventually(t, func() bool {
+		current, _ := backend.get()
+		return current == 0
+	}, 5*time.Second, time.Millisecond)
+	_, writes = backend.get()
+	assert.Equal(t, firstWrites, writes["first"])
+	// 50 -> 30 -> 10 -> 0 in steps, without jumping back to the startup weight
+	assert.GreaterOrEqual(t, writes["second"], 3)
+	assert.Equal(t, 0, second.store.Get().CurrentWeight)
+
+	stopSecond()
+	<-secondDone
+}
+
+func TestConfigFollower(t *testing.T) {
+	t.Parallel()
+
+	t.Run("the weight applied by the leader is copied without writing", func(t *testing.T) {
+		t.Parallel()
+		backend := &sharedBackend{desired: 50, current: 80, version: 2, writes: map[string]int{}}
+		follower := &ConfigFollower{Backend: backend.as("follower"), Store: NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100}), Log: testLogger}
+		follower.sync(context.Background())
+		assert.Equal(t, StoreConfig{DesiredWeight: 80, CurrentWeight: 80, Version: 2}, follower.Store.Get())
+		_, writes := backend.get()
+		assert.Empty(t, writes)
+	})
+
+	t.Run("the weight followed is acknowledged once elected", func(t *testing.T) {
+		t.Parallel()
+		backend := &sharedBackend{desired: 60, current: 60, version: 3, writes: map[string]int{}}
+		replica := newReplica(backend.as("replica"), StoreConfig{DesiredWeight: 100, CurrentWeight: 100})
+		replica.follower.sync(context.Background())
+
+		// A single tick is run when the context is done
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.NoError(t, replica.reconciler.Start(ctx))
+		current, writes := backend.get()
+		assert.Equal(t, 60, current)
+		assert.Equal(t, 1, writes["replica"])
+		assert.Equal(t, 0, backend.rejected, "the acknowledgement is for the version read")
+	})
+
+	t.Run("read errors keep the current weight", func(t *testing.T) {
+		t.Parallel()
+		backend := &testBackend{readErr: assert.AnError}
+		follower := &ConfigFollower{Backend: backend, Store: NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100}), Log: testLogger}
+		follower.sync(context.Background())
+		assert.Equal(t, StoreConfig{DesiredWeight: 100, CurrentWeight: 100}, follower.Store.Get())
+	})
+
+	t.Run("it stops once elected", func(t *testing.T) {
+		t.Parallel()
+		elected := make(chan struct{})
+		follower := &ConfigFollower{Backend: &testBackend{}, Store: NewWeightStore(StoreConfig{}), Interval: time.Millisecond, Elected: elected, Log: testLogger}
+		done := make(chan error)
+		go func() {
+			done <- follower.Start(context.Background())
+		}()
+		close(elected)
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the follower did not stop once elected")
+		}
+		assert.False(t, follower.NeedLeaderElection())
+	})
+}
@@ -0,0 +1,95 @@
+package trafficweight
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const lastKnownGoodKey = "store.json"
+
+// LastKnownGoodStore persists the last StoreConfig successfully read from the
+// weight backend, so it can be used when the backend is unreachable.
+type LastKnownGoodStore interface {
+	Load(ctx context.Context) (StoreConfig, error)
+	Save(ctx context.Context, store StoreConfig) error
+}
+
+type noLastKnownGoodStore struct{}
+
+func (noLastKnownGoodStore) Load(context.Context) (StoreConfig, error) {
+	return StoreConfig{}, fmt.Errorf("no last known good store configured")
+}
+
+func (noLastKnownGoodStore) Save(context.Context, StoreConfig) error {
+	return nil
+}
+
+type configMapLastKnownGoodStore struct {
+	reader client.Reader
+	writer client.Writer
+	key    types.NamespacedName
+}
+
+// NewLastKnownGoodStore returns a LastKnownGoodStore persisting the configuration in the given ConfigMap.
+// When name is empty, the returned store does not persist anything.
+// The reader is expected to be uncached, so that the store can be used before the manager cache is started.
+func NewLastKnownGoodStore(reader client.Reader, writer client.Writer, namespace, name string) LastKnownGoodStore {
+	if name == "" {
+		return noLastKnownGoodStore{}
+	}
+	return &configMapLastKnownGoodStore{
+		reader: reader,
+		writer: writer,
+		key:    types.NamespacedName{Namespace: namespace, Name: name},
+	}
+}
+
+func (s *configMapLastKnownGoodStore) Load(ctx context.Context) (StoreConfig, error) {
+	var cm corev1.ConfigMap
+	if err := s.reader.Get(ctx, s.key, &cm); err != nil {
+		return StoreConfig{}, err
+	}
+	data, ok := cm.Data[lastKnownGoodKey]
+	if !ok {
+		return StoreConfig{}, fmt.Errorf("configmap %s does not contain %s", s.key, lastKnownGoodKey)
+	}
+	store := StoreConfig{}
+	if err := json.Unmarshal([]byte(data), &store); err != nil {
+		return StoreConfig{}, err
+	}
+	return store, nil
+}
+
+func (s *configMapLastKnownGoodStore) Save(ctx context.Context, store StoreConfig) error {
+	data, err := json.Marshal(store)
+	if err != nil {
+		return err
+	}
+	var cm corev1.ConfigMap
+	err = s.reader.Get(ctx, s.key, &cm)
+	if apierrors.IsNotFound(err) {
+		cm = corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.key.Name,
+				Namespace: s.key.Namespace,
+			},
+			Data: map[string]string{lastKnownGoodKey: string(data)},
+		}
+		return s.writer.Create(ctx, &cm)
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[lastKnownGoodKey] = string(data)
+	return s.writer.Update(ctx, &cm)
+}
@@ -0,0 +1,73 @@
+package trafficweight
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestConfigMapLastKnownGoodStore(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	store := NewLastKnownGoodStore(k8sClient, k8sClient, "traffic-controller", "last-known-good")
+
+	_, err := store.Load(context.Background())
+	assert.Error(t, err)
+
+	assert.NoError(t, store.Save(context.Background(), StoreConfig{DesiredWeight: 50, CurrentWeight: 50}))
+	loaded, err := store.Load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, StoreConfig{DesiredWeight: 50, CurrentWeight: 50}, loaded)
+
+	assert.NoError(t, store.Save(context.Background(), StoreConfig{DesiredWeight: 20, CurrentWeight: 20}))
+	loaded, err = store.Load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, StoreConfig{DesiredWeight: 20, CurrentWeight: 20}, loaded)
+
+	cm := corev1.ConfigMap{}
+	assert.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "traffic-controller", Name: "last-known-good"}, &cm))
+	assert.Contains(t, cm.Data, lastKnownGoodKey)
+}
+
+func TestRestoreLastKnownGood(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+	lastKnownGood := NewLastKnownGoodStore(k8sClient, k8sClient, "traffic-controller", "last-known-good")
+	saved := StoreConfig{DesiredWeight: 40, CurrentWeight: 40, AWSHealthCheckID: "old-check", Version: 7, ChangedBy: "jane", RecordTTL: 30}
+	require.NoError(t, lastKnownGood.Save(context.Background(), saved))
+
+	loaded, err := lastKnownGood.Load(context.Background())
+	require.NoError(t, err)
+	store := NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100, AWSHealthCheckID: "check"})
+	store.Restore(loaded)
+	assert.Equal(t, StoreConfig{DesiredWeight: 40, CurrentWeight: 40, AWSHealthCheckID: "check", Version: 7, ChangedBy: "jane", RecordTTL: 30}, store.Get())
+
+	// The weight read before the outage is acknowledged once the backend is back
+	backend := &sharedBackend{desired: 40, current: 40, version: 7, writes: map[string]int{}}
+	reconciler := &ConfigReconciler{Backend: backend.as("leader"), Store: store, Cache: &fakeCache{ing: &netv1.IngressList{}}, Interval: time.Millisecond, Log: testLogger}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, reconciler.Start(ctx))
+	_, writes := backend.get()
+	assert.Equal(t, 1, writes["leader"])
+	assert.Equal(t, 0, backend.rejected)
+}
+
+func TestNoLastKnownGoodStore(t *testing.T) {
+	store := NewLastKnownGoodStore(nil, nil, "", "")
+	assert.NoError(t, store.Save(context.Background(), StoreConfig{DesiredWeight: 50}))
+	_, err := store.Load(context.Background())
+	assert.Error(t, err)
+}
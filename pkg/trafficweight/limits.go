@@ -0,0 +1,54 @@
+package trafficweight
+
+import (
+	"fmt"
+)
+
+// WeightChangeLimits bounds how fast the cluster weight is allowed to move.
+// A zero value disables the corresponding limit.
+type WeightChangeLimits struct {
+	// MaxDeltaPerInterval is the maximum weight change applied on a single
+	// reconcile interval. Larger changes are applied in steps of this size.
+	MaxDeltaPerInterval int
+	// MaxDeltaPerChange is the maximum accepted difference between the
+	// current and the desired weight. Larger changes are rejected.
+	MaxDeltaPerChange int
+}
+
+type WeightChangeRejectedError struct {
+	CurrentWeight int
+	DesiredWeight int
+	MaxDelta      int
+}
+
+func (e *WeightChangeRejectedError) Error() string {
+	return fmt.Sprintf(
+		"weight change from %d to %d exceeds the maximum allowed change of %d, set the force flag in the backend to apply it",
+		e.CurrentWeight, e.DesiredWeight, e.MaxDelta,
+	)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// nextWeight returns the weight to apply on this interval to move from current towards desired
+func (l WeightChangeLimits) nextWeight(current, desired int, forced bool) (int, error) {
+	delta := desired - current
+	if forced {
+		return desired, nil
+	}
+	if l.MaxDeltaPerChange > 0 && abs(delta) > l.MaxDeltaPerChange {
+		return current, &WeightChangeRejectedError{CurrentWeight: current, DesiredWeight: desired, MaxDelta: l.MaxDeltaPerChange}
+	}
+	if l.MaxDeltaPerInterval > 0 && abs(delta) > l.MaxDeltaPerInterval {
+		if delta > 0 {
+			return current + l.MaxDeltaPerInterval, nil
+		}
+		return current - l.MaxDeltaPerInterval, nil
+	}
+	return desired, nil
+}
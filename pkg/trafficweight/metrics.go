@@ -0,0 +1,186 @@
+package trafficweight
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+type StoreMetrics struct {
+	DesiredWeight     prometheus.Gauge
+	CurrentWeight     prometheus.Gauge
+	RecordTTLOverride prometheus.Gauge
+	// RecordTTLOverrideRejected is set by the ConfigReconciler, it is not part of the store
+	RecordTTLOverrideRejected prometheus.Gauge
+}
+
+type WeightChangeMetrics struct {
+	Rejected          prometheus.Counter
+	Limited           prometheus.Counter
+	EnqueuedIngresses prometheus.Histogram
+}
+
+type BackendMetrics struct {
+	LastSuccessfulRead      prometheus.Gauge
+	SinceLastSuccessfulRead prometheus.GaugeFunc
+	ReadErrors              prometheus.Counter
+	FallbackActive          prometheus.Gauge
+	DynamoDBRequestDuration *prometheus.HistogramVec
+	DynamoDBRequestErrors   *prometheus.CounterVec
+}
+
+type PropagationMetrics struct {
+	Duration prometheus.Histogram
+	Pending  prometheus.Gauge
+}
+
+// lastSuccessfulRead holds the unix nano time of the last successful backend read,
+// initialized to the start time of the controller
+var lastSuccessfulRead atomic.Int64
+
+var (
+	storeMetrics = StoreMetrics{
+		DesiredWeight: prometheus.NewGauge(prometheus.GaugeOpts{
+			// cluster_traffic_controller_ingress_weight_desired
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "ingress_weight_desired",
+			Help:      "The desired weight of the ingress",
+		}),
+		CurrentWeight: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				// cluster_traffic_controller_ingress_weight_current
+				Namespace: "cluster",
+				Subsystem: "traffic_controller",
+				Name:      "ingress_weight_current",
+				Help:      "The current weight of the cluster",
+			},
+		),
+		RecordTTLOverride: prometheus.NewGauge(prometheus.GaugeOpts{
+			// cluster_traffic_controller_record_ttl_override_seconds
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "record_ttl_override_seconds",
+			Help:      "The TTL of the DNS records set in the weight backend, 0 when not overridden",
+		}),
+		RecordTTLOverrideRejected: prometheus.NewGauge(prometheus.GaugeOpts{
+			// cluster_traffic_controller_record_ttl_override_rejected
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "record_ttl_override_rejected",
+			Help:      "Whether the TTL of the DNS records set in the weight backend is invalid, and ignored",
+		}),
+	}
+	weightChangeMetrics = WeightChangeMetrics{
+		Rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			// cluster_traffic_controller_weight_change_rejected_total
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "weight_change_rejected_total",
+			Help:      "The number of weight changes rejected because they exceed the maximum allowed change",
+		}),
+		Limited: prometheus.NewCounter(prometheus.CounterOpts{
+			// cluster_traffic_controller_weight_change_limited_total
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "weight_change_limited_total",
+			Help:      "The number of weight changes applied in steps because they exceed the maximum change per interval",
+		}),
+		EnqueuedIngresses: prometheus.NewHistogram(prometheus.HistogramOpts{
+			// cluster_traffic_controller_weight_change_enqueued_ingresses
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "weight_change_enqueued_ingresses",
+			Help:      "The number of ingresses enqueued for reconciliation per weight change",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+	}
+	backendMetrics = BackendMetrics{
+		LastSuccessfulRead: prometheus.NewGauge(prometheus.GaugeOpts{
+			// cluster_traffic_controller_backend_last_successful_read_timestamp_seconds
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "backend_last_successful_read_timestamp_seconds",
+			Help:      "The unix timestamp of the last successful read from the weight backend",
+		}),
+		SinceLastSuccessfulRead: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			// cluster_traffic_controller_backend_seconds_since_last_successful_read
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "backend_seconds_since_last_successful_read",
+			Help:      "The time since the last successful read from the weight backend, or since the controller started",
+		}, func() float64 {
+			return time.Since(time.Unix(0, lastSuccessfulRead.Load())).Seconds()
+		}),
+		ReadErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			// cluster_traffic_controller_backend_read_errors_total
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "backend_read_errors_total",
+			Help:      "The number of failed reads from the weight backend",
+		}),
+		FallbackActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			// cluster_traffic_controller_backend_outage_fallback_active
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "backend_outage_fallback_active",
+			Help:      "Whether the fallback weight is applied because the weight backend is unreachable",
+		}),
+		DynamoDBRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			// cluster_traffic_controller_dynamodb_request_duration_seconds
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "dynamodb_request_duration_seconds",
+			Help:      "The duration of the requests to DynamoDB, including the failed ones",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		DynamoDBRequestErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			// cluster_traffic_controller_dynamodb_request_errors_total
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "dynamodb_request_errors_total",
+			Help:      "The number of failed requests to DynamoDB",
+		}, []string{"operation", "type"}),
+	}
+	propagationMetrics = PropagationMetrics{
+		Duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			// cluster_traffic_controller_weight_propagation_duration_seconds
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "weight_propagation_duration_seconds",
+			Help:      "The time from a weight change being observed to all the ingresses being reconciled with it",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+		}),
+		Pending: prometheus.NewGauge(prometheus.GaugeOpts{
+			// cluster_traffic_controller_weight_propagation_pending_ingresses
+			Namespace: "cluster",
+			Subsystem: "traffic_controller",
+			Name:      "weight_propagation_pending_ingresses",
+			Help:      "The number of ingresses not reconciled yet with the last weight change",
+		}),
+	}
+)
+
+// readSucceeded records a successful read from the weight backend
+func (m BackendMetrics) readSucceeded() {
+	lastSuccessfulRead.Store(time.Now().UnixNano())
+	m.LastSuccessfulRead.SetToCurrentTime()
+}
+
+// record exposes the weight configuration currently applied
+func (m StoreMetrics) record(store StoreConfig) {
+	m.DesiredWeight.Set(float64(store.DesiredWeight))
+	m.CurrentWeight.Set(float64(store.CurrentWeight))
+	m.RecordTTLOverride.Set(float64(store.RecordTTL))
+}
+
+func init() {
+	lastSuccessfulRead.Store(time.Now().UnixNano())
+	metrics.Registry.MustRegister(storeMetrics.DesiredWeight, storeMetrics.CurrentWeight, storeMetrics.RecordTTLOverride, storeMetrics.RecordTTLOverrideRejected)
+	metrics.Registry.MustRegister(weightChangeMetrics.Rejected, weightChangeMetrics.Limited, weightChangeMetrics.EnqueuedIngresses)
+	metrics.Registry.MustRegister(backendMetrics.LastSuccessfulRead, backendMetrics.SinceLastSuccessfulRead, backendMetrics.ReadErrors, backendMetrics.FallbackActive)
+	metrics.Registry.MustRegister(backendMetrics.DynamoDBRequestDuration, backendMetrics.DynamoDBRequestErrors)
+	metrics.Registry.MustRegister(propagationMetrics.Duration, propagationMetrics.Pending)
+}
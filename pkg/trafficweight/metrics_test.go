@@ -0,0 +1,63 @@
+package trafficweight
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func metricValue(t *testing.T, metric prometheus.Metric) *dto.Metric {
+	t.Helper()
+	m := &dto.Metric{}
+	require.NoError(t, metric.Write(m))
+	return m
+}
+
+func TestDynamoDBRequestMetrics(t *testing.T) {
+	throttled := backendMetrics.DynamoDBRequestErrors.WithLabelValues("TransactWriteItems", "Throttled")
+	before := metricValue(t, throttled).GetCounter().GetValue()
+	writes := backendMetrics.DynamoDBRequestDuration.WithLabelValues("TransactWriteItems").(prometheus.Histogram)
+	writesBefore := metricValue(t, writes).GetHistogram().GetSampleCount()
+	reads := backendMetrics.DynamoDBRequestDuration.WithLabelValues("GetItem").(prometheus.Histogram)
+	readsBefore := metricValue(t, reads).GetHistogram().GetSampleCount()
+
+	mockSvc := &mockDynamoDBClient{
+		desiredWeight: aws.String("0"),
+		writeErrs: []error{
+			awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "slow down", nil),
+		},
+	}
+	dynamoBackend := dynamodbBackend{
+		service: mockSvc,
+		backoff: wait.Backoff{Steps: 2, Duration: time.Millisecond},
+		Log:     zap.New(zap.UseDevMode(true)),
+	}
+	require.NoError(t, dynamoBackend.OnWeightUpdate(context.Background(), StoreConfig{CurrentWeight: 35}))
+	_, err := dynamoBackend.ReadWeight(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, before+1, metricValue(t, throttled).GetCounter().GetValue())
+	assert.Equal(t, writesBefore+2, metricValue(t, writes).GetHistogram().GetSampleCount())
+	assert.Equal(t, readsBefore+1, metricValue(t, reads).GetHistogram().GetSampleCount())
+}
+
+func TestRequestErrorType(t *testing.T) {
+	assert.Equal(t, "Throttled", requestErrorType(awserr.New(dynamodb.ErrCodeRequestLimitExceeded, "slow down", nil)))
+	assert.Equal(t, "Canceled", requestErrorType(awserr.New("RequestCanceled", "canceled", context.DeadlineExceeded)))
+	assert.Equal(t, "Unknown", requestErrorType(assert.AnError))
+}
+
+func TestSinceLastSuccessfulRead(t *testing.T) {
+	backendMetrics.readSucceeded()
+	assert.Less(t, metricValue(t, backendMetrics.SinceLastSuccessfulRead).GetGauge().GetValue(), time.Minute.Seconds())
+}
@@ -0,0 +1,70 @@
+package trafficweight
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+type OutagePolicyMode string
+
+const (
+	// OutagePolicyKeepLast keeps the last applied weight while the backend is unreachable
+	OutagePolicyKeepLast OutagePolicyMode = "keep-last"
+	// OutagePolicyFallback applies OutagePolicy.FallbackWeight once the backend
+	// has been unreachable for longer than OutagePolicy.GracePeriod
+	OutagePolicyFallback OutagePolicyMode = "fallback"
+)
+
+func ParseOutagePolicyMode(mode string) (OutagePolicyMode, error) {
+	switch OutagePolicyMode(mode) {
+	case OutagePolicyKeepLast, OutagePolicyFallback:
+		return OutagePolicyMode(mode), nil
+	default:
+		return "", fmt.Errorf("unknown backend outage policy %q, valid values are %q and %q", mode, OutagePolicyKeepLast, OutagePolicyFallback)
+	}
+}
+
+// OutagePolicy describes how the controller reacts when the weight backend is unreachable
+type OutagePolicy struct {
+	Mode           OutagePolicyMode
+	GracePeriod    time.Duration
+	FallbackWeight int
+}
+
+type BackendUnavailableError struct {
+	err error
+}
+
+func (e *BackendUnavailableError) Error() string {
+	return fmt.Sprintf("weight backend unavailable: %v", e.err)
+}
+
+func (e *BackendUnavailableError) Unwrap() error { return e.err }
+
+// apply enforces the policy after the backend has been unreachable for the given duration.
+// It returns the number of affected hosts and true when the stored weight was changed.
+func (p OutagePolicy) apply(ctx context.Context, store *WeightStore, c cache.Cache, events chan event.GenericEvent, filter IngressFilter, propagation *PropagationTracker, outage time.Duration) (int, bool, error) {
+	if p.Mode != OutagePolicyFallback || outage < p.GracePeriod {
+		return 0, false, nil
+	}
+	backendMetrics.FallbackActive.Set(1)
+	if current := store.Get(); current.CurrentWeight == p.FallbackWeight && current.DesiredWeight == p.FallbackWeight {
+		return 0, false, nil
+	}
+	store.Update(func(config *StoreConfig) {
+		config.DesiredWeight = p.FallbackWeight
+		config.ChangedBy = AuditSourceOutageFallback
+	})
+	hosts, err := enqueueReconcileEvents(ctx, events, c, filter, propagation, p.FallbackWeight)
+	if err != nil {
+		return hosts, false, err
+	}
+	store.Update(func(config *StoreConfig) {
+		config.CurrentWeight = p.FallbackWeight
+	})
+	return hosts, true, nil
+}
@@ -0,0 +1,87 @@
+package trafficweight
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// PropagationTracker measures how long a weight change takes to be applied to
+// all the ingresses. The weight loop starts tracking the ingresses it enqueues
+// and the ingress reconciler reports every ingress reconciled with the weight.
+// A nil tracker does nothing.
+type PropagationTracker struct {
+	mu      sync.Mutex
+	weight  int
+	started time.Time
+	pending map[types.NamespacedName]struct{}
+	// now defaults to time.Now
+	now func() time.Time
+}
+
+func NewPropagationTracker() *PropagationTracker {
+	return &PropagationTracker{}
+}
+
+func (t *PropagationTracker) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+// Start tracks the propagation of weight to the given ingresses.
+// A propagation still in progress is abandoned.
+func (t *PropagationTracker) Start(weight int, ingresses []types.NamespacedName) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.weight = weight
+	t.started = t.clock()
+	t.pending = make(map[types.NamespacedName]struct{}, len(ingresses))
+	for _, ingress := range ingresses {
+		t.pending[ingress] = struct{}{}
+	}
+	t.observe()
+}
+
+// Done reports that ingress was reconciled with weight
+func (t *PropagationTracker) Done(ingress types.NamespacedName, weight int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pending == nil || weight != t.weight {
+		return
+	}
+	if _, ok := t.pending[ingress]; !ok {
+		return
+	}
+	delete(t.pending, ingress)
+	t.observe()
+}
+
+// Pending returns the number of ingresses not yet reconciled with the last weight change
+func (t *PropagationTracker) Pending() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+// observe must be called with the lock held
+func (t *PropagationTracker) observe() {
+	propagationMetrics.Pending.Set(float64(len(t.pending)))
+	if len(t.pending) == 0 {
+		propagationMetrics.Duration.Observe(t.clock().Sub(t.started).Seconds())
+		t.pending = nil
+	}
+}
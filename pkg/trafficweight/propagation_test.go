@@ -0,0 +1,50 @@
+package trafficweight
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestPropagationTracker(t *testing.T) {
+	first := types.NamespacedName{Namespace: "ns", Name: "first"}
+	second := types.NamespacedName{Namespace: "ns", Name: "second"}
+
+	t.Run("the propagation finishes once all the ingresses are reconciled with the weight", func(t *testing.T) {
+		now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		tracker := &PropagationTracker{now: func() time.Time { return now }}
+		tracker.Start(50, []types.NamespacedName{first, second})
+		assert.Equal(t, 2, tracker.Pending())
+
+		tracker.Done(first, 100)
+		assert.Equal(t, 2, tracker.Pending())
+		tracker.Done(first, 50)
+		tracker.Done(first, 50)
+		assert.Equal(t, 1, tracker.Pending())
+		tracker.Done(types.NamespacedName{Namespace: "ns", Name: "unknown"}, 50)
+		assert.Equal(t, 1, tracker.Pending())
+
+		now = now.Add(time.Minute)
+		tracker.Done(second, 50)
+		assert.Equal(t, 0, tracker.Pending())
+	})
+
+	t.Run("a new weight change abandons the previous one", func(t *testing.T) {
+		tracker := NewPropagationTracker()
+		tracker.Start(50, []types.NamespacedName{first, second})
+		tracker.Start(0, []types.NamespacedName{first})
+		tracker.Done(second, 50)
+		assert.Equal(t, 1, tracker.Pending())
+		tracker.Done(first, 0)
+		assert.Equal(t, 0, tracker.Pending())
+	})
+
+	t.Run("a nil tracker does nothing", func(t *testing.T) {
+		var tracker *PropagationTracker
+		tracker.Start(50, []types.NamespacedName{first})
+		tracker.Done(first, 50)
+		assert.Equal(t, 0, tracker.Pending())
+	})
+}
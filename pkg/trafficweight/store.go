@@ -1,9 +1,123 @@
 package trafficweight
 
+import (
+	"sync"
+	"sync/atomic"
+)
+
 type StoreConfig struct {
 	DesiredWeight    int
 	CurrentWeight    int
 	AWSHealthCheckID string
+	// Version of the DesiredWeight, as provided by the backend
+	Version int
+	// Force allows DesiredWeight changes exceeding the configured WeightChangeLimits
+	Force bool
+	// ChangedBy identifies who changed the DesiredWeight, when the backend provides it
+	ChangedBy string
+	// RecordTTL, when not 0, overrides the TTL in seconds of all the DNS records,
+	// like to lower it ahead of a planned migration
+	RecordTTL int64
+}
+
+// WeightStore holds the weight configuration applied by the controller.
+// It is safe for concurrent use: readers get a consistent snapshot of the
+// configuration and can subscribe to be notified of its changes.
+type WeightStore struct {
+	current atomic.Pointer[StoreConfig]
+
+	// mu serializes writers and protects subscribers
+	mu          sync.Mutex
+	subscribers map[int]chan StoreConfig
+	nextID      int
+}
+
+func NewWeightStore(initial StoreConfig) *WeightStore {
+	s := &WeightStore{subscribers: map[int]chan StoreConfig{}}
+	s.current.Store(&initial)
+	return s
 }
 
-var Store StoreConfig
+// Get returns a snapshot of the current configuration
+func (s *WeightStore) Get() StoreConfig {
+	return *s.current.Load()
+}
+
+// Set replaces the configuration and notifies the subscribers if it changed
+func (s *WeightStore) Set(config StoreConfig) {
+	s.Update(func(c *StoreConfig) {
+		*c = config
+	})
+}
+
+// Update atomically applies mutate to the configuration and returns the new value
+func (s *WeightStore) Update(mutate func(*StoreConfig)) StoreConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.current.Load()
+	next := *previous
+	mutate(&next)
+	s.current.Store(&next)
+	if next != *previous {
+		for _, ch := range s.subscribers {
+			notify(ch, next)
+		}
+	}
+	return next
+}
+
+// Follow applies the weight acknowledged in the CurrentWeight of config, as
+// read from the backend, with its Version and valid RecordTTL. The leader
+// then steps from it towards the DesiredWeight of config within the
+// WeightChangeLimits.
+func (s *WeightStore) Follow(config StoreConfig) StoreConfig {
+	return s.Update(func(store *StoreConfig) {
+		store.DesiredWeight = config.CurrentWeight
+		store.CurrentWeight = config.CurrentWeight
+		store.Version = config.Version
+		if ValidateRecordTTL(config.RecordTTL) == nil {
+			store.RecordTTL = config.RecordTTL
+		}
+	})
+}
+
+// Restore applies a saved configuration, like the last known good one, but
+// for the AWSHealthCheckID configured in this instance
+func (s *WeightStore) Restore(saved StoreConfig) StoreConfig {
+	return s.Update(func(store *StoreConfig) {
+		saved.AWSHealthCheckID = store.AWSHealthCheckID
+		*store = saved
+	})
+}
+
+// Subscribe returns a channel receiving the configuration every time it changes
+// and a function to cancel the subscription.
+// Slow subscribers only receive the latest configuration.
+func (s *WeightStore) Subscribe() (<-chan StoreConfig, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	ch := make(chan StoreConfig, 1)
+	s.subscribers[id] = ch
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subscribers[id]; ok {
+			delete(s.subscribers, id)
+			close(ch)
+		}
+	}
+}
+
+// notify sends config without blocking, replacing any pending notification.
+// It must be called with the store lock held.
+func notify(ch chan StoreConfig, config StoreConfig) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- config
+}
@@ -0,0 +1,119 @@
+package trafficweight
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netv1 "k8s.io/api/networking/v1"
+)
+
+func TestWeightStore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("updates are visible to readers", func(t *testing.T) {
+		t.Parallel()
+		store := NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100, AWSHealthCheckID: "id"})
+		assert.Equal(t, StoreConfig{DesiredWeight: 100, CurrentWeight: 100, AWSHealthCheckID: "id"}, store.Get())
+
+		updated := store.Update(func(config *StoreConfig) {
+			config.DesiredWeight = 50
+		})
+		assert.Equal(t, StoreConfig{DesiredWeight: 50, CurrentWeight: 100, AWSHealthCheckID: "id"}, updated)
+		assert.Equal(t, updated, store.Get())
+
+		store.Set(StoreConfig{DesiredWeight: 10, CurrentWeight: 10})
+		assert.Equal(t, StoreConfig{DesiredWeight: 10, CurrentWeight: 10}, store.Get())
+	})
+
+	t.Run("snapshots are not affected by later updates", func(t *testing.T) {
+		t.Parallel()
+		store := NewWeightStore(StoreConfig{DesiredWeight: 100})
+		snapshot := store.Get()
+		store.Set(StoreConfig{DesiredWeight: 0})
+		assert.Equal(t, 100, snapshot.DesiredWeight)
+	})
+
+	t.Run("subscribers are notified of changes only", func(t *testing.T) {
+		t.Parallel()
+		store := NewWeightStore(StoreConfig{DesiredWeight: 100})
+		changes, cancel := store.Subscribe()
+		defer cancel()
+
+		store.Set(StoreConfig{DesiredWeight: 100})
+		assert.Len(t, changes, 0)
+
+		store.Set(StoreConfig{DesiredWeight: 50})
+		require.Len(t, changes, 1)
+		assert.Equal(t, StoreConfig{DesiredWeight: 50}, <-changes)
+	})
+
+	t.Run("slow subscribers get the latest configuration", func(t *testing.T) {
+		t.Parallel()
+		store := NewWeightStore(StoreConfig{})
+		changes, cancel := store.Subscribe()
+		defer cancel()
+
+		for i := 1; i <= 10; i++ {
+			store.Set(StoreConfig{DesiredWeight: i})
+		}
+		require.Len(t, changes, 1)
+		assert.Equal(t, StoreConfig{DesiredWeight: 10}, <-changes)
+	})
+
+	t.Run("cancelled subscriptions are closed and no longer notified", func(t *testing.T) {
+		t.Parallel()
+		store := NewWeightStore(StoreConfig{})
+		changes, cancel := store.Subscribe()
+		cancel()
+		cancel()
+
+		store.Set(StoreConfig{DesiredWeight: 10})
+		_, open := <-changes
+		assert.False(t, open)
+	})
+
+	t.Run("concurrent updates are not lost", func(t *testing.T) {
+		t.Parallel()
+		store := NewWeightStore(StoreConfig{})
+		changes, cancel := store.Subscribe()
+		defer cancel()
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 50; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				store.Update(func(config *StoreConfig) {
+					config.CurrentWeight++
+				})
+			}()
+			go func() {
+				defer wg.Done()
+				_ = store.Get()
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 50, store.Get().CurrentWeight)
+		assert.Equal(t, 50, (<-changes).CurrentWeight)
+	})
+
+	t.Run("the applied weight is followed and the desired one reached within the limits", func(t *testing.T) {
+		t.Parallel()
+		store := NewWeightStore(StoreConfig{DesiredWeight: 50, CurrentWeight: 50, AWSHealthCheckID: "check"})
+		store.Follow(StoreConfig{DesiredWeight: 0, CurrentWeight: 100, Version: 4, RecordTTL: 30})
+		assert.Equal(t, StoreConfig{DesiredWeight: 100, CurrentWeight: 100, AWSHealthCheckID: "check", Version: 4, RecordTTL: 30}, store.Get())
+
+		reconciler := &ConfigReconciler{
+			Backend: &testBackend{weight: 0, ttl: 30},
+			Store:   store,
+			Cache:   &fakeCache{ing: &netv1.IngressList{}},
+			Limits:  WeightChangeLimits{MaxDeltaPerInterval: 20},
+			Log:     testLogger,
+		}
+		require.NoError(t, reconciler.doReconcile(context.Background()))
+		assert.Equal(t, 80, store.Get().CurrentWeight, "the desired weight is not applied at once")
+	})
+}
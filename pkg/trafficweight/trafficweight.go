@@ -2,94 +2,360 @@ package trafficweight
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	log "github.com/go-logr/logr"
 	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 type TrafficWeightBackend interface {
-	ReadWeight() (int, error)
-	OnWeightUpdate(StoreConfig) error
+	// ReadWeight returns the weight configuration currently stored in the backend
+	ReadWeight(ctx context.Context) (StoreConfig, error)
+	// OnWeightUpdate acknowledges that store.CurrentWeight was applied for the
+	// store.DesiredWeight and store.Version previously returned by ReadWeight
+	OnWeightUpdate(ctx context.Context, store StoreConfig) error
 }
 
-func NewBackend(backendType, clusterName string, awsRegion string, tableName string, awsHealthCheckID string, logger log.Logger) (TrafficWeightBackend, error) {
+// NewBackend returns the backend of backendType. In dryRun, the backend is
+// only read.
+func NewBackend(ctx context.Context, backendType, clusterName string, awsRegion string, tableName string, awsHealthCheckID string, store *WeightStore, logger log.Logger, dryRun bool) (TrafficWeightBackend, error) {
 	switch backendType {
 	case "fake":
-		return NewFakeBackend(logger), nil
+		return NewFakeBackend(logger, store), nil
 	case "dynamoDB":
-		return NewDynamodbBackend(logger, clusterName, awsRegion, tableName), nil
+		return NewDynamodbBackend(ctx, logger, store, clusterName, awsRegion, tableName, dryRun)
 	default:
 		return nil, fmt.Errorf("Not implemented")
 	}
 }
 
-func enqueueReconcileEvents(events chan event.GenericEvent, c cache.Cache) error {
+// IngressFilter tells whether an ingress is reconciled by the ingress controller
+type IngressFilter func(ctx context.Context, ingress *netv1.Ingress) bool
+
+// enqueueReconcileEvents triggers the reconciliation of all the ingresses
+// accepted by filter, all of them when nil, after a change to weight.
+// It returns the number of hosts of these ingresses.
+func enqueueReconcileEvents(ctx context.Context, events chan event.GenericEvent, c cache.Cache, filter IngressFilter, propagation *PropagationTracker, weight int) (int, error) {
 	var ingresses netv1.IngressList
-	err := c.List(context.Background(), &ingresses, &client.ListOptions{})
+	err := c.List(ctx, &ingresses, &client.ListOptions{})
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if filter != nil {
+		// The ingresses out of scope are never reconciled, they would stay pending
+		selected := ingresses.Items[:0]
+		for i := range ingresses.Items {
+			if filter(ctx, &ingresses.Items[i]) {
+				selected = append(selected, ingresses.Items[i])
+			}
+		}
+		ingresses.Items = selected
 	}
+	keys := make([]types.NamespacedName, 0, len(ingresses.Items))
+	hosts := 0
 	for i := range ingresses.Items {
+		keys = append(keys, client.ObjectKeyFromObject(&ingresses.Items[i]))
+		for _, rule := range ingresses.Items[i].Spec.Rules {
+			if rule.Host != "" {
+				hosts++
+			}
+		}
+	}
+	propagation.Start(weight, keys)
+	weightChangeMetrics.EnqueuedIngresses.Observe(float64(len(keys)))
+	return hosts, sendReconcileEvents(ctx, events, ingresses.Items)
+}
+
+// sendReconcileEvents triggers the reconciliation of the given ingresses
+func sendReconcileEvents(ctx context.Context, events chan event.GenericEvent, ingresses []netv1.Ingress) error {
+	for i := range ingresses {
 		// Provide a distinct object for each loop.
 		// as generic event accepts a pointer, using the intuitive for _, ing := range ingresses.Items {
 		// copies for every single ingress the object into the same ing value. Then, we would always
 		// provide the same address to event.GenericEvent{Object: &ing} and hence would make future calls
 		// to handle multiple times the same ingress, and skipping some of them, depending on the concurrence pattern
-		// we could do "DeepCopys" instead but that would consume more memory
-		// We take advantage of ingresses already have allocated all the required memory and
-		// avoid duplicating it
+		// The listed ingresses are already copies of the cached objects, so they
+		// are sent whole: the event predicates filter on their spec too, like
+		// the ingress class, not only on their metadata
 		genEvent := event.GenericEvent{
-			Object: &netv1.Ingress{
-				ObjectMeta: *ingresses.Items[i].ObjectMeta.DeepCopy(),
-			},
+			Object: &ingresses[i],
+		}
+		select {
+		case events <- genEvent:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		events <- genEvent
 	}
 	return nil
 }
 
-func doReconcile(backend TrafficWeightBackend, c cache.Cache, events chan event.GenericEvent) error {
-	desiredWeight, err := backend.ReadWeight()
+// ConfigReconciler periodically reads the weight from the backend and triggers
+// the reconciliation of all the ingresses when it changes.
+// It is meant to be added to the manager, so it only runs once the caches are
+// synced and this instance is the leader. Replicas that are not the leader keep
+// their WeightStore up to date with a ConfigFollower.
+type ConfigReconciler struct {
+	Backend       TrafficWeightBackend
+	Store         *WeightStore
+	Cache         cache.Cache
+	Events        chan event.GenericEvent
+	Interval      time.Duration
+	Limits        WeightChangeLimits
+	OutagePolicy  OutagePolicy
+	LastKnownGood LastKnownGoodStore
+	// Propagation, when set, tracks the ingresses reconciled after each weight change
+	Propagation *PropagationTracker
+	// Filter, when set, selects the ingresses reconciled and tracked after each weight change
+	Filter IngressFilter
+	// Audit, when set, records every new DesiredWeight observed in the backend
+	Audit AuditSink
+	// Source names the backend in the audit entries
+	Source string
+	// DryRun applies the weights to the WeightStore without acknowledging
+	// them to the backend nor saving them as last known good
+	DryRun bool
+	Log    log.Logger
+
+	lastSuccessfulRead time.Time
+	saved              StoreConfig
+	// pendingAck holds an applied weight the backend failed to acknowledge
+	pendingAck *StoreConfig
+	// audited is the last recorded entry, so that a change failing on every
+	// tick is only recorded once
+	audited *AuditEntry
+	// rejectedTTL is the last invalid record TTL read from the backend, so
+	// that it is only logged once
+	rejectedTTL *int64
+}
+
+var _ manager.Runnable = &ConfigReconciler{}
+var _ manager.LeaderElectionRunnable = &ConfigReconciler{}
+
+func (r *ConfigReconciler) NeedLeaderElection() bool {
+	return true
+}
+
+func (r *ConfigReconciler) Start(ctx context.Context) error {
+	if r.LastKnownGood == nil {
+		r.LastKnownGood = noLastKnownGoodStore{}
+	}
+	r.lastSuccessfulRead = time.Now()
+	// Only the leader writes to the backend, acknowledge the weight applied
+	// before being elected
+	if store := r.Store.Get(); store.CurrentWeight == store.DesiredWeight && !r.DryRun {
+		r.pendingAck = &StoreConfig{
+			DesiredWeight: store.DesiredWeight,
+			CurrentWeight: store.CurrentWeight,
+			Version:       store.Version,
+		}
+	}
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		tickCtx, cancel := context.WithTimeout(ctx, r.Interval)
+		r.tick(tickCtx)
+		cancel()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *ConfigReconciler) tick(ctx context.Context) {
+	// Expose the weight even when no ingress is reconciled
+	defer func() {
+		storeMetrics.record(r.Store.Get())
+	}()
+	if r.pendingAck != nil {
+		// The weight was applied but the backend was not told about it, try again
+		err := r.Backend.OnWeightUpdate(ctx, *r.pendingAck)
+		var writeErr *BackendWriteError
+		switch {
+		case errors.As(err, &writeErr) && writeErr.Reason == WriteErrorConditionalCheckFailed:
+			// The desired weight changed since it was applied, it is handled by the next read
+			r.Log.Info("Dropping outdated weight acknowledgement", "weight", r.pendingAck.CurrentWeight)
+			r.pendingAck = nil
+		case err != nil:
+			r.Log.Error(err, "Error acknowledging ingress weight on store backend")
+		default:
+			r.pendingAck = nil
+		}
+	}
+	err := r.doReconcile(ctx)
+	var unavailable *BackendUnavailableError
+	if errors.As(err, &unavailable) {
+		r.Log.Error(err, "Error reading ingress weight from store backend")
+		before := r.Store.Get()
+		hosts, applied, err := r.OutagePolicy.apply(ctx, r.Store, r.Cache, r.Events, r.Filter, r.Propagation, time.Since(r.lastSuccessfulRead))
+		if err != nil {
+			r.Log.Error(err, "Error applying the backend outage fallback weight")
+			r.audit(ctx, AuditEntry{
+				OldWeight:     before.CurrentWeight,
+				NewWeight:     before.CurrentWeight,
+				DesiredWeight: r.OutagePolicy.FallbackWeight,
+				Source:        AuditSourceOutageFallback,
+				AffectedHosts: hosts,
+				Result:        AuditResultEnqueueFailed,
+				Error:         err.Error(),
+			})
+		}
+		if applied {
+			r.audit(ctx, AuditEntry{
+				OldWeight:     before.CurrentWeight,
+				NewWeight:     r.OutagePolicy.FallbackWeight,
+				DesiredWeight: r.OutagePolicy.FallbackWeight,
+				Source:        AuditSourceOutageFallback,
+				AffectedHosts: hosts,
+				Result:        AuditResultApplied,
+			})
+			r.Log.Info("Backend unavailable for too long, applied the fallback weight", "weight", r.Store.Get().CurrentWeight, "lastSuccessfulRead", r.lastSuccessfulRead)
+		}
+		return
+	}
+	r.lastSuccessfulRead = time.Now()
+	if err != nil {
+		r.Log.Error(err, "Error updating ingress weight on store backend")
+	}
+	if store := r.Store.Get(); store != r.saved && !r.DryRun {
+		err = r.LastKnownGood.Save(ctx, store)
+		if err != nil {
+			r.Log.Error(err, "Error saving the last known good weight configuration")
+		} else {
+			r.saved = store
+		}
+	}
+}
+
+// audit records entry unless it repeats the previous one
+func (r *ConfigReconciler) audit(ctx context.Context, entry AuditEntry) {
+	if r.Audit == nil {
+		return
+	}
+	if r.audited != nil && sameAuditEntry(*r.audited, entry) {
+		return
+	}
+	r.audited = &entry
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if err := r.Audit.Record(ctx, entry); err != nil {
+		r.Log.Error(err, "Error recording the weight change audit entry")
+	}
+}
+
+func sameAuditEntry(a, b AuditEntry) bool {
+	a.Time, b.Time = time.Time{}, time.Time{}
+	return a == b
+}
+
+func (r *ConfigReconciler) doReconcile(ctx context.Context) error {
+	desired, err := r.Backend.ReadWeight(ctx)
 	if err != nil {
+		backendMetrics.ReadErrors.Inc()
+		return &BackendUnavailableError{err: err}
+	}
+	backendMetrics.readSucceeded()
+	backendMetrics.FallbackActive.Set(0)
+	if err := r.applyRecordTTL(ctx, desired.RecordTTL); err != nil {
 		return err
 	}
-
-	if Store.CurrentWeight != desiredWeight {
-		Store.DesiredWeight = desiredWeight
-		err = enqueueReconcileEvents(events, c)
+	current := r.Store.Get()
+	if current.CurrentWeight != desired.DesiredWeight {
+		entry := AuditEntry{
+			OldWeight:     current.CurrentWeight,
+			NewWeight:     current.CurrentWeight,
+			DesiredWeight: desired.DesiredWeight,
+			Version:       desired.Version,
+			Source:        r.Source,
+			ChangedBy:     desired.ChangedBy,
+		}
+		nextWeight, err := r.Limits.nextWeight(current.CurrentWeight, desired.DesiredWeight, desired.Force)
+		if err != nil {
+			weightChangeMetrics.Rejected.Inc()
+			entry.Result = AuditResultRejected
+			entry.Error = err.Error()
+			r.audit(ctx, entry)
+			return err
+		}
+		entry.Result = AuditResultApplied
+		if nextWeight != desired.DesiredWeight {
+			weightChangeMetrics.Limited.Inc()
+			entry.Result = AuditResultLimited
+		}
+		r.Store.Update(func(store *StoreConfig) {
+			store.DesiredWeight = nextWeight
+			store.Version = desired.Version
+			store.ChangedBy = desired.ChangedBy
+		})
+		entry.AffectedHosts, err = enqueueReconcileEvents(ctx, r.Events, r.Cache, r.Filter, r.Propagation, nextWeight)
 		if err != nil {
+			entry.Result = AuditResultEnqueueFailed
+			entry.Error = err.Error()
+			r.audit(ctx, entry)
 			return err
 		}
-		Store.CurrentWeight = desiredWeight
-		err = backend.OnWeightUpdate(Store)
+		r.Store.Update(func(store *StoreConfig) {
+			store.CurrentWeight = nextWeight
+		})
+		entry.NewWeight = nextWeight
+		ack := StoreConfig{
+			DesiredWeight: desired.DesiredWeight,
+			CurrentWeight: nextWeight,
+			Version:       desired.Version,
+		}
+		if r.DryRun {
+			r.Log.Info("The weight would be acknowledged", "DryRun", true, "weight", nextWeight, "version", desired.Version)
+			r.audit(ctx, entry)
+			return nil
+		}
+		err = r.Backend.OnWeightUpdate(ctx, ack)
+		var writeErr *BackendWriteError
+		if errors.As(err, &writeErr) {
+			r.pendingAck = &ack
+		}
+		if err != nil {
+			entry.Result = AuditResultAcknowledgementFailed
+			entry.Error = err.Error()
+		}
+		r.audit(ctx, entry)
 		if err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
-func ConfigReconcileLoop(backend TrafficWeightBackend, c cache.Cache, seconds time.Duration, log log.Logger, events chan event.GenericEvent) {
-	ticker := time.NewTicker(seconds)
-	quit := make(chan struct{})
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				err := doReconcile(backend, c, events)
-				if err != nil {
-					log.Error(err, "Error updating ingress weight on store backend")
-				}
-			case <-quit:
-				ticker.Stop()
-				return
-			}
+// applyRecordTTL stores the record TTL override read from the backend, when
+// changed, and triggers the reconciliation of all the ingresses with it.
+// Invalid overrides are ignored, so they do not block the weight changes.
+func (r *ConfigReconciler) applyRecordTTL(ctx context.Context, ttl int64) error {
+	if err := ValidateRecordTTL(ttl); err != nil {
+		if r.rejectedTTL == nil || *r.rejectedTTL != ttl {
+			r.Log.Error(err, "Ignoring the record TTL override of the backend")
+			r.rejectedTTL = &ttl
 		}
-	}()
+		storeMetrics.RecordTTLOverrideRejected.Set(1)
+		return nil
+	}
+	r.rejectedTTL = nil
+	storeMetrics.RecordTTLOverrideRejected.Set(0)
+	if r.Store.Get().RecordTTL == ttl {
+		return nil
+	}
+	r.Log.Info("Applying the record TTL override of the backend", "ttl", ttl)
+	config := r.Store.Update(func(store *StoreConfig) {
+		store.RecordTTL = ttl
+	})
+	_, err := enqueueReconcileEvents(ctx, r.Events, r.Cache, r.Filter, r.Propagation, config.CurrentWeight)
+	return err
 }
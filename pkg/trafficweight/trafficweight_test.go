@@ -3,13 +3,18 @@ package trafficweight
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	netv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -60,12 +65,14 @@ func (c *fakeCache) IndexField(ctx context.Context, obj client.Object, field str
 }
 
 func TestNewBackend(t *testing.T) {
-	backend, err := NewBackend("fake", "foo", "", "", "a-healthy-check-id", zap.New(zap.UseDevMode(true)))
+	t.Parallel()
+	store := NewWeightStore(StoreConfig{})
+	backend, err := NewBackend(context.Background(), "fake", "foo", "", "", "a-healthy-check-id", store, zap.New(zap.UseDevMode(true)), false)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, backend)
 
-	backend, err = NewBackend("foolanito", "foo", "", "", "a-healthy-check-id", zap.New(zap.UseDevMode(true)))
+	backend, err = NewBackend(context.Background(), "foolanito", "foo", "", "", "a-healthy-check-id", store, zap.New(zap.UseDevMode(true)), false)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, backend)
@@ -97,9 +104,12 @@ func Test_enqueueReconcileEvents(t *testing.T) {
 
 	cache.ing = &inglist
 
-	err := enqueueReconcileEvents(events, cache)
+	propagation := NewPropagationTracker()
+	hosts, err := enqueueReconcileEvents(context.Background(), events, cache, nil, propagation, 50)
 
 	assert.Nil(t, err)
+	assert.Equal(t, 1, hosts)
+	assert.Equal(t, 1, propagation.Pending())
 
 	err = nil
 
@@ -113,6 +123,7 @@ func Test_enqueueReconcileEvents(t *testing.T) {
 			inglist.Items[0].GetName(),
 			myEvent.Object.GetName(),
 		)
+		assert.Equal(t, inglist.Items[0].Spec, myEvent.Object.(*netv1.Ingress).Spec, "the predicates filter on the spec")
 	default:
 		err = assert.AnError
 	}
@@ -127,18 +138,45 @@ type testBackend struct {
 	weight  int
 	updated int
 	err     error
+	readErr error
+	forced  bool
+	ttl     int64
+	acked   StoreConfig
 }
 
-func (b *testBackend) ReadWeight() (int, error) {
-	return b.weight, nil
+func (b *testBackend) ReadWeight(context.Context) (StoreConfig, error) {
+	return StoreConfig{DesiredWeight: b.weight, Force: b.forced, RecordTTL: b.ttl}, b.readErr
 }
 
-func (b *testBackend) OnWeightUpdate(StoreConfig) error {
+func (b *testBackend) OnWeightUpdate(ctx context.Context, store StoreConfig) error {
 	b.updated++
+	if b.err == nil {
+		b.acked = store
+	}
 	return b.err
 }
 
+func Test_enqueueReconcileEventsWithFilter(t *testing.T) {
+	events := make(chan event.GenericEvent, 2)
+	cache := &fakeCache{ing: &netv1.IngressList{Items: []netv1.Ingress{
+		{ObjectMeta: metav1.ObjectMeta{Name: "handled", Namespace: "bar"}, Spec: netv1.IngressSpec{Rules: []netv1.IngressRule{{Host: "handled.cheap.io"}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "filtered", Namespace: "bar"}, Spec: netv1.IngressSpec{Rules: []netv1.IngressRule{{Host: "filtered.cheap.io"}}}},
+	}}}
+	filter := func(ctx context.Context, ingress *netv1.Ingress) bool { return ingress.Name != "filtered" }
+
+	propagation := NewPropagationTracker()
+	hosts, err := enqueueReconcileEvents(context.Background(), events, cache, filter, propagation, 50)
+	require.NoError(t, err)
+	assert.Equal(t, 1, hosts)
+	require.Len(t, events, 1)
+	assert.Equal(t, "handled", (<-events).Object.GetName())
+
+	propagation.Done(types.NamespacedName{Namespace: "bar", Name: "handled"}, 50)
+	assert.Equal(t, 0, propagation.Pending(), "the filtered out ingresses are not waited for")
+}
+
 func Test_doReconcile(t *testing.T) {
+	t.Parallel()
 	events := make(chan event.GenericEvent, 1)
 	cache := &fakeCache{}
 	cache.ing = &netv1.IngressList{}
@@ -148,15 +186,16 @@ func Test_doReconcile(t *testing.T) {
 		updated: 0,
 		err:     nil,
 	}
+	reconciler := &ConfigReconciler{Backend: fake, Store: NewWeightStore(StoreConfig{}), Cache: cache, Events: events}
 
 	// There was an weight change and backend was updated
-	err := doReconcile(fake, cache, events)
+	err := reconciler.doReconcile(context.Background())
 
 	assert.Nil(t, err)
 	assert.Equal(t, fake.updated, 1)
 
 	// Nothing changes, there should not be further updates
-	err = doReconcile(fake, cache, events)
+	err = reconciler.doReconcile(context.Background())
 
 	assert.Nil(t, err)
 	assert.Equal(t, fake.updated, 1) // There were no event updates
@@ -164,7 +203,224 @@ func Test_doReconcile(t *testing.T) {
 	// If weight changes and the update event is not properly handled
 	fake.err = assert.AnError
 	fake.weight = 250
-	err = doReconcile(fake, cache, events)
+	err = reconciler.doReconcile(context.Background())
 
 	assert.NotNil(t, err)
 }
+
+func Test_doReconcileWithLimits(t *testing.T) {
+	t.Parallel()
+	events := make(chan event.GenericEvent, 1)
+	cache := &fakeCache{}
+	cache.ing = &netv1.IngressList{}
+
+	t.Run("changes bigger than the interval limit are applied in steps", func(t *testing.T) {
+		store := NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100})
+		fake := &testBackend{weight: 70}
+		reconciler := &ConfigReconciler{Backend: fake, Store: store, Cache: cache, Events: events, Limits: WeightChangeLimits{MaxDeltaPerInterval: 20}}
+
+		assert.NoError(t, reconciler.doReconcile(context.Background()))
+		assert.Equal(t, 80, store.Get().CurrentWeight)
+		assert.Equal(t, 80, store.Get().DesiredWeight)
+		assert.Equal(t, StoreConfig{DesiredWeight: 70, CurrentWeight: 80}, fake.acked)
+
+		assert.NoError(t, reconciler.doReconcile(context.Background()))
+		assert.Equal(t, 70, store.Get().CurrentWeight)
+		assert.Equal(t, 70, store.Get().DesiredWeight)
+
+		assert.NoError(t, reconciler.doReconcile(context.Background()))
+		assert.Equal(t, 70, store.Get().CurrentWeight)
+		assert.Equal(t, 2, fake.updated)
+	})
+
+	t.Run("changes bigger than the change limit are rejected", func(t *testing.T) {
+		store := NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100})
+		fake := &testBackend{weight: 0}
+		reconciler := &ConfigReconciler{Backend: fake, Store: store, Cache: cache, Events: events, Limits: WeightChangeLimits{MaxDeltaPerChange: 50, MaxDeltaPerInterval: 20}}
+
+		err := reconciler.doReconcile(context.Background())
+		assert.Error(t, err)
+		assert.IsType(t, &WeightChangeRejectedError{}, err)
+		assert.Equal(t, 100, store.Get().CurrentWeight)
+		assert.Equal(t, 100, store.Get().DesiredWeight)
+		assert.Equal(t, 0, fake.updated)
+	})
+
+	t.Run("forced changes bypass the limits", func(t *testing.T) {
+		store := NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100})
+		fake := &testBackend{weight: 0, forced: true}
+		reconciler := &ConfigReconciler{Backend: fake, Store: store, Cache: cache, Events: events, Limits: WeightChangeLimits{MaxDeltaPerChange: 50, MaxDeltaPerInterval: 20}}
+
+		assert.NoError(t, reconciler.doReconcile(context.Background()))
+		assert.Equal(t, 0, store.Get().CurrentWeight)
+		assert.Equal(t, 0, store.Get().DesiredWeight)
+		assert.Equal(t, 1, fake.updated)
+	})
+}
+
+func Test_doReconcileRecordTTL(t *testing.T) {
+	t.Parallel()
+	events := make(chan event.GenericEvent, 1)
+	cache := &fakeCache{}
+	cache.ing = &netv1.IngressList{Items: []netv1.Ingress{
+		{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "ns"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "filtered", Namespace: "ns"}},
+	}}
+	filter := func(ctx context.Context, ingress *netv1.Ingress) bool { return ingress.Name != "filtered" }
+	propagation := NewPropagationTracker()
+	fake := &testBackend{weight: 50, ttl: 30}
+	store := NewWeightStore(StoreConfig{DesiredWeight: 50, CurrentWeight: 50})
+	rejections := 0
+	logger := funcr.New(func(prefix, args string) {
+		if strings.Contains(args, "Ignoring the record TTL override") {
+			rejections++
+		}
+	}, funcr.Options{})
+	reconciler := &ConfigReconciler{Backend: fake, Store: store, Cache: cache, Events: events, Filter: filter, Propagation: propagation, Log: logger}
+
+	require.NoError(t, reconciler.doReconcile(context.Background()))
+	assert.Equal(t, int64(30), store.Get().RecordTTL)
+	assert.Equal(t, "app", (<-events).Object.GetName(), "the ingresses are reconciled with the new ttl")
+	assert.Empty(t, events, "the filtered out ingresses are not reconciled")
+	assert.Equal(t, 1, propagation.Pending())
+	propagation.Done(types.NamespacedName{Namespace: "ns", Name: "app"}, 50)
+	assert.Equal(t, 0, propagation.Pending(), "the propagation of the ttl is tracked")
+	assert.Equal(t, 0, fake.updated, "the weight is not acknowledged again")
+
+	fake.ttl = -1
+	for i := 0; i < 3; i++ {
+		require.NoError(t, reconciler.doReconcile(context.Background()))
+	}
+	assert.Equal(t, int64(30), store.Get().RecordTTL, "invalid ttls are ignored")
+	assert.Empty(t, events)
+	assert.Equal(t, 1, rejections, "an invalid ttl is only logged once")
+	assert.Equal(t, 1.0, metricValue(t, storeMetrics.RecordTTLOverrideRejected).GetGauge().GetValue())
+
+	fake.ttl = 0
+	require.NoError(t, reconciler.doReconcile(context.Background()))
+	assert.Equal(t, int64(0), store.Get().RecordTTL, "the override is removed")
+	assert.Len(t, events, 1)
+	assert.Equal(t, 0.0, metricValue(t, storeMetrics.RecordTTLOverrideRejected).GetGauge().GetValue())
+
+	fake.ttl = -1
+	require.NoError(t, reconciler.doReconcile(context.Background()))
+	assert.Equal(t, 2, rejections, "the ttl is logged again once rejected anew")
+}
+
+func TestOutagePolicy(t *testing.T) {
+	t.Parallel()
+	events := make(chan event.GenericEvent, 1)
+	cache := &fakeCache{}
+	cache.ing = &netv1.IngressList{}
+
+	t.Run("read errors are reported as backend unavailable", func(t *testing.T) {
+		store := NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100})
+		fake := &testBackend{readErr: assert.AnError}
+		reconciler := &ConfigReconciler{Backend: fake, Store: store, Cache: cache, Events: events}
+		err := reconciler.doReconcile(context.Background())
+		var unavailable *BackendUnavailableError
+		assert.ErrorAs(t, err, &unavailable)
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Equal(t, 100, store.Get().CurrentWeight)
+	})
+
+	t.Run("keep-last policy keeps the current weight", func(t *testing.T) {
+		store := NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100})
+		policy := OutagePolicy{Mode: OutagePolicyKeepLast, GracePeriod: time.Minute, FallbackWeight: 0}
+		_, applied, err := policy.apply(context.Background(), store, cache, events, nil, nil, time.Hour)
+		assert.NoError(t, err)
+		assert.False(t, applied)
+		assert.Equal(t, 100, store.Get().CurrentWeight)
+		assert.Equal(t, 100, store.Get().DesiredWeight)
+	})
+
+	t.Run("fallback policy waits for the grace period", func(t *testing.T) {
+		store := NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100})
+		policy := OutagePolicy{Mode: OutagePolicyFallback, GracePeriod: time.Minute, FallbackWeight: 10}
+		_, applied, err := policy.apply(context.Background(), store, cache, events, nil, nil, time.Second)
+		assert.NoError(t, err)
+		assert.False(t, applied)
+		assert.Equal(t, 100, store.Get().CurrentWeight)
+
+		_, applied, err = policy.apply(context.Background(), store, cache, events, nil, nil, time.Hour)
+		assert.NoError(t, err)
+		assert.True(t, applied)
+		assert.Equal(t, 10, store.Get().CurrentWeight)
+		assert.Equal(t, 10, store.Get().DesiredWeight)
+
+		_, applied, err = policy.apply(context.Background(), store, cache, events, nil, nil, time.Hour)
+		assert.NoError(t, err)
+		assert.False(t, applied)
+	})
+
+	t.Run("unknown policies are rejected", func(t *testing.T) {
+		_, err := ParseOutagePolicyMode("panic")
+		assert.Error(t, err)
+		mode, err := ParseOutagePolicyMode("fallback")
+		assert.NoError(t, err)
+		assert.Equal(t, OutagePolicyFallback, mode)
+	})
+}
+
+func TestConfigReconcilerRetriesFailedAcknowledgements(t *testing.T) {
+	t.Parallel()
+	events := make(chan event.GenericEvent, 1)
+	cache := &fakeCache{}
+	cache.ing = &netv1.IngressList{}
+
+	store := NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100})
+	fake := &testBackend{weight: 50, err: &BackendWriteError{Reason: WriteErrorThrottled}}
+	reconciler := &ConfigReconciler{Backend: fake, Store: store, Cache: cache, Events: events, LastKnownGood: noLastKnownGoodStore{}, Log: testLogger}
+
+	reconciler.tick(context.Background())
+	assert.Equal(t, 50, store.Get().CurrentWeight)
+	assert.Equal(t, 1, fake.updated)
+	assert.NotNil(t, reconciler.pendingAck)
+
+	fake.err = nil
+	reconciler.tick(context.Background())
+	assert.Equal(t, 2, fake.updated)
+	assert.Nil(t, reconciler.pendingAck)
+	assert.Equal(t, StoreConfig{DesiredWeight: 50, CurrentWeight: 50}, fake.acked)
+}
+
+func TestConfigReconcilerDryRun(t *testing.T) {
+	t.Parallel()
+	events := make(chan event.GenericEvent, 1)
+	cache := &fakeCache{}
+	cache.ing = &netv1.IngressList{}
+
+	store := NewWeightStore(StoreConfig{DesiredWeight: 100, CurrentWeight: 100})
+	fake := &testBackend{weight: 50}
+	audit := &recordingAuditSink{}
+	reconciler := &ConfigReconciler{Backend: fake, Store: store, Cache: cache, Events: events, Audit: audit, DryRun: true, Log: testLogger}
+
+	reconciler.tick(context.Background())
+	assert.Equal(t, 50, store.Get().CurrentWeight)
+	assert.Equal(t, 0, fake.updated)
+	assert.Nil(t, reconciler.pendingAck)
+	assert.Equal(t, StoreConfig{}, reconciler.saved)
+	require.Len(t, audit.entries, 1)
+	assert.Equal(t, AuditResultApplied, audit.entries[0].Result)
+}
+
+func TestConfigReconcilerStopsWithTheContext(t *testing.T) {
+	t.Parallel()
+	cache := &fakeCache{}
+	cache.ing = &netv1.IngressList{}
+	reconciler := &ConfigReconciler{Backend: &testBackend{}, Store: NewWeightStore(StoreConfig{}), Cache: cache, Events: make(chan event.GenericEvent), Interval: time.Millisecond, Log: testLogger}
+	assert.True(t, reconciler.NeedLeaderElection())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- reconciler.Start(ctx)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reconcile loop did not stop with its context")
+	}
+}
@@ -0,0 +1,46 @@
+package trafficweight
+
+import (
+	"fmt"
+	"math"
+)
+
+// IngressWeight returns the DNS weight of an ingress annotated with trafficWeight
+// in a cluster with clusterWeight. Both weights are percentages, capped to 100.
+func IngressWeight(clusterWeight int, trafficWeight float64) (uint, error) {
+	backendPercentage := float64(clusterWeight)
+	if backendPercentage < 0 {
+		return 0, fmt.Errorf("Cannot handle negative backend weights")
+	}
+	if backendPercentage > 100 {
+		backendPercentage = float64(100.0)
+	}
+	backendPercentage = float64(backendPercentage) / float64(100)
+	if trafficWeight < 0 {
+		return 0, fmt.Errorf("Cannot handle negative traffic weights")
+	}
+	if trafficWeight > 100 {
+		trafficWeight = float64(100.0)
+	}
+	calculatedWeight := backendPercentage * trafficWeight
+	return uint(math.Ceil(calculatedWeight)), nil
+}
+
+// TrafficSplit returns the percentage of the traffic each record receives
+// given their weights. As Route53 does, records share the traffic evenly when
+// all their weights are 0.
+func TrafficSplit(weights map[string]uint) map[string]float64 {
+	split := make(map[string]float64, len(weights))
+	total := uint(0)
+	for _, weight := range weights {
+		total += weight
+	}
+	for name, weight := range weights {
+		if total == 0 {
+			split[name] = 100 / float64(len(weights))
+			continue
+		}
+		split[name] = 100 * float64(weight) / float64(total)
+	}
+	return split
+}
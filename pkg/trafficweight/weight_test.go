@@ -0,0 +1,32 @@
+package trafficweight
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIngressWeight(t *testing.T) {
+	weight, err := IngressWeight(50, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(5), weight)
+
+	weight, err = IngressWeight(120, 150)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(100), weight)
+
+	weight, err = IngressWeight(3, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), weight)
+
+	_, err = IngressWeight(-1, 50)
+	assert.Error(t, err)
+	_, err = IngressWeight(50, -1)
+	assert.Error(t, err)
+}
+
+func TestTrafficSplit(t *testing.T) {
+	assert.Equal(t, map[string]float64{"a": 75, "b": 25, "c": 0}, TrafficSplit(map[string]uint{"a": 75, "b": 25, "c": 0}))
+	assert.Equal(t, map[string]float64{"a": 50, "b": 50}, TrafficSplit(map[string]uint{"a": 0, "b": 0}))
+	assert.Empty(t, TrafficSplit(map[string]uint{}))
+}
@@ -0,0 +1,82 @@
+package trafficweight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotDrained is returned when restoring a cluster that was not drained
+var ErrNotDrained = errors.New("the cluster is not drained")
+
+// ErrClusterNotFound is returned when changing the weight of a cluster missing in the backend
+var ErrClusterNotFound = errors.New("the cluster is not found")
+
+// ErrStaleVersion is returned when acknowledging a weight changed since it was read
+var ErrStaleVersion = errors.New("the desired weight changed since it was read")
+
+// WeightChange describes who requests a DesiredWeight change and how
+type WeightChange struct {
+	// ChangedBy identifies who changed the weight in the audit log
+	ChangedBy string
+	// Force allows the change to exceed the configured WeightChangeLimits
+	Force bool
+}
+
+// WeightWriter is implemented by the backends allowing to change the DesiredWeight
+type WeightWriter interface {
+	// SetDesiredWeight sets the DesiredWeight of the cluster and increments its Version
+	SetDesiredWeight(ctx context.Context, weight int, change WeightChange) error
+	// Drain sets the DesiredWeight to 0, remembering the previous one for Restore.
+	// Draining a drained cluster keeps the weight remembered by the first drain.
+	Drain(ctx context.Context, change WeightChange) error
+	// Restore sets the DesiredWeight back to the one before Drain.
+	// It returns ErrNotDrained when the cluster is not drained.
+	Restore(ctx context.Context, change WeightChange) error
+}
+
+// ClusterBackend reads and changes the weight of a cluster
+type ClusterBackend interface {
+	TrafficWeightBackend
+	WeightWriter
+}
+
+// WaitForWeight polls backend every interval until the CurrentWeight matches the DesiredWeight
+func WaitForWeight(ctx context.Context, backend TrafficWeightBackend, interval time.Duration) (StoreConfig, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		config, err := backend.ReadWeight(ctx)
+		if err == nil && config.CurrentWeight == config.DesiredWeight {
+			return config, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if err != nil {
+				return config, fmt.Errorf("%w: %w", ctx.Err(), err)
+			}
+			return config, ctx.Err()
+		}
+	}
+}
+
+// MaxRecordTTL is the largest record TTL accepted by Route53, in seconds
+const MaxRecordTTL = 1<<31 - 1
+
+// ValidateRecordTTL checks that ttl is a valid record TTL in seconds, 0 meaning not set
+func ValidateRecordTTL(ttl int64) error {
+	if ttl < 0 || ttl > MaxRecordTTL {
+		return fmt.Errorf("invalid record ttl %d, record TTLs must be between 0 and %d seconds", ttl, MaxRecordTTL)
+	}
+	return nil
+}
+
+// ValidateWeight checks that weight is a valid cluster weight, a percentage
+func ValidateWeight(weight int) error {
+	if weight < 0 || weight > 100 {
+		return fmt.Errorf("invalid weight %d, cluster weights must be between 0 and 100", weight)
+	}
+	return nil
+}
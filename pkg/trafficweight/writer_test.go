@@ -0,0 +1,41 @@
+package trafficweight
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type convergingBackend struct {
+	testBackend
+	reads int
+}
+
+func (b *convergingBackend) ReadWeight(context.Context) (StoreConfig, error) {
+	b.reads++
+	if b.reads < 3 {
+		return StoreConfig{DesiredWeight: 0, CurrentWeight: 20}, nil
+	}
+	return StoreConfig{DesiredWeight: 0, CurrentWeight: 0}, nil
+}
+
+func TestWaitForWeight(t *testing.T) {
+	t.Run("returns once the weight is applied", func(t *testing.T) {
+		backend := &convergingBackend{}
+		config, err := WaitForWeight(context.Background(), backend, time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, 0, config.CurrentWeight)
+		assert.Equal(t, 3, backend.reads)
+	})
+
+	t.Run("stops with the context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := WaitForWeight(ctx, &testBackend{weight: 50, readErr: assert.AnError}, time.Millisecond)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorIs(t, err, assert.AnError)
+	})
+}